@@ -25,7 +25,7 @@ type ClaudeRequest struct {
 	MaxTokens   int             `json:"max_tokens"`
 	Messages    []ClaudeMessage `json:"messages"`
 	System      string          `json:"system,omitempty"`
-	Temperature float64         `json:"temperature,omitempty"`
+	Temperature *float64        `json:"temperature,omitempty"`
 	Stream      bool            `json:"stream,omitempty"`
 }
 
@@ -66,13 +66,22 @@ func NewClaudeClient(apiKey string) *ClaudeClient {
 }
 
 // SendMessage sends a message to Claude and returns the response
-func (c *ClaudeClient) SendMessage(model string, messages []ClaudeMessage, systemPrompt string) (*ClaudeResponse, error) {
+func (c *ClaudeClient) SendMessage(model string, messages []ClaudeMessage, systemPrompt string, options GenerationOptions) (*ClaudeResponse, error) {
+	maxTokens := options.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 4000
+	}
+	temperature := 0.7
+	if options.Temperature != nil {
+		temperature = *options.Temperature
+	}
+
 	request := ClaudeRequest{
 		Model:       model,
-		MaxTokens:   4000,
+		MaxTokens:   maxTokens,
 		Messages:    messages,
 		System:      systemPrompt,
-		Temperature: 0.7,
+		Temperature: &temperature,
 		Stream:      false,
 	}
 
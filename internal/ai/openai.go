@@ -3,6 +3,7 @@ package ai
 import (
 	"context"
 	"fmt"
+	"math"
 
 	openai "github.com/sashabaranov/go-openai"
 )
@@ -44,15 +45,23 @@ func GetModelForRequest(modelName string) string {
 }
 
 // SendToOpenAI sends a prompt to OpenAI and returns the response.
-func SendToOpenAI(client *openai.Client, model string, messages []openai.ChatCompletionMessage) (openai.ChatCompletionResponse, error) {
+func SendToOpenAI(client *openai.Client, model string, messages []openai.ChatCompletionMessage, options GenerationOptions) (openai.ChatCompletionResponse, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// The client omits a zero temperature, so ask for the smallest one it sends
+	var temperature float32
+	if options.Temperature != nil {
+		temperature = max(float32(*options.Temperature), math.SmallestNonzeroFloat32)
+	}
+
 	response, err := client.CreateChatCompletion(
 		ctx,
 		openai.ChatCompletionRequest{
-			Model:    GetModelForRequest(model),
-			Messages: messages,
+			Model:       GetModelForRequest(model),
+			Messages:    messages,
+			Temperature: temperature,
+			MaxTokens:   options.MaxTokens,
 		},
 	)
 	if err != nil {
@@ -31,6 +31,13 @@ type UnifiedResponse struct {
 	Provider         Provider
 }
 
+// GenerationOptions tunes how a response is generated. Zero values use the
+// provider defaults; a nil Temperature is unset, so 0 can be asked for.
+type GenerationOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+}
+
 // UnifiedClient wraps both OpenAI and Claude clients
 type UnifiedClient struct {
 	OpenAIClient *openai.Client
@@ -98,22 +105,39 @@ func (c *UnifiedClient) GetAvailableModels() []string {
 	return models
 }
 
+// CheapestModel returns the least expensive configured model, for background
+// tasks such as naming conversations, or "" if no provider is configured.
+func (c *UnifiedClient) CheapestModel() string {
+	if c.OpenAIClient != nil {
+		return "gpt-4o-mini"
+	}
+	if c.ClaudeClient != nil {
+		return "claude-3-haiku-20240307"
+	}
+	return ""
+}
+
 // SendMessage sends a message using the appropriate provider
 func (c *UnifiedClient) SendMessage(model string, messages []UnifiedMessage, systemPrompt string) (*UnifiedResponse, error) {
+	return c.SendMessageWithOptions(model, messages, systemPrompt, GenerationOptions{})
+}
+
+// SendMessageWithOptions sends a message using the appropriate provider and generation options
+func (c *UnifiedClient) SendMessageWithOptions(model string, messages []UnifiedMessage, systemPrompt string, options GenerationOptions) (*UnifiedResponse, error) {
 	provider := c.GetProviderForModel(model)
 	
 	switch provider {
 	case ProviderOpenAI:
-		return c.sendToOpenAI(model, messages, systemPrompt)
+		return c.sendToOpenAI(model, messages, systemPrompt, options)
 	case ProviderClaude:
-		return c.sendToClaude(model, messages, systemPrompt)
+		return c.sendToClaude(model, messages, systemPrompt, options)
 	default:
 		return nil, fmt.Errorf("model %s is not supported or provider not configured", model)
 	}
 }
 
 // sendToOpenAI handles OpenAI API calls
-func (c *UnifiedClient) sendToOpenAI(model string, messages []UnifiedMessage, systemPrompt string) (*UnifiedResponse, error) {
+func (c *UnifiedClient) sendToOpenAI(model string, messages []UnifiedMessage, systemPrompt string, options GenerationOptions) (*UnifiedResponse, error) {
 	if c.OpenAIClient == nil {
 		return nil, fmt.Errorf("OpenAI client not configured")
 	}
@@ -144,7 +168,7 @@ func (c *UnifiedClient) sendToOpenAI(model string, messages []UnifiedMessage, sy
 		})
 	}
 	
-	response, err := SendToOpenAI(c.OpenAIClient, model, openaiMessages)
+	response, err := SendToOpenAI(c.OpenAIClient, model, openaiMessages, options)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +187,7 @@ func (c *UnifiedClient) sendToOpenAI(model string, messages []UnifiedMessage, sy
 }
 
 // sendToClaude handles Claude API calls
-func (c *UnifiedClient) sendToClaude(model string, messages []UnifiedMessage, systemPrompt string) (*UnifiedResponse, error) {
+func (c *UnifiedClient) sendToClaude(model string, messages []UnifiedMessage, systemPrompt string, options GenerationOptions) (*UnifiedResponse, error) {
 	if c.ClaudeClient == nil {
 		return nil, fmt.Errorf("Claude client not configured")
 	}
@@ -179,7 +203,7 @@ func (c *UnifiedClient) sendToClaude(model string, messages []UnifiedMessage, sy
 		}
 	}
 	
-	response, err := c.ClaudeClient.SendMessage(model, claudeMessages, systemPrompt)
+	response, err := c.ClaudeClient.SendMessage(model, claudeMessages, systemPrompt, options)
 	if err != nil {
 		return nil, err
 	}
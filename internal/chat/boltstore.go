@@ -0,0 +1,633 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The database lives next to the chat files; without a .json suffix ListChats skips it
+const chatDatabaseFile = "chats.db"
+
+var (
+	bucketMeta          = []byte("meta")
+	bucketChats         = []byte("chats")          // id -> ChatHistory
+	bucketChatSummaries = []byte("chat_summaries") // id -> ChatSummary
+	bucketChatsByUpdate = []byte("chats_by_updated")
+	bucketChatsByModel  = []byte("chats_by_model")
+	bucketChatsByTag    = []byte("chats_by_tag")
+	bucketTrees         = []byte("trees") // id -> ConversationTree
+	bucketUsage         = []byte("usage") // sequence -> UsageRecord
+	bucketTrash         = []byte("trash") // id -> ChatHistory
+
+	keySchemaVersion = []byte("schema_version")
+)
+
+// boltMigrations bring a database up to the current schema. Entry i upgrades
+// version i to version i+1; add new steps to the end and never edit old ones.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// 1: chats with summary, date, model and tag indexes; trees; usage
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			bucketChats, bucketChatSummaries, bucketChatsByUpdate, bucketChatsByModel,
+			bucketChatsByTag, bucketTrees, bucketUsage,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: trash for deleted chats
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketTrash)
+		return err
+	},
+}
+
+// BoltStore keeps chats, trees and usage in a single embedded bbolt database.
+// Listing and filtering chats reads small summary records and lookup indexes
+// rather than every conversation.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// boltTx is the Store view of one database transaction.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// GetChatDatabasePath returns the default path of the chat database.
+func GetChatDatabasePath() (string, error) {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(historyDir, chatDatabaseFile), nil
+}
+
+// OpenBoltStore opens the database at path, creating it if needed, and
+// migrates it to the current schema. An empty path uses GetChatDatabasePath.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if path == "" {
+		defaultPath, err := GetChatDatabasePath()
+		if err != nil {
+			return nil, err
+		}
+		path = defaultPath
+	}
+
+	// Only one process may hold the database, so give up instead of hanging
+	db, err := bolt.Open(path, filePermissions, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat database %s (is lil_guy already running?): %w", path, err)
+	}
+
+	if err := migrateBolt(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// migrateBolt runs the migrations the database hasn't seen yet in one transaction.
+func migrateBolt(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata bucket: %w", err)
+		}
+
+		version := 0
+		if value := meta.Get(keySchemaVersion); len(value) == 8 {
+			version = int(binary.BigEndian.Uint64(value))
+		}
+		if version > len(boltMigrations) {
+			return fmt.Errorf("chat database schema version %d is newer than this build supports (%d)", version, len(boltMigrations))
+		}
+
+		for i := version; i < len(boltMigrations); i++ {
+			if err := boltMigrations[i](tx); err != nil {
+				return fmt.Errorf("failed to migrate chat database to version %d: %w", i+1, err)
+			}
+		}
+
+		return meta.Put(keySchemaVersion, uint64Key(uint64(len(boltMigrations))))
+	})
+}
+
+// SchemaVersion returns the schema version recorded in the database.
+func (s *BoltStore) SchemaVersion() (int, error) {
+	version := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(bucketMeta).Get(keySchemaVersion); len(value) == 8 {
+			version = int(binary.BigEndian.Uint64(value))
+		}
+		return nil
+	})
+	return version, err
+}
+
+// Path returns the database file path.
+func (s *BoltStore) Path() string {
+	return s.db.Path()
+}
+
+// uint64Key encodes n so that keys sort numerically.
+func uint64Key(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
+
+// timeKey encodes a time so that keys sort chronologically; times before 1970
+// sort first.
+func timeKey(t time.Time) []byte {
+	if t.Before(time.Unix(0, 0)) {
+		return uint64Key(0)
+	}
+	return uint64Key(uint64(t.UnixNano()))
+}
+
+// lookupKey builds an index key for a chat under a model or tag.
+func lookupKey(value, id string) []byte {
+	return []byte(strings.ToLower(value) + "\x00" + id)
+}
+
+func (s *BoltStore) view(fn func(t *boltTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (s *BoltStore) update(fn func(t *boltTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+// SaveChat stores a chat and updates its summary and indexes.
+func (s *BoltStore) SaveChat(history *ChatHistory) error {
+	return s.update(func(t *boltTx) error { return t.SaveChat(history) })
+}
+
+// LoadChat reads a chat.
+func (s *BoltStore) LoadChat(id string) (history *ChatHistory, err error) {
+	err = s.view(func(t *boltTx) error {
+		history, err = t.LoadChat(id)
+		return err
+	})
+	return history, err
+}
+
+// DeleteChat removes a chat and its index entries.
+func (s *BoltStore) DeleteChat(id string) error {
+	return s.update(func(t *boltTx) error { return t.DeleteChat(id) })
+}
+
+// ListChats returns the summaries of the chats matching the filter, most
+// recently updated first.
+func (s *BoltStore) ListChats(filter ChatFilter) (summaries []ChatSummary, err error) {
+	err = s.view(func(t *boltTx) error {
+		summaries, err = t.ListChats(filter)
+		return err
+	})
+	return summaries, err
+}
+
+// ChatVersions returns the UpdatedAt time of each chat.
+func (s *BoltStore) ChatVersions(ids ...string) (versions map[string]time.Time, err error) {
+	err = s.view(func(t *boltTx) error {
+		versions, err = t.ChatVersions(ids...)
+		return err
+	})
+	return versions, err
+}
+
+// TrashChat moves a chat to the trash.
+func (s *BoltStore) TrashChat(id string, at time.Time) error {
+	return s.update(func(t *boltTx) error { return t.TrashChat(id, at) })
+}
+
+// RestoreChat moves a chat out of the trash.
+func (s *BoltStore) RestoreChat(id string) error {
+	return s.update(func(t *boltTx) error { return t.RestoreChat(id) })
+}
+
+// ListTrash returns the chats in the trash, most recently deleted first.
+func (s *BoltStore) ListTrash() (summaries []ChatSummary, err error) {
+	err = s.view(func(t *boltTx) error {
+		summaries, err = t.ListTrash()
+		return err
+	})
+	return summaries, err
+}
+
+// PurgeChat permanently deletes a chat from the trash.
+func (s *BoltStore) PurgeChat(id string) error {
+	return s.update(func(t *boltTx) error { return t.PurgeChat(id) })
+}
+
+// SaveTree stores a conversation tree.
+func (s *BoltStore) SaveTree(id string, tree *ConversationTree) error {
+	return s.update(func(t *boltTx) error { return t.SaveTree(id, tree) })
+}
+
+// LoadTree reads a conversation tree.
+func (s *BoltStore) LoadTree(id string) (tree *ConversationTree, err error) {
+	err = s.view(func(t *boltTx) error {
+		tree, err = t.LoadTree(id)
+		return err
+	})
+	return tree, err
+}
+
+// DeleteTree removes a conversation tree.
+func (s *BoltStore) DeleteTree(id string) error {
+	return s.update(func(t *boltTx) error { return t.DeleteTree(id) })
+}
+
+// ListTrees returns the IDs of the stored conversation trees in name order.
+func (s *BoltStore) ListTrees() (ids []string, err error) {
+	err = s.view(func(t *boltTx) error {
+		ids, err = t.ListTrees()
+		return err
+	})
+	return ids, err
+}
+
+// RecordUsage stores a usage record.
+func (s *BoltStore) RecordUsage(record UsageRecord) error {
+	return s.update(func(t *boltTx) error { return t.RecordUsage(record) })
+}
+
+// ListUsage returns the usage records matching the filter, oldest first.
+func (s *BoltStore) ListUsage(filter UsageFilter) (records []UsageRecord, err error) {
+	err = s.view(func(t *boltTx) error {
+		records, err = t.ListUsage(filter)
+		return err
+	})
+	return records, err
+}
+
+// Update runs fn in a read-write transaction.
+func (s *BoltStore) Update(fn func(Store) error) error {
+	return s.update(func(t *boltTx) error { return fn(t) })
+}
+
+// Close closes the database.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t *boltTx) SaveChat(history *ChatHistory) error {
+	if err := t.unindexChat(history.ID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat history: %w", err)
+	}
+	summary, err := json.Marshal(summarize(history))
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat summary: %w", err)
+	}
+
+	id := []byte(history.ID)
+	if err := t.tx.Bucket(bucketChats).Put(id, data); err != nil {
+		return fmt.Errorf("failed to write chat: %w", err)
+	}
+	if err := t.tx.Bucket(bucketChatSummaries).Put(id, summary); err != nil {
+		return fmt.Errorf("failed to write chat summary: %w", err)
+	}
+
+	// Index entries are keys only; the summary holds everything else
+	if err := t.tx.Bucket(bucketChatsByUpdate).Put(append(timeKey(history.UpdatedAt), id...), nil); err != nil {
+		return err
+	}
+	if history.Model != "" {
+		if err := t.tx.Bucket(bucketChatsByModel).Put(lookupKey(history.Model, history.ID), nil); err != nil {
+			return err
+		}
+	}
+	for _, tag := range history.Tags {
+		if err := t.tx.Bucket(bucketChatsByTag).Put(lookupKey(tag, history.ID), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// summary reads the stored summary of a chat, or nil if there is no such chat.
+func (t *boltTx) summary(id string) (*ChatSummary, error) {
+	data := t.tx.Bucket(bucketChatSummaries).Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+	var summary ChatSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse chat summary %s: %w", id, err)
+	}
+	return &summary, nil
+}
+
+// unindexChat removes the index entries of the stored version of a chat.
+func (t *boltTx) unindexChat(id string) error {
+	old, err := t.summary(id)
+	if err != nil || old == nil {
+		return err
+	}
+
+	if err := t.tx.Bucket(bucketChatsByUpdate).Delete(append(timeKey(old.UpdatedAt), id...)); err != nil {
+		return err
+	}
+	if old.Model != "" {
+		if err := t.tx.Bucket(bucketChatsByModel).Delete(lookupKey(old.Model, id)); err != nil {
+			return err
+		}
+	}
+	for _, tag := range old.Tags {
+		if err := t.tx.Bucket(bucketChatsByTag).Delete(lookupKey(tag, id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *boltTx) LoadChat(id string) (*ChatHistory, error) {
+	data := t.tx.Bucket(bucketChats).Get([]byte(id))
+	if data == nil {
+		return nil, fmt.Errorf("chat not found: %s", id)
+	}
+
+	var history ChatHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse chat %s: %w", id, err)
+	}
+	return &history, nil
+}
+
+func (t *boltTx) DeleteChat(id string) error {
+	if t.tx.Bucket(bucketChats).Get([]byte(id)) == nil {
+		return fmt.Errorf("chat not found: %s", id)
+	}
+	if err := t.unindexChat(id); err != nil {
+		return err
+	}
+	if err := t.tx.Bucket(bucketChatSummaries).Delete([]byte(id)); err != nil {
+		return err
+	}
+	return t.tx.Bucket(bucketChats).Delete([]byte(id))
+}
+
+// lookup returns the IDs of chats indexed under keys starting with prefix.
+func (t *boltTx) lookup(bucket []byte, prefix string) map[string]bool {
+	ids := map[string]bool{}
+	c := t.tx.Bucket(bucket).Cursor()
+	p := []byte(prefix)
+	for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+		if sep := bytes.IndexByte(k, 0); sep >= 0 {
+			ids[string(k[sep+1:])] = true
+		}
+	}
+	return ids
+}
+
+func (t *boltTx) ListChats(filter ChatFilter) ([]ChatSummary, error) {
+	// Narrow down by tag and model first, then walk the date index newest first
+	var candidates map[string]bool
+	if filter.Tag != "" {
+		candidates = t.lookup(bucketChatsByTag, strings.ToLower(filter.Tag)+"\x00")
+	}
+	if filter.Model != "" {
+		byModel := t.lookup(bucketChatsByModel, strings.ToLower(filter.Model))
+		if candidates == nil {
+			candidates = byModel
+		} else {
+			for id := range candidates {
+				if !byModel[id] {
+					delete(candidates, id)
+				}
+			}
+		}
+	}
+	if candidates != nil && len(candidates) == 0 {
+		return nil, nil
+	}
+
+	c := t.tx.Bucket(bucketChatsByUpdate).Cursor()
+	var k []byte
+	if filter.Before.IsZero() {
+		k, _ = c.Last()
+	} else if k, _ = c.Seek(timeKey(filter.Before)); k == nil {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.Prev()
+	}
+
+	var after []byte
+	if !filter.After.IsZero() {
+		after = timeKey(filter.After)
+	}
+
+	var summaries []ChatSummary
+	for ; k != nil; k, _ = c.Prev() {
+		if len(k) < 8 || (after != nil && bytes.Compare(k[:8], after) < 0) {
+			break
+		}
+		id := string(k[8:])
+		if candidates != nil && !candidates[id] {
+			continue
+		}
+
+		summary, err := t.summary(id)
+		if err != nil {
+			return nil, err
+		}
+		if summary == nil {
+			continue // Stale index entry
+		}
+		summaries = append(summaries, *summary)
+		if filter.Limit > 0 && len(summaries) == filter.Limit {
+			break
+		}
+	}
+
+	return summaries, nil
+}
+
+func (t *boltTx) ChatVersions(ids ...string) (map[string]time.Time, error) {
+	versions := map[string]time.Time{}
+	if len(ids) > 0 {
+		for _, id := range ids {
+			summary, err := t.summary(id)
+			if err != nil {
+				return nil, err
+			}
+			if summary != nil {
+				versions[id] = summary.UpdatedAt
+			}
+		}
+		return versions, nil
+	}
+
+	err := t.tx.Bucket(bucketChatSummaries).ForEach(func(k, v []byte) error {
+		var summary ChatSummary
+		if err := json.Unmarshal(v, &summary); err != nil {
+			return fmt.Errorf("failed to parse chat summary %s: %w", k, err)
+		}
+		versions[string(k)] = summary.UpdatedAt
+		return nil
+	})
+	return versions, err
+}
+
+func (t *boltTx) TrashChat(id string, at time.Time) error {
+	history, err := t.LoadChat(id)
+	if err != nil {
+		return err
+	}
+	history.DeletedAt = at
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat history: %w", err)
+	}
+	if err := t.tx.Bucket(bucketTrash).Put([]byte(id), data); err != nil {
+		return fmt.Errorf("failed to write chat to trash: %w", err)
+	}
+	return t.DeleteChat(id)
+}
+
+func (t *boltTx) RestoreChat(id string) error {
+	data := t.tx.Bucket(bucketTrash).Get([]byte(id))
+	if data == nil {
+		return fmt.Errorf("chat not in trash: %s", id)
+	}
+	if t.tx.Bucket(bucketChats).Get([]byte(id)) != nil {
+		return fmt.Errorf("a chat named %s already exists", id)
+	}
+
+	var history ChatHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return fmt.Errorf("failed to parse chat %s: %w", id, err)
+	}
+	history.DeletedAt = time.Time{}
+
+	if err := t.SaveChat(&history); err != nil {
+		return err
+	}
+	return t.tx.Bucket(bucketTrash).Delete([]byte(id))
+}
+
+func (t *boltTx) ListTrash() ([]ChatSummary, error) {
+	var summaries []ChatSummary
+	err := t.tx.Bucket(bucketTrash).ForEach(func(k, v []byte) error {
+		var history ChatHistory
+		if err := json.Unmarshal(v, &history); err != nil {
+			return fmt.Errorf("failed to parse chat %s: %w", k, err)
+		}
+		summaries = append(summaries, summarize(&history))
+		return nil
+	})
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].DeletedAt.After(summaries[j].DeletedAt)
+	})
+	return summaries, err
+}
+
+func (t *boltTx) PurgeChat(id string) error {
+	if t.tx.Bucket(bucketTrash).Get([]byte(id)) == nil {
+		return fmt.Errorf("chat not in trash: %s", id)
+	}
+	return t.tx.Bucket(bucketTrash).Delete([]byte(id))
+}
+
+func (t *boltTx) SaveTree(id string, tree *ConversationTree) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation tree: %w", err)
+	}
+	if err := t.tx.Bucket(bucketTrees).Put([]byte(id), data); err != nil {
+		return fmt.Errorf("failed to write tree: %w", err)
+	}
+	return nil
+}
+
+func (t *boltTx) LoadTree(id string) (*ConversationTree, error) {
+	data := t.tx.Bucket(bucketTrees).Get([]byte(id))
+	if data == nil {
+		return nil, fmt.Errorf("tree not found: %s", id)
+	}
+
+	tree, err := decodeTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tree %s: %w", id, err)
+	}
+	return tree, nil
+}
+
+func (t *boltTx) DeleteTree(id string) error {
+	if t.tx.Bucket(bucketTrees).Get([]byte(id)) == nil {
+		return fmt.Errorf("tree not found: %s", id)
+	}
+	return t.tx.Bucket(bucketTrees).Delete([]byte(id))
+}
+
+func (t *boltTx) ListTrees() ([]string, error) {
+	var ids []string
+	err := t.tx.Bucket(bucketTrees).ForEach(func(k, _ []byte) error {
+		ids = append(ids, string(k))
+		return nil
+	})
+	return ids, err
+}
+
+func (t *boltTx) RecordUsage(record UsageRecord) error {
+	bucket := t.tx.Bucket(bucketUsage)
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+	return bucket.Put(uint64Key(seq), data)
+}
+
+func (t *boltTx) ListUsage(filter UsageFilter) ([]UsageRecord, error) {
+	var records []UsageRecord
+	err := t.tx.Bucket(bucketUsage).ForEach(func(_, v []byte) error {
+		var record UsageRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("failed to parse usage record: %w", err)
+		}
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Update runs fn in the transaction that is already open.
+func (t *boltTx) Update(fn func(Store) error) error {
+	return fn(t)
+}
+
+// Close does nothing; the transaction ends when its Update returns.
+func (t *boltTx) Close() error {
+	return nil
+}
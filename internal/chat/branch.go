@@ -1,6 +1,8 @@
 package chat
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -8,35 +10,67 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"lil_guy/internal/wrap"
 )
 
-// Checkpoint represents a saved conversation state
+// treeVersion is the current ConversationTree file format. Version 1 trees,
+// saved before messages were stored as nodes, have no version.
+const treeVersion = 2
+
+// MessageNode is a message in a conversation tree. Each node points at the
+// message it answers or follows, so alternatives to a message, such as an
+// edited question or a regenerated reply, are siblings sharing a parent.
+type MessageNode struct {
+	ID         string      `json:"id"`
+	ParentID   string      `json:"parent_id,omitempty"` // Empty for the first message
+	Message    ChatMessage `json:"message"`
+	PickedFrom string      `json:"picked_from,omitempty"` // Node this message was cherry-picked from
+	MergedFrom []string    `json:"merged_from,omitempty"` // Messages whose branches this one merges
+}
+
+// Checkpoint represents a named point in a conversation
 type Checkpoint struct {
 	ID          string        `json:"id"`
 	Name        string        `json:"name"`
 	Description string        `json:"description"`
-	Messages    []ChatMessage `json:"messages"`
+	NodeID      string        `json:"node_id"` // Last message at the checkpoint, empty before the first
 	CreatedAt   time.Time     `json:"created_at"`
 	BranchFrom  string        `json:"branch_from,omitempty"` // ID of parent checkpoint
+	Auto        bool          `json:"auto,omitempty"`        // Created automatically, so it may be thinned out
+	Messages    []ChatMessage `json:"messages,omitempty"`    // Full copy, only in version 1 trees
 }
 
-// Branch represents a conversation branch
+// Branch represents a conversation branch: a name for the line of
+// conversation ending at its head
 type Branch struct {
 	ID          string       `json:"id"`
 	Name        string       `json:"name"`
 	Description string       `json:"description"`
+	Head        string       `json:"head"` // ID of the branch's latest message
 	Checkpoints []Checkpoint `json:"checkpoints"`
 	CreatedAt   time.Time    `json:"created_at"`
 	UpdatedAt   time.Time    `json:"updated_at"`
 }
 
-// ConversationTree manages branches and checkpoints
+// ConversationTree manages the messages, branches and checkpoints of a
+// conversation. Messages are stored once, however many branches share them.
 type ConversationTree struct {
-	RootBranch   *Branch            `json:"root_branch"`
-	Branches     map[string]*Branch `json:"branches"`
-	CurrentBranch string            `json:"current_branch"`
-	CreatedAt    time.Time         `json:"created_at"`
-	UpdatedAt    time.Time         `json:"updated_at"`
+	Version       int                     `json:"version"`
+	Nodes         map[string]*MessageNode `json:"nodes"`
+	RootBranchID  string                  `json:"root_branch_id"`
+	Branches      map[string]*Branch      `json:"branches"`
+	CurrentBranch string                  `json:"current_branch"`
+	Title         string                  `json:"title,omitempty"`
+	BuddyName     string                  `json:"buddy_name,omitempty"`
+	Model         string                  `json:"model,omitempty"`
+	Settings      *GenerationSettings     `json:"settings,omitempty"`
+	Source        string                  `json:"source,omitempty"`    // "chatgpt" or "claude" for imported trees
+	SourceID      string                  `json:"source_id,omitempty"` // The conversation's ID in its source
+	CreatedAt     time.Time               `json:"created_at"`
+	UpdatedAt     time.Time               `json:"updated_at"`
+
+	children map[string][]string // Child node IDs by parent ID, built on first use
 }
 
 const (
@@ -63,7 +97,7 @@ func GetBranchesDir() (string, error) {
 func NewConversationTree() *ConversationTree {
 	now := time.Now()
 	rootBranch := &Branch{
-		ID:          fmt.Sprintf("branch_%d", now.Unix()),
+		ID:          fmt.Sprintf("branch_%d", now.UnixNano()),
 		Name:        "main",
 		Description: "Main conversation branch",
 		Checkpoints: []Checkpoint{},
@@ -72,7 +106,9 @@ func NewConversationTree() *ConversationTree {
 	}
 
 	return &ConversationTree{
-		RootBranch:    rootBranch,
+		Version:       treeVersion,
+		Nodes:         map[string]*MessageNode{},
+		RootBranchID:  rootBranch.ID,
 		Branches:      map[string]*Branch{rootBranch.ID: rootBranch},
 		CurrentBranch: rootBranch.ID,
 		CreatedAt:     now,
@@ -80,7 +116,240 @@ func NewConversationTree() *ConversationTree {
 	}
 }
 
-// CreateCheckpoint creates a new checkpoint in the current branch
+// decodeTree parses a saved conversation tree, upgrading older formats.
+func decodeTree(data []byte) (*ConversationTree, error) {
+	var tree ConversationTree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	if tree.Version < treeVersion {
+		// Version 1 stored the whole root branch instead of its ID
+		var legacy struct {
+			RootBranch struct {
+				ID string `json:"id"`
+			} `json:"root_branch"`
+		}
+		json.Unmarshal(data, &legacy)
+		tree.upgrade(legacy.RootBranch.ID)
+	}
+	if tree.Nodes == nil {
+		tree.Nodes = map[string]*MessageNode{}
+	}
+	if tree.Branches == nil {
+		tree.Branches = map[string]*Branch{}
+	}
+	return &tree, nil
+}
+
+// upgrade converts a version 1 tree, where every checkpoint held a full copy
+// of the conversation, to message nodes. Copies of the same message become
+// one node, so checkpoints and branches share their common history.
+func (ct *ConversationTree) upgrade(rootBranchID string) {
+	if ct.Nodes == nil {
+		ct.Nodes = map[string]*MessageNode{}
+	}
+	if ct.RootBranchID == "" {
+		ct.RootBranchID = rootBranchID
+	}
+	updated := ct.UpdatedAt
+
+	// Main first, then oldest branch first, so nodes are added in the order
+	// the conversation happened
+	ids := make([]string, 0, len(ct.Branches))
+	for id := range ct.Branches {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ct.Branches[ids[i]], ct.Branches[ids[j]]
+		if (ids[i] == ct.RootBranchID) != (ids[j] == ct.RootBranchID) {
+			return ids[i] == ct.RootBranchID
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		branch := ct.Branches[id]
+		for i := range branch.Checkpoints {
+			checkpoint := &branch.Checkpoints[i]
+			parent := ""
+			for _, msg := range checkpoint.Messages {
+				parent = ct.AddMessage(parent, msg).ID
+			}
+			checkpoint.NodeID = parent
+			checkpoint.Messages = nil
+			branch.Head = parent
+		}
+	}
+	ct.UpdatedAt = updated // Upgrading isn't an update
+	ct.Version = treeVersion
+}
+
+// newNodeID returns a random message node ID.
+func newNodeID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return "msg_" + hex.EncodeToString(id)
+}
+
+// Children returns the messages that follow a node, oldest first. An empty
+// parentID returns the messages that start the conversation.
+func (ct *ConversationTree) Children(parentID string) []*MessageNode {
+	if ct.children == nil {
+		ct.children = map[string][]string{}
+		for id, node := range ct.Nodes {
+			ct.children[node.ParentID] = append(ct.children[node.ParentID], id)
+		}
+	}
+
+	var children []*MessageNode
+	for _, id := range ct.children[parentID] {
+		children = append(children, ct.Nodes[id])
+	}
+	sort.Slice(children, func(i, j int) bool {
+		a, b := children[i], children[j]
+		if !a.Message.Timestamp.Equal(b.Message.Timestamp) {
+			return a.Message.Timestamp.Before(b.Message.Timestamp)
+		}
+		return a.ID < b.ID
+	})
+	return children
+}
+
+// Siblings returns a node and its alternatives, oldest first.
+func (ct *ConversationTree) Siblings(nodeID string) []*MessageNode {
+	node, ok := ct.Nodes[nodeID]
+	if !ok {
+		return nil
+	}
+	return ct.Children(node.ParentID)
+}
+
+// AddMessage adds a message after parentID and returns its node. If the
+// parent already has an identical message, that node is returned instead.
+func (ct *ConversationTree) AddMessage(parentID string, msg ChatMessage) *MessageNode {
+	for _, child := range ct.Children(parentID) {
+		existing := child.Message
+		if existing.Role == msg.Role && existing.Content == msg.Content && existing.Timestamp.Equal(msg.Timestamp) {
+			return child
+		}
+	}
+
+	node := &MessageNode{ID: newNodeID(), ParentID: parentID, Message: msg}
+	ct.Nodes[node.ID] = node
+	ct.children[parentID] = append(ct.children[parentID], node.ID)
+	ct.UpdatedAt = time.Now()
+	return node
+}
+
+// Path returns the node IDs from the start of the conversation to nodeID.
+func (ct *ConversationTree) Path(nodeID string) []string {
+	var path []string
+	for id := nodeID; id != "" && len(path) <= len(ct.Nodes); {
+		node, ok := ct.Nodes[id]
+		if !ok {
+			break
+		}
+		path = append(path, id)
+		id = node.ParentID
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Messages returns the conversation from its start to nodeID.
+func (ct *ConversationTree) Messages(nodeID string) []ChatMessage {
+	path := ct.Path(nodeID)
+	messages := make([]ChatMessage, len(path))
+	for i, id := range path {
+		messages[i] = ct.Nodes[id].Message
+	}
+	return messages
+}
+
+// Head returns the ID of the current branch's latest message.
+func (ct *ConversationTree) Head() string {
+	if branch := ct.GetCurrentBranch(); branch != nil {
+		return branch.Head
+	}
+	return ""
+}
+
+// SetHead moves the current branch to a message.
+func (ct *ConversationTree) SetHead(nodeID string) error {
+	if _, ok := ct.Nodes[nodeID]; nodeID != "" && !ok {
+		return fmt.Errorf("message not found: %s", nodeID)
+	}
+	branch := ct.GetCurrentBranch()
+	if branch == nil {
+		return fmt.Errorf("current branch not found")
+	}
+
+	now := time.Now()
+	branch.Head = nodeID
+	branch.UpdatedAt = now
+	ct.UpdatedAt = now
+	return nil
+}
+
+// SyncMessages records a conversation on the current branch, adding the
+// messages the tree doesn't have yet, and returns the new head. When the
+// conversation was edited or regenerated, the old messages stay in the tree
+// as siblings of the new ones.
+func (ct *ConversationTree) SyncMessages(messages []ChatMessage) string {
+	parent := ""
+	for _, msg := range messages {
+		parent = ct.AddMessage(parent, msg).ID
+	}
+	if parent != ct.Head() {
+		ct.SetHead(parent)
+	}
+	return parent
+}
+
+// CherryPick copies messages, in order, onto the head of the current branch
+// and returns the new head. Each copy remembers the message it came from.
+func (ct *ConversationTree) CherryPick(nodeIDs []string) (string, error) {
+	head := ct.Head()
+	now := time.Now()
+	for i, id := range nodeIDs {
+		source, ok := ct.Nodes[id]
+		if !ok {
+			return "", fmt.Errorf("message not found: %s", id)
+		}
+
+		msg := source.Message
+		msg.Timestamp = now.Add(time.Duration(i)) // Keep the picked order when sorting
+		node := ct.AddMessage(head, msg)
+		node.PickedFrom = id
+		head = node.ID
+	}
+
+	if err := ct.SetHead(head); err != nil {
+		return "", err
+	}
+	return head, nil
+}
+
+// AddMerge appends a message combining other branches to the current branch
+// and moves its head there. from holds the heads of the merged branches.
+func (ct *ConversationTree) AddMerge(msg ChatMessage, from []string) (*MessageNode, error) {
+	node := ct.AddMessage(ct.Head(), msg)
+	node.MergedFrom = from
+	if err := ct.SetHead(node.ID); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// CreateCheckpoint records the messages on the current branch and creates a
+// checkpoint at its head
 func (ct *ConversationTree) CreateCheckpoint(name, description string, messages []ChatMessage) (*Checkpoint, error) {
 	branch, exists := ct.Branches[ct.CurrentBranch]
 	if !exists {
@@ -92,7 +361,7 @@ func (ct *ConversationTree) CreateCheckpoint(name, description string, messages
 		ID:          fmt.Sprintf("checkpoint_%d", now.UnixNano()),
 		Name:        name,
 		Description: description,
-		Messages:    messages,
+		NodeID:      ct.SyncMessages(messages),
 		CreatedAt:   now,
 	}
 
@@ -103,6 +372,86 @@ func (ct *ConversationTree) CreateCheckpoint(name, description string, messages
 	return &checkpoint, nil
 }
 
+// CreateAutoCheckpoint records the messages on the current branch and
+// returns a checkpoint of its head, creating one unless the head already has
+// one. Older automatic checkpoints are thinned out.
+func (ct *ConversationTree) CreateAutoCheckpoint(reason string, messages []ChatMessage) (*Checkpoint, error) {
+	branch := ct.GetCurrentBranch()
+	if branch == nil {
+		return nil, fmt.Errorf("current branch not found")
+	}
+
+	head := ct.SyncMessages(messages)
+	for _, existing := range branch.Checkpoints {
+		if existing.NodeID == head {
+			return &existing, nil
+		}
+	}
+
+	checkpoint, err := ct.CreateCheckpoint(GenerateCheckpointName(messages), reason, messages)
+	if err != nil {
+		return nil, err
+	}
+	branch.Checkpoints[len(branch.Checkpoints)-1].Auto = true
+	checkpoint.Auto = true
+
+	ct.PruneAutoCheckpoints(checkpoint.CreatedAt)
+	return checkpoint, nil
+}
+
+// keepRecentAutoCheckpoints is how many automatic checkpoints per branch are
+// kept before thinning starts.
+const keepRecentAutoCheckpoints = 5
+
+// PruneAutoCheckpoints thins out automatic checkpoints on every branch and
+// returns how many were removed. The newest few are kept, then one an hour
+// for the last day, then one a day. Manual checkpoints and checkpoints a
+// branch starts from are always kept.
+func (ct *ConversationTree) PruneAutoCheckpoints(now time.Time) int {
+	branchedFrom := map[string]bool{}
+	for _, branch := range ct.Branches {
+		for _, cp := range branch.Checkpoints {
+			if cp.BranchFrom != "" {
+				branchedFrom[cp.BranchFrom] = true
+			}
+		}
+	}
+
+	removed := 0
+	for _, branch := range ct.Branches {
+		var kept []Checkpoint
+		buckets := map[string]bool{}
+		recent := 0
+
+		// Checkpoints are in creation order, so walk them newest first
+		for i := len(branch.Checkpoints) - 1; i >= 0; i-- {
+			cp := branch.Checkpoints[i]
+			if !cp.Auto || branchedFrom[cp.ID] {
+				kept = append(kept, cp)
+				continue
+			}
+
+			bucket := cp.CreatedAt.Format("2006-01-02")
+			if now.Sub(cp.CreatedAt) < 24*time.Hour {
+				bucket = cp.CreatedAt.Format("2006-01-02 15h")
+			}
+			if recent >= keepRecentAutoCheckpoints && buckets[bucket] {
+				removed++
+				continue
+			}
+			recent++
+			buckets[bucket] = true
+			kept = append(kept, cp)
+		}
+
+		for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+			kept[i], kept[j] = kept[j], kept[i]
+		}
+		branch.Checkpoints = kept
+	}
+	return removed
+}
+
 // CreateBranch creates a new branch from a checkpoint
 func (ct *ConversationTree) CreateBranch(fromCheckpoint string, name, description string) (*Branch, error) {
 	// Find the checkpoint
@@ -128,12 +477,13 @@ func (ct *ConversationTree) CreateBranch(fromCheckpoint string, name, descriptio
 		ID:          fmt.Sprintf("branch_%d", now.UnixNano()),
 		Name:        name,
 		Description: description,
+		Head:        sourceCheckpoint.NodeID,
 		Checkpoints: []Checkpoint{
 			{
 				ID:          fmt.Sprintf("checkpoint_%d", now.UnixNano()),
 				Name:        "Branch start",
 				Description: fmt.Sprintf("Branched from checkpoint '%s'", sourceCheckpoint.Name),
-				Messages:    sourceCheckpoint.Messages,
+				NodeID:      sourceCheckpoint.NodeID,
 				CreatedAt:   now,
 				BranchFrom:  fromCheckpoint,
 			},
@@ -148,6 +498,37 @@ func (ct *ConversationTree) CreateBranch(fromCheckpoint string, name, descriptio
 	return newBranch, nil
 }
 
+// BranchAt creates a branch that continues the conversation from a message
+func (ct *ConversationTree) BranchAt(nodeID, name, description string) (*Branch, error) {
+	if _, ok := ct.Nodes[nodeID]; !ok {
+		return nil, fmt.Errorf("message not found: %s", nodeID)
+	}
+
+	now := time.Now()
+	newBranch := &Branch{
+		ID:          fmt.Sprintf("branch_%d", now.UnixNano()),
+		Name:        name,
+		Description: description,
+		Head:        nodeID,
+		Checkpoints: []Checkpoint{
+			{
+				ID:          fmt.Sprintf("checkpoint_%d", now.UnixNano()),
+				Name:        "Branch start",
+				Description: "Branched from a message",
+				NodeID:      nodeID,
+				CreatedAt:   now,
+			},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	ct.Branches[newBranch.ID] = newBranch
+	ct.UpdatedAt = now
+
+	return newBranch, nil
+}
+
 // SwitchBranch switches to a different branch
 func (ct *ConversationTree) SwitchBranch(branchID string) error {
 	if _, exists := ct.Branches[branchID]; !exists {
@@ -158,6 +539,42 @@ func (ct *ConversationTree) SwitchBranch(branchID string) error {
 	return nil
 }
 
+// RenameBranch renames a branch
+func (ct *ConversationTree) RenameBranch(branchID, name string) error {
+	branch, exists := ct.Branches[branchID]
+	if !exists {
+		return fmt.Errorf("branch not found: %s", branchID)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("branch name can't be empty")
+	}
+
+	now := time.Now()
+	branch.Name = name
+	branch.UpdatedAt = now
+	ct.UpdatedAt = now
+	return nil
+}
+
+// DeleteBranch removes a branch and its checkpoints. Its messages stay in the
+// tree as alternatives. Deleting the current branch switches to main.
+func (ct *ConversationTree) DeleteBranch(branchID string) error {
+	if _, exists := ct.Branches[branchID]; !exists {
+		return fmt.Errorf("branch not found: %s", branchID)
+	}
+	if branchID == ct.RootBranchID {
+		return fmt.Errorf("the main branch can't be deleted")
+	}
+
+	delete(ct.Branches, branchID)
+	if ct.CurrentBranch == branchID {
+		ct.CurrentBranch = ct.RootBranchID
+	}
+	ct.UpdatedAt = time.Now()
+	return nil
+}
+
 // GetCurrentBranch returns the current branch
 func (ct *ConversationTree) GetCurrentBranch() *Branch {
 	return ct.Branches[ct.CurrentBranch]
@@ -168,7 +585,7 @@ func (ct *ConversationTree) LoadFromCheckpoint(checkpointID string) ([]ChatMessa
 	for _, branch := range ct.Branches {
 		for _, cp := range branch.Checkpoints {
 			if cp.ID == checkpointID {
-				return cp.Messages, nil
+				return ct.Messages(cp.NodeID), nil
 			}
 		}
 	}
@@ -188,73 +605,141 @@ func (ct *ConversationTree) ListCheckpoints() []Checkpoint {
 	return checkpoints
 }
 
-// SaveTree saves the conversation tree to a file
+// SaveTree saves the conversation tree under a filename, generating one if empty
 func SaveTree(tree *ConversationTree, filename string) error {
-	branchDir, err := GetBranchesDir()
-	if err != nil {
-		return err
-	}
-
-	// Use provided filename or generate one
 	if filename == "" {
 		filename = fmt.Sprintf("tree_%s.json", time.Now().Format("2006-01-02_15-04-05"))
 	}
-	
-	filePath := filepath.Join(branchDir, filename)
+	return DefaultStore().SaveTree(treeID(filename), tree)
+}
+
+// LoadTree loads a conversation tree by filename or ID
+func LoadTree(filename string) (*ConversationTree, error) {
+	return DefaultStore().LoadTree(treeID(filename))
+}
 
-	data, err := json.MarshalIndent(tree, "", "  ")
+// ListTrees returns the filenames of the saved conversation trees
+func ListTrees() ([]string, error) {
+	ids, err := DefaultStore().ListTrees()
 	if err != nil {
-		return fmt.Errorf("failed to marshal conversation tree: %w", err)
+		return nil, err
 	}
 
-	if err := os.WriteFile(filePath, data, filePermissions); err != nil {
-		return fmt.Errorf("failed to write tree file: %w", err)
+	var treeFiles []string
+	for _, id := range ids {
+		treeFiles = append(treeFiles, id+".json")
 	}
+	return treeFiles, nil
+}
 
-	return nil
+// TreeSummary describes a saved conversation tree
+type TreeSummary struct {
+	Filename  string
+	Name      string // The tree's title, or the start of its main branch
+	Branches  int
+	Messages  int // Messages in the tree, across all branches
+	UpdatedAt time.Time
 }
 
-// LoadTree loads a conversation tree from a file
-func LoadTree(filename string) (*ConversationTree, error) {
-	branchDir, err := GetBranchesDir()
+// ListTreeSummaries returns the saved conversation trees, most recently updated first
+func ListTreeSummaries() ([]TreeSummary, error) {
+	trees, err := ListTrees()
 	if err != nil {
 		return nil, err
 	}
 
-	filePath := filepath.Join(branchDir, filename)
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read tree file: %w", err)
-	}
+	var summaries []TreeSummary
+	for _, filename := range trees {
+		tree, err := LoadTree(filename)
+		if err != nil {
+			continue // Skip files we can't read
+		}
 
-	var tree ConversationTree
-	if err := json.Unmarshal(data, &tree); err != nil {
-		return nil, fmt.Errorf("failed to parse tree file: %w", err)
+		name := tree.Title
+		if root := tree.Branches[tree.RootBranchID]; name == "" && root != nil {
+			name = root.Name
+			if messages := tree.Messages(root.Head); len(messages) > 0 {
+				name = GenerateTitle(messages)
+			}
+		}
+		summaries = append(summaries, TreeSummary{
+			Filename:  filename,
+			Name:      name,
+			Branches:  len(tree.Branches),
+			Messages:  len(tree.Nodes),
+			UpdatedAt: tree.UpdatedAt,
+		})
 	}
 
-	return &tree, nil
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+	return summaries, nil
 }
 
-// ListTrees returns a list of saved conversation trees
-func ListTrees() ([]string, error) {
-	branchDir, err := GetBranchesDir()
+// treeID returns the tree ID for a tree filename
+func treeID(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".json")
+}
+
+// ResolveTree maps a tree ID or filename to the filename of an existing tree.
+func ResolveTree(idOrFilename string) (string, error) {
+	id := treeID(idOrFilename)
+	if !strings.HasPrefix(id, "tree_") {
+		return "", fmt.Errorf("tree not found: %s", idOrFilename)
+	}
+
+	ids, err := DefaultStore().ListTrees()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	for _, existing := range ids {
+		if existing == id {
+			return id + ".json", nil
+		}
+	}
+
+	return "", fmt.Errorf("tree not found: %s", idOrFilename)
+}
 
-	files, err := os.ReadDir(branchDir)
+// FindCheckpoint searches all saved trees for a checkpoint and returns the tree file containing it
+func FindCheckpoint(checkpointID string) (string, *ConversationTree, error) {
+	trees, err := ListTrees()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read branches directory: %w", err)
+		return "", nil, err
 	}
 
-	var treeFiles []string
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") && strings.HasPrefix(file.Name(), "tree_") {
-			treeFiles = append(treeFiles, file.Name())
+	for _, filename := range trees {
+		tree, err := LoadTree(filename)
+		if err != nil {
+			continue // Skip files we can't read
+		}
+		if _, err := tree.LoadFromCheckpoint(checkpointID); err == nil {
+			return filename, tree, nil
 		}
 	}
 
-	return treeFiles, nil
+	return "", nil, fmt.Errorf("checkpoint not found: %s", checkpointID)
+}
+
+// BranchForCheckpoint returns the branch that owns a checkpoint
+func (ct *ConversationTree) BranchForCheckpoint(checkpointID string) *Branch {
+	for _, branch := range ct.Branches {
+		for _, cp := range branch.Checkpoints {
+			if cp.ID == checkpointID {
+				return branch
+			}
+		}
+	}
+	return nil
+}
+
+// LatestCheckpoint returns the most recent checkpoint on a branch, or nil if it has none
+func (b *Branch) LatestCheckpoint() *Checkpoint {
+	if len(b.Checkpoints) == 0 {
+		return nil
+	}
+	return &b.Checkpoints[len(b.Checkpoints)-1]
 }
 
 // GenerateCheckpointName creates a descriptive checkpoint name from the last few messages
@@ -276,9 +761,10 @@ func GenerateCheckpointName(messages []ChatMessage) string {
 		return fmt.Sprintf("Checkpoint at %s", time.Now().Format("15:04"))
 	}
 
-	// Truncate to reasonable length
-	if len(lastUserMsg) > 50 {
-		lastUserMsg = lastUserMsg[:47] + "..."
+	// Truncate to reasonable length, on one line
+	lastUserMsg = strings.Join(strings.Fields(lastUserMsg), " ")
+	if wrap.Width(lastUserMsg) > 50 {
+		lastUserMsg = strings.TrimSpace(wrap.Truncate(lastUserMsg, 47, "")) + "..."
 	}
 
 	return lastUserMsg
@@ -1,12 +1,16 @@
 package chat
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+
+	"lil_guy/internal/wrap"
 )
 
 const (
@@ -22,13 +26,32 @@ type ChatMessage struct {
 	Model     string    `json:"model,omitempty"`
 }
 
+// GenerationSettings records how responses in a conversation were generated.
+type GenerationSettings struct {
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	Personality  string   `json:"personality,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty"` // Nil when unset
+	MaxTokens    int      `json:"max_tokens,omitempty"`
+}
+
 // ChatHistory represents a saved conversation.
 type ChatHistory struct {
-	Messages  []ChatMessage `json:"messages"`
-	BuddyName string        `json:"buddy_name"`
-	Model     string        `json:"model"`
-	CreatedAt time.Time     `json:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at"`
+	ID        string              `json:"id"`
+	Title     string              `json:"title,omitempty"`
+	Messages  []ChatMessage       `json:"messages"`
+	BuddyName string              `json:"buddy_name"`
+	Model     string              `json:"model"`
+	Settings  *GenerationSettings `json:"settings,omitempty"`
+	Tags      []string            `json:"tags,omitempty"`
+	Pinned    bool                `json:"pinned,omitempty"`
+	Archived  bool                `json:"archived,omitempty"` // Hidden from the browser but kept
+	Cost      float64             `json:"cost,omitempty"`     // Estimated API cost in USD
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	DeletedAt time.Time           `json:"deleted_at,omitzero"` // When the chat was moved to the trash
+	Source    string              `json:"source,omitempty"`    // "chatgpt" or "claude" for imported chats
+	SourceID  string              `json:"source_id,omitempty"` // The conversation's ID in its source
+	Tree      *ConversationTree   `json:"tree,omitempty"`      // Every version of the conversation, if it was edited or regenerated
 }
 
 // GetChatHistoryDir returns the directory path for chat history files.
@@ -47,108 +70,246 @@ func GetChatHistoryDir() (string, error) {
 	return historyDir, nil
 }
 
-// SaveChat saves the current conversation to a file.
+// NewChatID returns a new unique conversation ID.
+func NewChatID() string {
+	return newID("chat", time.Now())
+}
+
+// newID returns a unique ID such as chat_2025-01-02_15-04-05_a1b2c3 for
+// something created at t.
+func newID(prefix string, t time.Time) string {
+	// The random suffix keeps IDs from the same second apart
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s_%s_%s", prefix, t.Format("2006-01-02_15-04-05"), hex.EncodeToString(suffix))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once the rename has succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, filePermissions); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// SaveChat saves a conversation to the store, assigning an ID on the first
+// save. Later saves of the same conversation replace it, keep CreatedAt and
+// bump UpdatedAt. It returns the chat's filename, which is its ID plus .json.
 func SaveChat(history ChatHistory) (string, error) {
 	if len(history.Messages) == 0 {
 		return "", fmt.Errorf("no messages to save")
 	}
 
-	historyDir, err := GetChatHistoryDir()
-	if err != nil {
-		return "", err
+	store := DefaultStore()
+	if history.ID == "" {
+		history.ID = NewChatID()
 	}
 
 	now := time.Now()
-	filename := fmt.Sprintf("chat_%s.json", now.Format("2006-01-02_15-04-05"))
-	filePath := filepath.Join(historyDir, filename)
-
-	history.CreatedAt = now
+	if history.CreatedAt.IsZero() {
+		history.CreatedAt = now
+		if existing, err := store.LoadChat(history.ID); err == nil && !existing.CreatedAt.IsZero() {
+			history.CreatedAt = existing.CreatedAt
+		}
+	}
 	history.UpdatedAt = now
 
-	data, err := json.MarshalIndent(history, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal chat history: %w", err)
+	if err := store.SaveChat(&history); err != nil {
+		return "", err
 	}
 
-	if err := os.WriteFile(filePath, data, filePermissions); err != nil {
-		return "", fmt.Errorf("failed to write chat history file: %w", err)
-	}
+	updateSearchIndex(&history)
 
-	return filename, nil
+	return history.ID + ".json", nil
 }
 
-// LoadChat loads a specific chat history file.
+// LoadChat loads a saved chat by filename or ID.
 func LoadChat(filename string) (*ChatHistory, error) {
-	historyDir, err := GetChatHistoryDir()
+	return DefaultStore().LoadChat(ChatIDFromFilename(filename))
+}
+
+// ListChats returns the filenames of the saved chats in name order.
+func ListChats() ([]string, error) {
+	versions, err := DefaultStore().ChatVersions()
 	if err != nil {
 		return nil, err
 	}
 
-	filePath := filepath.Join(historyDir, filename)
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read chat file: %w", err)
+	var chatFiles []string
+	for id := range versions {
+		chatFiles = append(chatFiles, id+".json")
 	}
+	sort.Strings(chatFiles)
 
-	var history ChatHistory
-	if err := json.Unmarshal(data, &history); err != nil {
-		return nil, fmt.Errorf("failed to parse chat file: %w", err)
-	}
+	return chatFiles, nil
+}
 
-	return &history, nil
+// ChatSummary describes a saved chat without its message bodies.
+type ChatSummary struct {
+	ID           string    `json:"id"`
+	Filename     string    `json:"filename"`
+	Title        string    `json:"title"` // Generated from the first message for untitled chats
+	BuddyName    string    `json:"buddy_name"`
+	Model        string    `json:"model"`
+	Tags         []string  `json:"tags,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	Archived     bool      `json:"archived,omitempty"`
+	MessageCount int       `json:"message_count"`
+	Cost         float64   `json:"cost"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	DeletedAt    time.Time `json:"deleted_at,omitzero"`
+	Source       string    `json:"source,omitempty"`
+	SourceID     string    `json:"source_id,omitempty"`
 }
 
-// ListChats returns a list of saved chat files.
-func ListChats() ([]string, error) {
-	historyDir, err := GetChatHistoryDir()
+// ChatIDFromFilename returns the chat ID for a chat history filename.
+func ChatIDFromFilename(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".json")
+}
+
+// ResolveChat maps a chat ID or filename to the filename of an existing chat.
+func ResolveChat(idOrFilename string) (string, error) {
+	id := ChatIDFromFilename(idOrFilename)
+	versions, err := DefaultStore().ChatVersions(id)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if _, ok := versions[id]; !ok {
+		return "", fmt.Errorf("chat not found: %s", idOrFilename)
 	}
 
-	files, err := os.ReadDir(historyDir)
+	return id + ".json", nil
+}
+
+// ListChatSummaries returns summaries of all saved chats, most recently updated first.
+func ListChatSummaries() ([]ChatSummary, error) {
+	return FindChats(ChatFilter{})
+}
+
+// FindChats returns summaries of the saved chats matching a filter, most
+// recently updated first.
+func FindChats(filter ChatFilter) ([]ChatSummary, error) {
+	return DefaultStore().ListChats(filter)
+}
+
+// LatestChat returns the filename of the most recently updated chat.
+func LatestChat() (string, error) {
+	summaries, err := ListChatSummaries()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read chat history directory: %w", err)
+		return "", err
+	}
+	if len(summaries) == 0 {
+		return "", fmt.Errorf("no saved chats found")
 	}
+	return summaries[0].Filename, nil
+}
 
-	var chatFiles []string
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			chatFiles = append(chatFiles, file.Name())
-		}
+// DeleteChat removes a saved chat.
+func DeleteChat(filename string) error {
+	id := ChatIDFromFilename(filename)
+	if err := DefaultStore().DeleteChat(id); err != nil {
+		return err
 	}
 
-	return chatFiles, nil
+	removeFromSearchIndex(id)
+	return nil
 }
 
-// ExportToMarkdown exports the current chat to a markdown file.
-func ExportToMarkdown(history ChatHistory) (string, error) {
-	if len(history.Messages) == 0 {
-		return "", fmt.Errorf("no messages to export")
+// GenerateTitle builds a title from the first user message, for chats that
+// haven't been named by a model or the user.
+func GenerateTitle(messages []ChatMessage) string {
+	for _, msg := range messages {
+		if msg.Role != "user" {
+			continue
+		}
+
+		// Collapse the message onto one line
+		title := strings.Join(strings.Fields(msg.Content), " ")
+		if title == "" {
+			continue
+		}
+		if wrap.Width(title) > 50 {
+			title = strings.TrimSpace(wrap.Truncate(title, 47, "")) + "..."
+		}
+		return title
 	}
 
-	historyDir, err := GetChatHistoryDir()
-	if err != nil {
-		return "", err
+	return "Untitled chat"
+}
+
+// RoleLabel returns the display label for a message role.
+func RoleLabel(role, buddyName string) string {
+	switch role {
+	case "user":
+		return "You"
+	case "system":
+		return "System"
+	default:
+		if buddyName == "" {
+			return "AI"
+		}
+		return buddyName
 	}
+}
 
-	now := time.Now()
-	filename := fmt.Sprintf("chat_%s.md", now.Format("2006-01-02_15-04-05"))
-	filePath := filepath.Join(historyDir, filename)
+// RenderMarkdown renders a chat as a markdown document.
+func RenderMarkdown(history ChatHistory) string {
+	date := history.CreatedAt
+	if date.IsZero() {
+		date = time.Now()
+	}
 
 	var markdown strings.Builder
 	markdown.WriteString(fmt.Sprintf("# Chat with %s\n\n", history.BuddyName))
-	markdown.WriteString(fmt.Sprintf("**Date:** %s\n", now.Format("January 2, 2006 15:04:05")))
+	markdown.WriteString(fmt.Sprintf("**Date:** %s\n", date.Format("January 2, 2006 15:04:05")))
 	markdown.WriteString(fmt.Sprintf("**Model:** %s\n\n", history.Model))
 	markdown.WriteString("---\n\n")
 
 	for _, msg := range history.Messages {
-		if msg.Role == "user" {
-			markdown.WriteString(fmt.Sprintf("**You** (%s):\n", msg.Timestamp.Format("15:04")))
-		} else {
-			markdown.WriteString(fmt.Sprintf("**%s** (%s):\n", history.BuddyName, msg.Timestamp.Format("15:04")))
-		}
+		markdown.WriteString(fmt.Sprintf("**%s** (%s):\n", RoleLabel(msg.Role, history.BuddyName), msg.Timestamp.Format("15:04")))
 		markdown.WriteString(fmt.Sprintf("%s\n\n", msg.Content))
 	}
 
-	return filename, os.WriteFile(filePath, []byte(markdown.String()), filePermissions)
+	return markdown.String()
+}
+
+// ExportToMarkdown exports the current chat to a markdown file.
+func ExportToMarkdown(history ChatHistory) (string, error) {
+	if len(history.Messages) == 0 {
+		return "", fmt.Errorf("no messages to export")
+	}
+
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return "", err
+	}
+
+	filename := fmt.Sprintf("chat_%s.md", time.Now().Format("2006-01-02_15-04-05"))
+	if history.ID != "" {
+		filename = history.ID + ".md"
+	}
+	filePath := filepath.Join(historyDir, filename)
+
+	return filename, os.WriteFile(filePath, []byte(RenderMarkdown(history)), filePermissions)
 }
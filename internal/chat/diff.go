@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffOp says which side of a diff a line belongs to.
+type DiffOp int
+
+const (
+	DiffEqual  DiffOp = iota
+	DiffDelete        // Only in the first text
+	DiffInsert        // Only in the second text
+)
+
+// LineDiff is a line of a line-level diff.
+type LineDiff struct {
+	Op   DiffOp
+	Text string
+}
+
+// maxDiffCells bounds the size of the LCS table; texts longer than that are
+// shown as entirely replaced.
+const maxDiffCells = 4_000_000
+
+// DiffLines compares two texts line by line, keeping their longest common
+// subsequence of lines and marking the rest as deleted or inserted.
+func DiffLines(a, b string) []LineDiff {
+	left, right := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// Lines shared at the ends don't need the table
+	start := 0
+	for start < len(left) && start < len(right) && left[start] == right[start] {
+		start++
+	}
+	end := 0
+	for end < len(left)-start && end < len(right)-start && left[len(left)-1-end] == right[len(right)-1-end] {
+		end++
+	}
+
+	var diff []LineDiff
+	for _, line := range left[:start] {
+		diff = append(diff, LineDiff{DiffEqual, line})
+	}
+
+	l, r := left[start:len(left)-end], right[start:len(right)-end]
+	if (len(l)+1)*(len(r)+1) > maxDiffCells {
+		for _, line := range l {
+			diff = append(diff, LineDiff{DiffDelete, line})
+		}
+		for _, line := range r {
+			diff = append(diff, LineDiff{DiffInsert, line})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of l[i:] and r[j:]
+		lcs := make([][]int, len(l)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(r)+1)
+		}
+		for i := len(l) - 1; i >= 0; i-- {
+			for j := len(r) - 1; j >= 0; j-- {
+				if l[i] == r[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(l) || j < len(r) {
+			switch {
+			case i < len(l) && j < len(r) && l[i] == r[j]:
+				diff = append(diff, LineDiff{DiffEqual, l[i]})
+				i++
+				j++
+			case j == len(r) || (i < len(l) && lcs[i+1][j] >= lcs[i][j+1]):
+				diff = append(diff, LineDiff{DiffDelete, l[i]})
+				i++
+			default:
+				diff = append(diff, LineDiff{DiffInsert, r[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range left[len(left)-end:] {
+		diff = append(diff, LineDiff{DiffEqual, line})
+	}
+	return diff
+}
+
+// ConversationDiff is where two versions of a conversation part ways.
+type ConversationDiff struct {
+	Common []ChatMessage // Messages both versions start with
+	Left   []ChatMessage // The first version after that
+	Right  []ChatMessage // The second version after that
+}
+
+// DiffConversations splits two conversations into their common start and the
+// messages where they differ.
+func DiffConversations(left, right []ChatMessage) ConversationDiff {
+	common := 0
+	for common < len(left) && common < len(right) &&
+		left[common].Role == right[common].Role && left[common].Content == right[common].Content {
+		common++
+	}
+	return ConversationDiff{
+		Common: left[:common],
+		Left:   left[common:],
+		Right:  right[common:],
+	}
+}
+
+// MessagesAt returns the conversation at a checkpoint, at the head of a
+// branch or up to a message, given its ID.
+func (ct *ConversationTree) MessagesAt(id string) ([]ChatMessage, error) {
+	if branch, ok := ct.Branches[id]; ok {
+		return ct.Messages(branch.Head), nil
+	}
+	if _, ok := ct.Nodes[id]; ok {
+		return ct.Messages(id), nil
+	}
+	messages, err := ct.LoadFromCheckpoint(id)
+	if err != nil {
+		return nil, fmt.Errorf("no checkpoint, branch or message %s", id)
+	}
+	return messages, nil
+}
+
+// Diff compares the conversations at two checkpoints, branches or messages.
+func (ct *ConversationTree) Diff(a, b string) (ConversationDiff, error) {
+	left, err := ct.MessagesAt(a)
+	if err != nil {
+		return ConversationDiff{}, err
+	}
+	right, err := ct.MessagesAt(b)
+	if err != nil {
+		return ConversationDiff{}, err
+	}
+	return DiffConversations(left, right), nil
+}
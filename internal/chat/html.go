@@ -0,0 +1,242 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+const (
+	lightCodeStyle = "github"
+	darkCodeStyle  = "github-dark"
+)
+
+// HTMLOptions controls how a chat is rendered as HTML.
+type HTMLOptions struct {
+	Dark        bool // Start in the dark theme
+	ThemeToggle bool // Add a button that switches between light and dark
+}
+
+// RenderHTML renders a chat as a standalone HTML page with inline CSS, for
+// sharing with people who don't use lil_guy. Message content is rendered as
+// markdown with highlighted code blocks; raw HTML in messages is dropped.
+func RenderHTML(history ChatHistory, opts HTMLOptions) (string, error) {
+	title := history.Title
+	if title == "" {
+		title = GenerateTitle(history.Messages)
+	}
+	date := history.CreatedAt
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	css, err := htmlCSS()
+	if err != nil {
+		return "", err
+	}
+
+	theme := "light"
+	if opts.Dark {
+		theme = "dark"
+	}
+
+	markdown := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(codeBlockRenderer{}, 100)),
+		),
+	)
+
+	var page strings.Builder
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html lang=\"en\" data-theme=\"%s\">\n<head>\n", theme)
+	page.WriteString("<meta charset=\"utf-8\">\n<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&page, "<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n", html.EscapeString(title), css)
+
+	page.WriteString("<header>\n")
+	if opts.ThemeToggle {
+		page.WriteString(`<button class="theme-toggle" onclick="var d=document.documentElement;d.dataset.theme=d.dataset.theme==='dark'?'light':'dark'">Light / dark</button>` + "\n")
+	}
+	fmt.Fprintf(&page, "<h1>%s</h1>\n<p class=\"meta\">", html.EscapeString(title))
+	if history.BuddyName != "" {
+		fmt.Fprintf(&page, "Chat with %s &middot; ", html.EscapeString(history.BuddyName))
+	}
+	if history.Model != "" {
+		fmt.Fprintf(&page, "%s &middot; ", html.EscapeString(history.Model))
+	}
+	fmt.Fprintf(&page, "%s &middot; %d messages</p>\n</header>\n<main>\n", date.Format("January 2, 2006 15:04"), len(history.Messages))
+
+	for _, msg := range history.Messages {
+		role := msg.Role
+		if role != "user" && role != "system" {
+			role = "assistant"
+		}
+
+		fmt.Fprintf(&page, "<section class=\"message %s\">\n<div class=\"message-header\"><span class=\"role\">%s</span>",
+			role, html.EscapeString(RoleLabel(msg.Role, history.BuddyName)))
+		if msg.Model != "" && role == "assistant" {
+			fmt.Fprintf(&page, "<span class=\"model\">%s</span>", html.EscapeString(msg.Model))
+		}
+		if !msg.Timestamp.IsZero() {
+			fmt.Fprintf(&page, "<time datetime=\"%s\">%s</time>",
+				msg.Timestamp.Format(time.RFC3339), msg.Timestamp.Format("Jan 2, 15:04"))
+		}
+		page.WriteString("</div>\n<div class=\"content\">\n")
+
+		var content bytes.Buffer
+		if err := markdown.Convert([]byte(msg.Content), &content); err != nil {
+			return "", fmt.Errorf("failed to render message: %w", err)
+		}
+		page.Write(content.Bytes())
+		page.WriteString("</div>\n</section>\n")
+	}
+
+	page.WriteString("</main>\n</body>\n</html>\n")
+	return page.String(), nil
+}
+
+// ExportToHTML exports a chat to an HTML file next to the saved chats and
+// returns its filename.
+func ExportToHTML(history ChatHistory, opts HTMLOptions) (string, error) {
+	if len(history.Messages) == 0 {
+		return "", fmt.Errorf("no messages to export")
+	}
+
+	page, err := RenderHTML(history, opts)
+	if err != nil {
+		return "", err
+	}
+
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return "", err
+	}
+
+	filename := fmt.Sprintf("chat_%s.html", time.Now().Format("2006-01-02_15-04-05"))
+	if history.ID != "" {
+		filename = history.ID + ".html"
+	}
+	filePath := filepath.Join(historyDir, filename)
+
+	return filename, os.WriteFile(filePath, []byte(page), filePermissions)
+}
+
+// pageCSS styles the page in both themes; the code colours come from chroma.
+const pageCSS = `:root { color-scheme: light; --bg: #ffffff; --fg: #1f2328; --muted: #656d76; --border: #d0d7de;
+  --user: #ddf4ff; --assistant: #f6f8fa; --system: #fff8c5; --accent: #0969da; }
+html[data-theme="dark"] { color-scheme: dark; --bg: #0d1117; --fg: #e6edf3; --muted: #8d96a0; --border: #30363d;
+  --user: #0c2d48; --assistant: #161b22; --system: #2e2a12; --accent: #4493f8; }
+body { margin: 0 auto; max-width: 860px; padding: 24px; background: var(--bg); color: var(--fg);
+  font: 16px/1.6 -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; }
+header { border-bottom: 1px solid var(--border); margin-bottom: 24px; }
+h1 { margin: 0 0 4px; font-size: 1.6em; }
+.meta { color: var(--muted); margin: 0 0 16px; }
+.theme-toggle { float: right; background: none; color: var(--fg); border: 1px solid var(--border);
+  border-radius: 6px; padding: 4px 10px; cursor: pointer; }
+.message { border: 1px solid var(--border); border-radius: 8px; padding: 12px 16px; margin: 0 0 16px; }
+.message.user { background: var(--user); }
+.message.assistant { background: var(--assistant); }
+.message.system { background: var(--system); font-size: 0.9em; }
+.message-header { display: flex; gap: 12px; align-items: baseline; margin-bottom: 4px; }
+.role { font-weight: 600; }
+.model, time { color: var(--muted); font-size: 0.85em; }
+time { margin-left: auto; }
+.content > :first-child { margin-top: 0; }
+.content > :last-child { margin-bottom: 0; }
+a { color: var(--accent); }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 0.9em; }
+:not(pre) > code { background: var(--border); border-radius: 4px; padding: 0.1em 0.35em; }
+pre { padding: 12px; border-radius: 6px; overflow-x: auto; line-height: 1.45; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid var(--border); padding: 4px 10px; }
+blockquote { margin: 0; padding-left: 12px; border-left: 3px solid var(--border); color: var(--muted); }
+`
+
+// htmlCSS returns the page CSS with chroma's light and dark code styles.
+func htmlCSS() (string, error) {
+	var css strings.Builder
+	css.WriteString(pageCSS)
+
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	for _, theme := range []struct{ selector, style string }{
+		{`html[data-theme="light"]`, lightCodeStyle},
+		{`html[data-theme="dark"]`, darkCodeStyle},
+	} {
+		var styleCSS bytes.Buffer
+		if err := formatter.WriteCSS(&styleCSS, styles.Get(theme.style)); err != nil {
+			return "", fmt.Errorf("failed to write code styles: %w", err)
+		}
+
+		// Scope each rule to its theme; chroma writes one rule per line
+		for _, line := range strings.Split(strings.TrimSpace(styleCSS.String()), "\n") {
+			if _, rule, ok := strings.Cut(line, "*/ "); ok {
+				line = rule
+			}
+			css.WriteString(theme.selector + " " + line + "\n")
+		}
+	}
+	return css.String(), nil
+}
+
+// codeBlockRenderer renders fenced and indented code blocks with chroma.
+type codeBlockRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (r codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderCodeBlock)
+	reg.Register(ast.KindCodeBlock, r.renderCodeBlock)
+}
+
+// renderCodeBlock highlights a code block, guessing the language when the
+// fence doesn't name one.
+func (r codeBlockRenderer) renderCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var code strings.Builder
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		code.Write(segment.Value(source))
+	}
+
+	var lexer chroma.Lexer
+	if fenced, ok := node.(*ast.FencedCodeBlock); ok {
+		if language := fenced.Language(source); language != nil {
+			lexer = lexers.Get(string(language))
+		}
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(code.String())
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		fmt.Fprintf(w, "<pre><code>%s</code></pre>\n", html.EscapeString(code.String()))
+		return ast.WalkSkipChildren, nil
+	}
+
+	// The style only matters for inline colours; classes are styled by htmlCSS
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	if err := formatter.Format(w, styles.Get(lightCodeStyle), iterator); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, nil
+}
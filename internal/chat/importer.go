@@ -0,0 +1,660 @@
+package chat
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sources of imported conversations, recorded in ChatHistory.Source.
+const (
+	SourceChatGPT = "chatgpt"
+	SourceClaude  = "claude"
+)
+
+const (
+	exportConversationsFile = "conversations.json" // Name used by both ChatGPT and Claude.ai exports
+)
+
+// importNode is one message in an imported conversation. Both export formats
+// store conversations as trees, where editing or regenerating a message adds
+// a sibling.
+type importNode struct {
+	id       string
+	parent   string
+	message  *ChatMessage // nil for nodes that aren't shown, like tool calls
+	children []string
+}
+
+// importedConversation is a conversation read from an export, before it is
+// turned into a chat or, if it branched, a conversation tree.
+type importedConversation struct {
+	source    string
+	sourceID  string
+	title     string
+	buddyName string
+	model     string
+	createdAt time.Time
+	updatedAt time.Time
+	nodes     map[string]*importNode
+	order     []string // Node IDs in the order they appeared in the export
+	current   string   // Last message of the branch the user had open
+}
+
+// ImportConversations imports a ChatGPT or Claude.ai data export into the
+// store. path may be the export's zip file, the folder it unzips to or its
+// conversations.json. Conversations that branched are saved as conversation
+// trees. Conversations imported before are skipped, even if they have since
+// been moved to the trash.
+func ImportConversations(path string) (ImportStats, error) {
+	var stats ImportStats
+
+	data, err := readExport(path)
+	if err != nil {
+		return stats, err
+	}
+	conversations, err := parseExport(data)
+	if err != nil {
+		return stats, err
+	}
+
+	var imported []*ChatHistory
+	store := DefaultStore()
+	err = store.Update(func(tx Store) error {
+		seen, err := importedSources(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, conv := range conversations {
+			key := conv.source + "\x00" + conv.sourceID
+			if seen[key] {
+				stats.Skipped++
+				continue
+			}
+			seen[key] = true
+
+			paths := conv.paths()
+			if len(paths) == 0 {
+				stats.Skipped++
+				continue // Nothing but hidden messages
+			}
+			if len(paths) == 1 {
+				history := conv.toChat(paths[0])
+				if err := tx.SaveChat(history); err != nil {
+					return fmt.Errorf("failed to import %q: %w", conv.title, err)
+				}
+				imported = append(imported, history)
+				stats.Chats++
+				continue
+			}
+
+			if err := tx.SaveTree(newID("tree", conv.createdAt), conv.toTree(paths)); err != nil {
+				return fmt.Errorf("failed to import %q: %w", conv.title, err)
+			}
+			stats.Trees++
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	updateSearchIndex(imported...)
+	return stats, nil
+}
+
+// readExport returns the conversations.json from an export zip, folder or file.
+func readExport(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %w", err)
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, exportConversationsFile)
+	}
+
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read export: %w", err)
+		}
+		return data, nil
+	}
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %w", err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if filepath.Base(file.Name) != exportConversationsFile {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read export: %w", err)
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return nil, fmt.Errorf("no %s in %s", exportConversationsFile, path)
+}
+
+// parseExport detects whether data is a ChatGPT or Claude.ai export and parses it.
+func parseExport(data []byte) ([]*importedConversation, error) {
+	var probe []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse export: %w", err)
+	}
+	if len(probe) == 0 {
+		return nil, nil
+	}
+
+	if _, ok := probe[0]["mapping"]; ok {
+		return parseChatGPTExport(data)
+	}
+	if _, ok := probe[0]["chat_messages"]; ok {
+		return parseClaudeExport(data)
+	}
+	return nil, fmt.Errorf("unrecognized export: expected a ChatGPT or Claude.ai conversations.json")
+}
+
+// chatGPTConversation is a conversation in a ChatGPT conversations.json.
+type chatGPTConversation struct {
+	ID               string   `json:"id"`
+	ConversationID   string   `json:"conversation_id"`
+	Title            string   `json:"title"`
+	CreateTime       *float64 `json:"create_time"`
+	UpdateTime       *float64 `json:"update_time"`
+	CurrentNode      string   `json:"current_node"`
+	DefaultModelSlug string   `json:"default_model_slug"`
+	Mapping          map[string]struct {
+		ID       string   `json:"id"`
+		Parent   *string  `json:"parent"`
+		Children []string `json:"children"`
+		Message  *struct {
+			Author struct {
+				Role string `json:"role"`
+			} `json:"author"`
+			CreateTime *float64 `json:"create_time"`
+			Content    struct {
+				ContentType string            `json:"content_type"`
+				Parts       []json.RawMessage `json:"parts"`
+				Text        string            `json:"text"`
+				Language    string            `json:"language"`
+			} `json:"content"`
+			Metadata struct {
+				ModelSlug string `json:"model_slug"`
+				Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+			} `json:"metadata"`
+		} `json:"message"`
+	} `json:"mapping"`
+}
+
+// parseChatGPTExport parses a ChatGPT conversations.json.
+func parseChatGPTExport(data []byte) ([]*importedConversation, error) {
+	var exported []chatGPTConversation
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return nil, fmt.Errorf("failed to parse ChatGPT export: %w", err)
+	}
+
+	var conversations []*importedConversation
+	for _, c := range exported {
+		conv := &importedConversation{
+			source:    SourceChatGPT,
+			sourceID:  c.ConversationID,
+			title:     c.Title,
+			buddyName: "ChatGPT",
+			model:     c.DefaultModelSlug,
+			createdAt: unixSeconds(c.CreateTime),
+			updatedAt: unixSeconds(c.UpdateTime),
+			nodes:     map[string]*importNode{},
+			current:   c.CurrentNode,
+		}
+		if conv.sourceID == "" {
+			conv.sourceID = c.ID
+		}
+
+		// Map iteration order is random, so order nodes by time, then ID
+		ids := make([]string, 0, len(c.Mapping))
+		for id := range c.Mapping {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			a, b := c.Mapping[ids[i]].Message, c.Mapping[ids[j]].Message
+			var ta, tb float64
+			if a != nil && a.CreateTime != nil {
+				ta = *a.CreateTime
+			}
+			if b != nil && b.CreateTime != nil {
+				tb = *b.CreateTime
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			return ids[i] < ids[j]
+		})
+
+		for _, id := range ids {
+			entry := c.Mapping[id]
+			node := &importNode{id: id, children: entry.Children}
+			if entry.Parent != nil {
+				node.parent = *entry.Parent
+			}
+
+			if msg := entry.Message; msg != nil && !msg.Metadata.Hidden {
+				content := chatGPTContent(msg.Content.ContentType, msg.Content.Parts, msg.Content.Text, msg.Content.Language)
+				role := msg.Author.Role
+				if content != "" && (role == "user" || role == "assistant" || role == "system") {
+					node.message = &ChatMessage{
+						Role:      role,
+						Content:   content,
+						Timestamp: unixSeconds(msg.CreateTime),
+						Model:     msg.Metadata.ModelSlug,
+					}
+				}
+			}
+			conv.nodes[id] = node
+			conv.order = append(conv.order, id)
+		}
+
+		conversations = append(conversations, conv)
+	}
+	return conversations, nil
+}
+
+// chatGPTContent returns the text of a ChatGPT message. Images, browsing
+// results, reasoning and other non-text content are left out.
+func chatGPTContent(contentType string, parts []json.RawMessage, text, language string) string {
+	switch contentType {
+	case "text", "multimodal_text":
+		var texts []string
+		for _, part := range parts {
+			var s string
+			if json.Unmarshal(part, &s) == nil && strings.TrimSpace(s) != "" {
+				texts = append(texts, s)
+			}
+		}
+		return strings.TrimSpace(strings.Join(texts, "\n\n"))
+	case "code":
+		if strings.TrimSpace(text) == "" {
+			return ""
+		}
+		if language == "unknown" {
+			language = ""
+		}
+		return fmt.Sprintf("```%s\n%s\n```", language, strings.TrimSpace(text))
+	}
+	return ""
+}
+
+// unixSeconds converts a ChatGPT timestamp to a time, or zero if it is missing.
+func unixSeconds(seconds *float64) time.Time {
+	if seconds == nil || *seconds <= 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(*seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+// claudeConversation is a conversation in a Claude.ai conversations.json.
+type claudeConversation struct {
+	UUID                   string    `json:"uuid"`
+	Name                   string    `json:"name"`
+	Model                  string    `json:"model"`
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at"`
+	CurrentLeafMessageUUID string    `json:"current_leaf_message_uuid"`
+	ChatMessages           []struct {
+		UUID              string    `json:"uuid"`
+		ParentMessageUUID string    `json:"parent_message_uuid"`
+		Sender            string    `json:"sender"`
+		Text              string    `json:"text"`
+		CreatedAt         time.Time `json:"created_at"`
+		Content           []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"chat_messages"`
+}
+
+// parseClaudeExport parses a Claude.ai conversations.json. Older exports don't
+// record parent messages, so each message follows the one before it.
+func parseClaudeExport(data []byte) ([]*importedConversation, error) {
+	var exported []claudeConversation
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return nil, fmt.Errorf("failed to parse Claude export: %w", err)
+	}
+
+	var conversations []*importedConversation
+	for _, c := range exported {
+		conv := &importedConversation{
+			source:    SourceClaude,
+			sourceID:  c.UUID,
+			title:     c.Name,
+			buddyName: "Claude",
+			model:     c.Model,
+			createdAt: c.CreatedAt,
+			updatedAt: c.UpdatedAt,
+			nodes:     map[string]*importNode{},
+			current:   c.CurrentLeafMessageUUID,
+		}
+
+		previous := ""
+		for _, m := range c.ChatMessages {
+			parent := m.ParentMessageUUID
+			if parent == "" {
+				parent = previous
+			}
+			node := &importNode{id: m.UUID, parent: parent}
+			previous = m.UUID
+
+			// Newer exports split the text into content blocks
+			text := m.Text
+			if len(m.Content) > 0 {
+				var texts []string
+				for _, block := range m.Content {
+					if block.Type == "text" && strings.TrimSpace(block.Text) != "" {
+						texts = append(texts, block.Text)
+					}
+				}
+				text = strings.Join(texts, "\n\n")
+			}
+
+			role := m.Sender
+			if role == "human" {
+				role = "user"
+			}
+			if text = strings.TrimSpace(text); text != "" && (role == "user" || role == "assistant") {
+				node.message = &ChatMessage{Role: role, Content: text, Timestamp: m.CreatedAt, Model: c.Model}
+			}
+			conv.nodes[m.UUID] = node
+			conv.order = append(conv.order, m.UUID)
+		}
+
+		// Link children in export order; unknown parents are roots
+		for _, id := range conv.order {
+			node := conv.nodes[id]
+			if parent, ok := conv.nodes[node.parent]; ok {
+				parent.children = append(parent.children, id)
+			} else {
+				node.parent = ""
+			}
+		}
+
+		conversations = append(conversations, conv)
+	}
+	return conversations, nil
+}
+
+// paths returns the distinct message sequences from the conversation's roots
+// to its leaves, with the branch the user had open first.
+func (c *importedConversation) paths() [][]*importNode {
+	var leaves [][]*importNode
+	var walk func(id string, path []*importNode)
+	walk = func(id string, path []*importNode) {
+		node, ok := c.nodes[id]
+		if !ok {
+			return
+		}
+		if node.message != nil {
+			path = append(path[:len(path):len(path)], node)
+		}
+
+		var children []string
+		for _, child := range node.children {
+			if _, ok := c.nodes[child]; ok {
+				children = append(children, child)
+			}
+		}
+		if len(children) == 0 {
+			leaves = append(leaves, path)
+			return
+		}
+		for _, child := range children {
+			walk(child, path)
+		}
+	}
+	for _, id := range c.order {
+		if node := c.nodes[id]; node.parent == "" || c.nodes[node.parent] == nil {
+			walk(id, nil)
+		}
+	}
+
+	// Leaves that are hidden nodes can repeat a path or end part way along another
+	var paths [][]*importNode
+	for i, path := range leaves {
+		if len(path) == 0 {
+			continue
+		}
+		covered := false
+		for j, other := range leaves {
+			if i != j && isPathPrefix(path, other) && (len(path) < len(other) || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			paths = append(paths, path)
+		}
+	}
+
+	// The open branch, or failing that the most recent, goes first
+	main := -1
+	for i, path := range paths {
+		if c.current != "" && pathLeadsTo(c.nodes, path, c.current) {
+			main = i
+			break
+		}
+	}
+	if main < 0 {
+		for i, path := range paths {
+			if main < 0 || path[len(path)-1].message.Timestamp.After(paths[main][len(paths[main])-1].message.Timestamp) {
+				main = i
+			}
+		}
+	}
+	if main > 0 {
+		paths[0], paths[main] = paths[main], paths[0]
+	}
+	return paths
+}
+
+// isPathPrefix reports whether path is the start of other.
+func isPathPrefix(path, other []*importNode) bool {
+	if len(path) > len(other) {
+		return false
+	}
+	for i := range path {
+		if path[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pathLeadsTo reports whether the node id, or the last shown message before
+// it, ends path.
+func pathLeadsTo(nodes map[string]*importNode, path []*importNode, id string) bool {
+	for node := nodes[id]; node != nil; node = nodes[node.parent] {
+		if node.message != nil {
+			return path[len(path)-1] == node
+		}
+	}
+	return false
+}
+
+// messages returns copies of the messages along a path.
+func (c *importedConversation) messages(path []*importNode) []ChatMessage {
+	messages := make([]ChatMessage, len(path))
+	for i, node := range path {
+		messages[i] = *node.message
+	}
+	return messages
+}
+
+// timestamps fills in creation and update times missing from the export
+// from the messages, falling back to now.
+func (c *importedConversation) timestamps(messages []ChatMessage) (time.Time, time.Time) {
+	created, updated := c.createdAt, c.updatedAt
+	for _, msg := range messages {
+		if msg.Timestamp.IsZero() {
+			continue
+		}
+		if created.IsZero() || msg.Timestamp.Before(created) {
+			created = msg.Timestamp
+		}
+		if msg.Timestamp.After(updated) {
+			updated = msg.Timestamp
+		}
+	}
+	if created.IsZero() {
+		created = time.Now()
+	}
+	if updated.IsZero() {
+		updated = created
+	}
+	return created, updated
+}
+
+// modelFor returns the last model that replied in messages, or the
+// conversation's default model.
+func (c *importedConversation) modelFor(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "assistant" && messages[i].Model != "" {
+			return messages[i].Model
+		}
+	}
+	return c.model
+}
+
+// toChat converts a conversation that never branched to a chat.
+func (c *importedConversation) toChat(path []*importNode) *ChatHistory {
+	messages := c.messages(path)
+	created, updated := c.timestamps(messages)
+	return &ChatHistory{
+		ID:        newID("chat", created),
+		Title:     strings.TrimSpace(c.title),
+		Messages:  messages,
+		BuddyName: c.buddyName,
+		Model:     c.modelFor(messages),
+		Source:    c.source,
+		SourceID:  c.sourceID,
+		CreatedAt: created,
+		UpdatedAt: updated,
+	}
+}
+
+// toTree converts a branched conversation to a conversation tree. The branch
+// the user had open becomes main, and each alternative becomes a branch
+// whose head is the last message along it.
+func (c *importedConversation) toTree(paths [][]*importNode) *ConversationTree {
+	mainMessages := c.messages(paths[0])
+	created, updated := c.timestamps(mainMessages)
+
+	title := strings.TrimSpace(c.title)
+	if title == "" {
+		title = GenerateTitle(mainMessages)
+	}
+
+	tree := &ConversationTree{
+		Version:      treeVersion,
+		Nodes:        map[string]*MessageNode{},
+		RootBranchID: fmt.Sprintf("branch_%d_0", created.UnixNano()),
+		Branches:     map[string]*Branch{},
+		Title:        title,
+		BuddyName:    c.buddyName,
+		Model:        c.modelFor(mainMessages),
+		Source:       c.source,
+		SourceID:     c.sourceID,
+		CreatedAt:    created,
+		UpdatedAt:    updated,
+	}
+	tree.CurrentBranch = tree.RootBranchID
+
+	mainCheckpointID := fmt.Sprintf("checkpoint_%d_0", created.UnixNano())
+	for i, path := range paths {
+		messages := c.messages(path)
+		head := ""
+		for _, msg := range messages {
+			head = tree.AddMessage(head, msg).ID
+		}
+
+		branch := &Branch{
+			ID:          fmt.Sprintf("branch_%d_%d", created.UnixNano(), i),
+			Name:        "main",
+			Description: "Main conversation branch",
+			Head:        head,
+			CreatedAt:   created,
+			UpdatedAt:   updated,
+		}
+		checkpoint := Checkpoint{
+			ID:          fmt.Sprintf("checkpoint_%d_%d", created.UnixNano(), i),
+			Name:        title,
+			Description: fmt.Sprintf("Imported from %s", c.buddyName),
+			NodeID:      head,
+			CreatedAt:   created,
+		}
+
+		if i > 0 {
+			shared := 0
+			for shared < len(path) && shared < len(paths[0]) && path[shared] == paths[0][shared] {
+				shared++
+			}
+			branch.CreatedAt, branch.UpdatedAt = c.timestamps(messages[shared:])
+			branch.Name = fmt.Sprintf("alternative %d", i)
+			branch.Description = fmt.Sprintf("Diverges from main at message %d", shared+1)
+			checkpoint.Name = GenerateCheckpointName(messages)
+			checkpoint.CreatedAt = branch.CreatedAt
+			checkpoint.BranchFrom = mainCheckpointID
+		}
+		branch.Checkpoints = []Checkpoint{checkpoint}
+		tree.Branches[branch.ID] = branch
+	}
+	tree.UpdatedAt = updated
+	return tree
+}
+
+// importedSources returns the source and source ID of every chat and tree
+// that was imported before, joined by a NUL.
+func importedSources(store Store) (map[string]bool, error) {
+	seen := map[string]bool{}
+
+	chats, err := store.ListChats(ChatFilter{})
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := store.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range append(chats, trashed...) {
+		if summary.Source != "" {
+			seen[summary.Source+"\x00"+summary.SourceID] = true
+		}
+	}
+
+	trees, err := store.ListTrees()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range trees {
+		tree, err := store.LoadTree(id)
+		if err != nil {
+			continue // Skip files we can't read
+		}
+		if tree.Source != "" {
+			seen[tree.Source+"\x00"+tree.SourceID] = true
+		}
+	}
+	return seen, nil
+}
@@ -0,0 +1,540 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// The index lives next to the chats but without a .json suffix so ListChats skips it
+	searchIndexFile    = "search.idx"
+	searchIndexVersion = 2
+
+	// BM25 tuning parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// indexedChat records which version of a chat is in the index.
+type indexedChat struct {
+	ModTime  time.Time `json:"mod_time"` // The version stamp from Store.ChatVersions
+	Messages int       `json:"messages"`
+	Terms    []string  `json:"terms"` // Terms with postings for this chat, so removal needn't scan them all
+}
+
+// indexedMessage holds the metadata needed to filter and rank one message.
+type indexedMessage struct {
+	ChatID    string    `json:"chat_id"`
+	Index     int       `json:"index"`
+	Role      string    `json:"role"`
+	Model     string    `json:"model,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Length    int       `json:"length"` // Number of tokens
+}
+
+// SearchIndex is a persistent inverted index over the messages of all saved chats.
+type SearchIndex struct {
+	Version     int                         `json:"version"`
+	Chats       map[string]indexedChat      `json:"chats"`
+	Docs        map[string]indexedMessage   `json:"docs"`
+	Postings    map[string]map[string][]int `json:"postings"` // term -> doc key -> token positions
+	TotalTokens int                         `json:"total_tokens"`
+
+	path  string
+	dirty bool
+}
+
+// token is a normalized word and where it appears in the original text.
+type token struct {
+	Term  string
+	Start int // Byte offsets into the original text
+	End   int
+}
+
+// tokenize splits text into lowercase words made of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, token{Term: strings.ToLower(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{Term: strings.ToLower(text[start:]), Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+// docKey identifies a message in the index.
+func docKey(chatID string, index int) string {
+	return fmt.Sprintf("%s#%d", chatID, index)
+}
+
+// getSearchIndexPath returns the path of the search index file.
+func getSearchIndexPath() (string, error) {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(historyDir, searchIndexFile), nil
+}
+
+// newSearchIndex returns an empty index that saves to path.
+func newSearchIndex(path string) *SearchIndex {
+	return &SearchIndex{
+		Version:  searchIndexVersion,
+		Chats:    map[string]indexedChat{},
+		Docs:     map[string]indexedMessage{},
+		Postings: map[string]map[string][]int{},
+		path:     path,
+	}
+}
+
+// loadSearchIndex reads the index from disk. A missing, unreadable or outdated
+// index is replaced by an empty one that Sync will fill.
+func loadSearchIndex() (*SearchIndex, error) {
+	path, err := getSearchIndexPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newSearchIndex(path), nil
+		}
+		return nil, fmt.Errorf("failed to read search index: %w", err)
+	}
+
+	index := newSearchIndex(path)
+	if err := json.Unmarshal(data, index); err != nil || index.Version != searchIndexVersion {
+		// The index is derived data, so rebuild rather than fail
+		index = newSearchIndex(path)
+		index.dirty = true
+	}
+	index.path = path
+
+	return index, nil
+}
+
+// OpenSearchIndex loads the search index and brings it up to date with the store.
+func OpenSearchIndex() (*SearchIndex, error) {
+	index, err := loadSearchIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := index.Sync(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Sync indexes chats that are new or changed since they were last indexed and
+// drops chats that are gone, then saves the index if anything changed.
+func (idx *SearchIndex) Sync() error {
+	store := DefaultStore()
+	versions, err := store.ChatVersions()
+	if err != nil {
+		return err
+	}
+
+	for id, version := range versions {
+		if entry, ok := idx.Chats[id]; ok && entry.ModTime.Equal(version) {
+			continue
+		}
+
+		history, err := store.LoadChat(id)
+		if err != nil {
+			continue // Skip chats we can't read
+		}
+		idx.addChat(id, history, version)
+	}
+
+	for id := range idx.Chats {
+		if _, ok := versions[id]; !ok {
+			idx.removeChat(id)
+		}
+	}
+
+	if !idx.dirty {
+		return nil
+	}
+	return idx.Save()
+}
+
+// Save writes the index to disk.
+func (idx *SearchIndex) Save() error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal search index: %w", err)
+	}
+	if err := writeFileAtomic(idx.path, data); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	idx.dirty = false
+	return nil
+}
+
+// addChat (re)indexes every message in a chat.
+func (idx *SearchIndex) addChat(chatID string, history *ChatHistory, modTime time.Time) {
+	idx.removeChat(chatID)
+
+	terms := map[string]bool{}
+	for i, msg := range history.Messages {
+		key := docKey(chatID, i)
+		tokens := tokenize(msg.Content)
+		for pos, tok := range tokens {
+			postings, ok := idx.Postings[tok.Term]
+			if !ok {
+				postings = map[string][]int{}
+				idx.Postings[tok.Term] = postings
+			}
+			postings[key] = append(postings[key], pos)
+			terms[tok.Term] = true
+		}
+
+		model := msg.Model
+		if model == "" {
+			model = history.Model
+		}
+		idx.Docs[key] = indexedMessage{
+			ChatID:    chatID,
+			Index:     i,
+			Role:      msg.Role,
+			Model:     model,
+			Timestamp: msg.Timestamp,
+			Length:    len(tokens),
+		}
+		idx.TotalTokens += len(tokens)
+	}
+
+	entry := indexedChat{ModTime: modTime, Messages: len(history.Messages)}
+	for term := range terms {
+		entry.Terms = append(entry.Terms, term)
+	}
+	sort.Strings(entry.Terms)
+	idx.Chats[chatID] = entry
+	idx.dirty = true
+}
+
+// removeChat drops every message of a chat from the index.
+func (idx *SearchIndex) removeChat(chatID string) {
+	entry, ok := idx.Chats[chatID]
+	if !ok {
+		return
+	}
+
+	var removed []string
+	for i := 0; i < entry.Messages; i++ {
+		key := docKey(chatID, i)
+		if doc, ok := idx.Docs[key]; ok {
+			idx.TotalTokens -= doc.Length
+			delete(idx.Docs, key)
+			removed = append(removed, key)
+		}
+	}
+
+	for _, term := range entry.Terms {
+		postings := idx.Postings[term]
+		for _, key := range removed {
+			delete(postings, key)
+		}
+		if len(postings) == 0 {
+			delete(idx.Postings, term)
+		}
+	}
+
+	delete(idx.Chats, chatID)
+	idx.dirty = true
+}
+
+// updateSearchIndex indexes chats that were just saved, loading and saving
+// the index once however many there are. Callers may ignore its error: Sync
+// rebuilds anything missed from the store on the next search.
+func updateSearchIndex(histories ...*ChatHistory) error {
+	if len(histories) == 0 {
+		return nil
+	}
+	ids := make([]string, len(histories))
+	for i, history := range histories {
+		ids[i] = history.ID
+	}
+	versions, err := DefaultStore().ChatVersions(ids...)
+	if err != nil {
+		return err
+	}
+	index, err := loadSearchIndex()
+	if err != nil {
+		return err
+	}
+	for _, history := range histories {
+		index.addChat(history.ID, history, versions[history.ID])
+	}
+	return index.Save()
+}
+
+// removeFromSearchIndex drops deleted chats from the index. Like
+// updateSearchIndex, a failure is repaired by Sync on the next search.
+func removeFromSearchIndex(chatIDs ...string) error {
+	if len(chatIDs) == 0 {
+		return nil
+	}
+	index, err := loadSearchIndex()
+	if err != nil {
+		return err
+	}
+	for _, id := range chatIDs {
+		index.removeChat(id)
+	}
+	return index.Save()
+}
+
+// expandTerm returns the indexed terms a query term stands for; a trailing *
+// matches every term with that prefix.
+func (idx *SearchIndex) expandTerm(term string) []string {
+	if !strings.HasSuffix(term, "*") {
+		if _, ok := idx.Postings[term]; ok {
+			return []string{term}
+		}
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(term, "*")
+	var terms []string
+	for t := range idx.Postings {
+		if strings.HasPrefix(t, prefix) {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// matchesFilters reports whether a message passes the query's role, model and date filters.
+func (q SearchQuery) matchesFilters(doc indexedMessage) bool {
+	if q.Role != "" && doc.Role != q.Role {
+		return false
+	}
+	if q.Role == "" && doc.Role == "system" {
+		return false // System prompts are noise unless asked for
+	}
+	if q.Model != "" && !strings.HasPrefix(strings.ToLower(doc.Model), q.Model) {
+		return false
+	}
+	if !q.After.IsZero() && doc.Timestamp.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !doc.Timestamp.Before(q.Before) {
+		return false
+	}
+	return true
+}
+
+// hasPhrase reports whether a document contains the phrase's terms in order.
+func (idx *SearchIndex) hasPhrase(key string, phrase []string) bool {
+	if len(phrase) == 0 {
+		return true
+	}
+
+	first, ok := idx.Postings[phrase[0]][key]
+	if !ok {
+		return false
+	}
+
+	for _, start := range first {
+		matched := true
+		for offset, term := range phrase[1:] {
+			if !containsInt(idx.Postings[term][key], start+offset+1) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// containsInt reports whether a sorted position list contains n.
+func containsInt(positions []int, n int) bool {
+	i := sort.SearchInts(positions, n)
+	return i < len(positions) && positions[i] == n
+}
+
+// rank returns the messages matching a query, best matches first. Every
+// term and phrase must appear in a message for it to match.
+func (idx *SearchIndex) rank(q SearchQuery) []scoredDoc {
+	// Collect the indexed terms each query term expands to
+	var groups [][]string
+	for _, term := range q.Terms {
+		expanded := idx.expandTerm(term)
+		if len(expanded) == 0 {
+			return nil
+		}
+		groups = append(groups, expanded)
+	}
+	for _, phrase := range q.Phrases {
+		for _, term := range phrase {
+			if _, ok := idx.Postings[term]; !ok {
+				return nil
+			}
+			groups = append(groups, []string{term})
+		}
+	}
+
+	// Candidates are documents containing every group; with no terms, every document is a candidate
+	var candidates map[string]bool
+	for _, group := range groups {
+		matches := map[string]bool{}
+		for _, term := range group {
+			for key := range idx.Postings[term] {
+				if candidates == nil || candidates[key] {
+					matches[key] = true
+				}
+			}
+		}
+		candidates = matches
+	}
+	if candidates == nil {
+		candidates = map[string]bool{}
+		for key := range idx.Docs {
+			candidates[key] = true
+		}
+	}
+
+	docCount := float64(len(idx.Docs))
+	avgLength := 1.0
+	if len(idx.Docs) > 0 && idx.TotalTokens > 0 {
+		avgLength = float64(idx.TotalTokens) / docCount
+	}
+
+	var results []scoredDoc
+	for key := range candidates {
+		doc, ok := idx.Docs[key]
+		if !ok || !q.matchesFilters(doc) {
+			continue
+		}
+
+		phrasesMatch := true
+		for _, phrase := range q.Phrases {
+			if !idx.hasPhrase(key, phrase) {
+				phrasesMatch = false
+				break
+			}
+		}
+		if !phrasesMatch {
+			continue
+		}
+
+		score := 0.0
+		var matched []string
+		for _, group := range groups {
+			for _, term := range group {
+				positions := idx.Postings[term][key]
+				if len(positions) == 0 {
+					continue
+				}
+				matched = append(matched, term)
+
+				df := float64(len(idx.Postings[term]))
+				tf := float64(len(positions))
+				idf := math.Log(1 + (docCount-df+0.5)/(df+0.5))
+				norm := 1 - bm25B + bm25B*float64(doc.Length)/avgLength
+				score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+			}
+		}
+
+		results = append(results, scoredDoc{doc: doc, score: score, terms: matched})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].doc.Timestamp.After(results[j].doc.Timestamp)
+	})
+
+	return results
+}
+
+// scoredDoc is a ranked search hit before its message content is loaded.
+type scoredDoc struct {
+	doc   indexedMessage
+	score float64
+	terms []string
+}
+
+const snippetRadius = 60 // Bytes of context on each side of the first match
+
+// makeSnippet cuts a single-line excerpt around the first matched term and
+// returns it with the byte spans of every matched term inside it.
+func makeSnippet(content string, terms []string) (string, []Span) {
+	matchSet := map[string]bool{}
+	for _, term := range terms {
+		matchSet[term] = true
+	}
+
+	tokens := tokenize(content)
+	center := 0
+	for _, tok := range tokens {
+		if matchSet[tok.Term] {
+			center = tok.Start
+			break
+		}
+	}
+
+	start := center - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	end := center + snippetRadius*2
+	if end > len(content) {
+		end = len(content)
+	}
+
+	// Don't cut runes in half
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = "…"
+	}
+	if end < len(content) {
+		suffix = "…"
+	}
+
+	body := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, content[start:end])
+	snippet := prefix + body + suffix
+
+	var highlights []Span
+	for _, tok := range tokenize(snippet) {
+		if matchSet[tok.Term] {
+			highlights = append(highlights, Span{Start: tok.Start, End: tok.End})
+		}
+	}
+
+	return snippet, highlights
+}
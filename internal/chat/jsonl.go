@@ -0,0 +1,281 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JSONL record formats for training and eval data.
+const (
+	FormatOpenAI    = "openai"    // {"messages": [{"role": "system", ...}, ...]}, for chat fine-tuning
+	FormatAnthropic = "anthropic" // {"system": "...", "messages": [...]}, alternating user and assistant
+
+	SourceJSONL = "jsonl"
+)
+
+// JSONLOptions controls how chats are written as JSONL records.
+type JSONLOptions struct {
+	Format         string   // FormatOpenAI (default) or FormatAnthropic
+	StripSystem    bool     // Leave out system prompts
+	Redact         bool     // Mask email addresses, phone numbers, IP addresses and API keys
+	RedactPatterns []string // Extra regular expressions to mask
+}
+
+// jsonlMessage is a message in a JSONL record. Content is a string on export;
+// on import it may also be a list of content blocks.
+type jsonlMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// jsonlRecord is one line of a JSONL file in either format.
+type jsonlRecord struct {
+	System   json.RawMessage `json:"system,omitempty"`
+	Messages []jsonlMessage  `json:"messages"`
+}
+
+// redactionRule replaces text matching a pattern.
+type redactionRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// defaultRedactions mask common personal data and secrets. API keys go first
+// so their digits aren't taken for phone numbers.
+var defaultRedactions = []redactionRule{
+	{regexp.MustCompile(`\b(sk-(?:ant-|proj-)?[A-Za-z0-9_-]{16,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{30,}|xox[abprs]-[A-Za-z0-9-]{10,})\b`), "[API_KEY]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`), "[IP_ADDRESS]"},
+	{regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\) ?|\b\d{3}[ .-])\d{3}[ .-]\d{4}\b`), "[PHONE]"},
+}
+
+// redactor returns a function that masks text according to opts, or nil if
+// nothing is redacted.
+func redactor(opts JSONLOptions) (func(string) string, error) {
+	var rules []redactionRule
+	if opts.Redact {
+		rules = append(rules, defaultRedactions...)
+	}
+	for _, pattern := range opts.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		rules = append(rules, redactionRule{re, "[REDACTED]"})
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	return func(text string) string {
+		for _, rule := range rules {
+			text = rule.pattern.ReplaceAllString(text, rule.replacement)
+		}
+		return text
+	}, nil
+}
+
+// systemPrompt returns the prompt a chat was held with: the saved prompt, or
+// for imported chats the system messages it opens with.
+func systemPrompt(history *ChatHistory) string {
+	if history.Settings != nil && history.Settings.SystemPrompt != "" {
+		return history.Settings.SystemPrompt
+	}
+
+	var prompts []string
+	for _, msg := range history.Messages {
+		if msg.Role != "system" {
+			break
+		}
+		prompts = append(prompts, msg.Content)
+	}
+	return strings.Join(prompts, "\n\n")
+}
+
+// ExportJSONL writes each chat as one JSONL record and returns how many were
+// written. Notices shown as system messages during a chat are left out, and
+// chats without an assistant reply are skipped since they teach nothing.
+func ExportJSONL(w io.Writer, chats []*ChatHistory, opts JSONLOptions) (int, error) {
+	if opts.Format == "" {
+		opts.Format = FormatOpenAI
+	}
+	if opts.Format != FormatOpenAI && opts.Format != FormatAnthropic {
+		return 0, fmt.Errorf("unknown JSONL format %q, want %s or %s", opts.Format, FormatOpenAI, FormatAnthropic)
+	}
+	redact, err := redactor(opts)
+	if err != nil {
+		return 0, err
+	}
+	clean := func(text string) json.RawMessage {
+		if redact != nil {
+			text = redact(text)
+		}
+		data, _ := json.Marshal(text)
+		return data
+	}
+
+	encoder := json.NewEncoder(w)
+	written := 0
+	for _, history := range chats {
+		var messages []jsonlMessage
+		hasReply := false
+		for _, msg := range history.Messages {
+			if msg.Role != "user" && msg.Role != "assistant" {
+				continue
+			}
+			hasReply = hasReply || msg.Role == "assistant"
+
+			// Anthropic's format needs turns to alternate, so merge repeats
+			if last := len(messages) - 1; opts.Format == FormatAnthropic && last >= 0 && messages[last].Role == msg.Role {
+				var previous string
+				if err := json.Unmarshal(messages[last].Content, &previous); err != nil {
+					return written, fmt.Errorf("failed to merge %s turns: %w", msg.Role, err)
+				}
+				messages[last].Content = clean(previous + "\n\n" + msg.Content)
+				continue
+			}
+			messages = append(messages, jsonlMessage{Role: msg.Role, Content: clean(msg.Content)})
+		}
+		if !hasReply {
+			continue
+		}
+
+		record := jsonlRecord{Messages: messages}
+		if prompt := systemPrompt(history); prompt != "" && !opts.StripSystem {
+			if opts.Format == FormatAnthropic {
+				record.System = clean(prompt)
+			} else {
+				record.Messages = append([]jsonlMessage{{Role: "system", Content: clean(prompt)}}, messages...)
+			}
+		}
+		if opts.Format == FormatAnthropic && messages[0].Role != "user" {
+			continue // The API requires the user to speak first
+		}
+
+		if err := encoder.Encode(record); err != nil {
+			return written, fmt.Errorf("failed to write record: %w", err)
+		}
+		written++
+	}
+	return written, nil
+}
+
+// jsonlText returns the text of a JSONL content field, which is either a
+// string or a list of content blocks.
+func jsonlText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &blocks) != nil {
+		return ""
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// ImportJSONL saves each record of an OpenAI or Anthropic-style JSONL file as
+// a chat. Records that were imported before are skipped.
+func ImportJSONL(r io.Reader) (ImportStats, error) {
+	var stats ImportStats
+	var histories []*ChatHistory
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // Records can be long
+	now := time.Now()
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record jsonlRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return stats, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		history := &ChatHistory{Source: SourceJSONL, CreatedAt: now, UpdatedAt: now}
+		prompts := []string{}
+		if system := jsonlText(record.System); system != "" {
+			prompts = append(prompts, system)
+		}
+		for _, msg := range record.Messages {
+			content := jsonlText(msg.Content)
+			switch {
+			case msg.Role == "system" || msg.Role == "developer":
+				prompts = append(prompts, content)
+			case (msg.Role == "user" || msg.Role == "assistant") && content != "":
+				history.Messages = append(history.Messages, ChatMessage{Role: msg.Role, Content: content, Timestamp: now})
+			}
+		}
+		if len(history.Messages) == 0 {
+			stats.Skipped++
+			continue
+		}
+		if len(prompts) > 0 {
+			history.Settings = &GenerationSettings{SystemPrompt: strings.Join(prompts, "\n\n")}
+		}
+
+		// The same conversation gets the same source ID whichever format it's in
+		hash := sha256.New()
+		json.NewEncoder(hash).Encode(history.Settings)
+		for _, msg := range history.Messages {
+			fmt.Fprintf(hash, "%s\x00%s\x00", msg.Role, msg.Content)
+		}
+		history.SourceID = hex.EncodeToString(hash.Sum(nil))[:16]
+		histories = append(histories, history)
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, fmt.Errorf("failed to read JSONL: %w", err)
+	}
+
+	var imported []*ChatHistory
+	err := DefaultStore().Update(func(tx Store) error {
+		seen, err := importedSources(tx)
+		if err != nil {
+			return err
+		}
+		for _, history := range histories {
+			key := history.Source + "\x00" + history.SourceID
+			if seen[key] {
+				stats.Skipped++
+				continue
+			}
+			seen[key] = true
+
+			history.ID = NewChatID()
+			if err := tx.SaveChat(history); err != nil {
+				return fmt.Errorf("failed to import chat: %w", err)
+			}
+			imported = append(imported, history)
+			stats.Chats++
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	updateSearchIndex(imported...)
+	return stats, nil
+}
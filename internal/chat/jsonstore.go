@@ -0,0 +1,415 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// The usage log sits next to the chats; its .jsonl suffix keeps it out of ListChats
+	usageLogFile = "usage.jsonl"
+
+	// Trashed chats move to this subdirectory of the chat history directory
+	trashDir = "trash"
+)
+
+// JSONStore keeps every chat and tree in its own JSON file under the home
+// directory. It is the original layout and the default store. Update gives no
+// atomicity: each write lands on its own.
+type JSONStore struct{}
+
+// SaveChat writes a chat to <id>.json as it is, timestamps included.
+func (JSONStore) SaveChat(history *ChatHistory) error {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat history: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(historyDir, history.ID+".json"), data); err != nil {
+		return fmt.Errorf("failed to write chat history file: %w", err)
+	}
+	return nil
+}
+
+// LoadChat reads a chat file.
+func (JSONStore) LoadChat(id string) (*ChatHistory, error) {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	return readChatFile(filepath.Join(historyDir, id+".json"), id)
+}
+
+// readChatFile reads and parses a chat file.
+func readChatFile(path, id string) (*ChatHistory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat file: %w", err)
+	}
+
+	var history ChatHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse chat file: %w", err)
+	}
+
+	// Chats saved before IDs existed are identified by their filename
+	if history.ID == "" {
+		history.ID = id
+	}
+
+	return &history, nil
+}
+
+// DeleteChat removes a chat file.
+func (JSONStore) DeleteChat(id string) error {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(historyDir, id+".json")); err != nil {
+		return fmt.Errorf("failed to delete chat file: %w", err)
+	}
+	return nil
+}
+
+// ListChats reads every chat file and returns the summaries that match the
+// filter, most recently updated first.
+func (s JSONStore) ListChats(filter ChatFilter) ([]ChatSummary, error) {
+	versions, err := s.ChatVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []ChatSummary
+	for id := range versions {
+		history, err := s.LoadChat(id)
+		if err != nil {
+			continue // Skip files we can't read
+		}
+		if summary := summarize(history); filter.matches(summary) {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+	if filter.Limit > 0 && len(summaries) > filter.Limit {
+		summaries = summaries[:filter.Limit]
+	}
+
+	return summaries, nil
+}
+
+// ChatVersions returns the modification time of each chat file.
+func (JSONStore) ChatVersions(ids ...string) (map[string]time.Time, error) {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]time.Time{}
+	if len(ids) > 0 {
+		for _, id := range ids {
+			info, err := os.Stat(filepath.Join(historyDir, id+".json"))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to stat chat file: %w", err)
+			}
+			versions[id] = info.ModTime()
+		}
+		return versions, nil
+	}
+
+	files, err := os.ReadDir(historyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat history directory: %w", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue // Skip files we can't read
+		}
+		versions[ChatIDFromFilename(file.Name())] = info.ModTime()
+	}
+
+	return versions, nil
+}
+
+// getTrashDir returns the trash directory, creating it if needed.
+func getTrashDir() (string, error) {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(historyDir, trashDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	return dir, nil
+}
+
+// moveChatFile writes a chat to dst and then removes src, so a crash in between
+// leaves a copy rather than nothing.
+func moveChatFile(history *ChatHistory, src, dst string) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat history: %w", err)
+	}
+	if err := writeFileAtomic(dst, data); err != nil {
+		return fmt.Errorf("failed to write chat history file: %w", err)
+	}
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("failed to remove chat file: %w", err)
+	}
+	return nil
+}
+
+// TrashChat moves a chat file into the trash directory.
+func (s JSONStore) TrashChat(id string, at time.Time) error {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return err
+	}
+	dir, err := getTrashDir()
+	if err != nil {
+		return err
+	}
+
+	history, err := s.LoadChat(id)
+	if err != nil {
+		return err
+	}
+	history.DeletedAt = at
+
+	return moveChatFile(history, filepath.Join(historyDir, id+".json"), filepath.Join(dir, id+".json"))
+}
+
+// RestoreChat moves a chat file out of the trash directory.
+func (JSONStore) RestoreChat(id string) error {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return err
+	}
+	dir, err := getTrashDir()
+	if err != nil {
+		return err
+	}
+
+	dst := filepath.Join(historyDir, id+".json")
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("a chat named %s already exists", id)
+	}
+
+	src := filepath.Join(dir, id+".json")
+	history, err := readChatFile(src, id)
+	if err != nil {
+		return err
+	}
+	history.DeletedAt = time.Time{}
+
+	return moveChatFile(history, src, dst)
+}
+
+// ListTrash reads the chats in the trash directory.
+func (JSONStore) ListTrash() ([]ChatSummary, error) {
+	dir, err := getTrashDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash directory: %w", err)
+	}
+
+	var summaries []ChatSummary
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		history, err := readChatFile(filepath.Join(dir, file.Name()), ChatIDFromFilename(file.Name()))
+		if err != nil {
+			continue // Skip files we can't read
+		}
+		summaries = append(summaries, summarize(history))
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].DeletedAt.After(summaries[j].DeletedAt)
+	})
+
+	return summaries, nil
+}
+
+// PurgeChat removes a chat file from the trash directory.
+func (JSONStore) PurgeChat(id string) error {
+	dir, err := getTrashDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+		return fmt.Errorf("failed to delete chat file: %w", err)
+	}
+	return nil
+}
+
+// SaveTree writes a conversation tree to <id>.json in the branches directory.
+func (JSONStore) SaveTree(id string, tree *ConversationTree) error {
+	branchDir, err := GetBranchesDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation tree: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(branchDir, id+".json"), data); err != nil {
+		return fmt.Errorf("failed to write tree file: %w", err)
+	}
+	return nil
+}
+
+// LoadTree reads a conversation tree file.
+func (JSONStore) LoadTree(id string) (*ConversationTree, error) {
+	branchDir, err := GetBranchesDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(branchDir, id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree file: %w", err)
+	}
+
+	tree, err := decodeTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tree file: %w", err)
+	}
+
+	return tree, nil
+}
+
+// DeleteTree removes a conversation tree file.
+func (JSONStore) DeleteTree(id string) error {
+	branchDir, err := GetBranchesDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(branchDir, id+".json")); err != nil {
+		return fmt.Errorf("failed to delete tree file: %w", err)
+	}
+	return nil
+}
+
+// ListTrees returns the IDs of the saved conversation trees in name order.
+func (JSONStore) ListTrees() ([]string, error) {
+	branchDir, err := GetBranchesDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(branchDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read branches directory: %w", err)
+	}
+
+	var ids []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") && strings.HasPrefix(file.Name(), "tree_") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+
+	return ids, nil
+}
+
+// RecordUsage appends a usage record to the usage log.
+func (JSONStore) RecordUsage(record UsageRecord) error {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(historyDir, usageLogFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to open usage log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage log: %w", err)
+	}
+	return nil
+}
+
+// ListUsage reads the usage log.
+func (JSONStore) ListUsage(filter UsageFilter) ([]UsageRecord, error) {
+	historyDir, err := GetChatHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(historyDir, usageLogFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open usage log: %w", err)
+	}
+	defer file.Close()
+
+	var records []UsageRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // Skip lines cut short by a crash
+		}
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage log: %w", err)
+	}
+
+	return records, nil
+}
+
+// Update runs fn against the store itself.
+func (s JSONStore) Update(fn func(Store) error) error {
+	return fn(s)
+}
+
+// Close does nothing; files are closed after every operation.
+func (JSONStore) Close() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"strings"
+	"time"
+)
+
+// UpdateChatMeta applies fn to a saved chat and stores the result. UpdatedAt is
+// left alone so organizing chats doesn't reorder them by activity.
+func UpdateChatMeta(idOrFilename string, fn func(history *ChatHistory)) error {
+	id := ChatIDFromFilename(idOrFilename)
+	return DefaultStore().Update(func(tx Store) error {
+		history, err := tx.LoadChat(id)
+		if err != nil {
+			return err
+		}
+		fn(history)
+		return tx.SaveChat(history)
+	})
+}
+
+// RenameChat sets a chat's title.
+func RenameChat(id, title string) error {
+	return UpdateChatMeta(id, func(history *ChatHistory) {
+		history.Title = strings.TrimSpace(title)
+	})
+}
+
+// SetChatTags replaces a chat's tags.
+func SetChatTags(id string, tags []string) error {
+	return UpdateChatMeta(id, func(history *ChatHistory) {
+		history.Tags = tags
+	})
+}
+
+// SetChatPinned pins a chat to the top of the browser or unpins it.
+func SetChatPinned(id string, pinned bool) error {
+	return UpdateChatMeta(id, func(history *ChatHistory) {
+		history.Pinned = pinned
+	})
+}
+
+// SetChatArchived hides a chat from the browser or brings it back.
+func SetChatArchived(id string, archived bool) error {
+	return UpdateChatMeta(id, func(history *ChatHistory) {
+		history.Archived = archived
+	})
+}
+
+// ParseTags splits text such as "#work, go  ideas" into tags, dropping
+// leading #s and duplicates that differ only in case.
+func ParseTags(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	var tags []string
+	for _, field := range fields {
+		tag := strings.TrimLeft(field, "#")
+		if tag == "" || HasTag(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// TrashChat moves a chat to the trash, where it can be restored until purged.
+func TrashChat(idOrFilename string) error {
+	id := ChatIDFromFilename(idOrFilename)
+	if err := DefaultStore().TrashChat(id, time.Now()); err != nil {
+		return err
+	}
+
+	removeFromSearchIndex(id)
+	return nil
+}
+
+// RestoreChat moves a chat out of the trash.
+func RestoreChat(idOrFilename string) error {
+	id := ChatIDFromFilename(idOrFilename)
+	store := DefaultStore()
+	if err := store.RestoreChat(id); err != nil {
+		return err
+	}
+
+	if history, err := store.LoadChat(id); err == nil {
+		updateSearchIndex(history)
+	}
+	return nil
+}
+
+// ListTrash returns the chats in the trash, most recently deleted first.
+func ListTrash() ([]ChatSummary, error) {
+	return DefaultStore().ListTrash()
+}
+
+// PurgeChat permanently deletes a chat from the trash.
+func PurgeChat(idOrFilename string) error {
+	return DefaultStore().PurgeChat(ChatIDFromFilename(idOrFilename))
+}
+
+// EmptyTrash permanently deletes every chat in the trash and returns how many there were.
+func EmptyTrash() (int, error) {
+	trashed, err := ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, summary := range trashed {
+		if err := PurgeChat(summary.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(trashed), nil
+}
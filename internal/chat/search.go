@@ -0,0 +1,200 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Span marks a highlighted byte range in a snippet.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is a message that matched a search, along with where it came from.
+type SearchResult struct {
+	ChatID       string      `json:"chat_id"`
+	MessageIndex int         `json:"message_index"`
+	Message      ChatMessage `json:"message"`
+	Score        float64     `json:"score"`
+	Snippet      string      `json:"snippet"`
+	Highlights   []Span      `json:"highlights,omitempty"`
+}
+
+// MarkSnippet returns the snippet with each highlighted span passed through mark.
+func (r SearchResult) MarkSnippet(mark func(string) string) string {
+	var sb strings.Builder
+	last := 0
+	for _, span := range r.Highlights {
+		if span.Start < last || span.End > len(r.Snippet) {
+			continue
+		}
+		sb.WriteString(r.Snippet[last:span.Start])
+		sb.WriteString(mark(r.Snippet[span.Start:span.End]))
+		last = span.End
+	}
+	sb.WriteString(r.Snippet[last:])
+	return sb.String()
+}
+
+// SearchQuery is a parsed search query.
+type SearchQuery struct {
+	Terms   []string   // Words that must appear; a trailing * matches a prefix
+	Phrases [][]string // Quoted phrases that must appear word for word
+	Role    string     // role:user, role:assistant or role:system
+	Model   string     // model:<prefix>
+	After   time.Time  // after:YYYY-MM-DD (inclusive)
+	Before  time.Time  // before:YYYY-MM-DD (exclusive)
+}
+
+// ParseQuery parses a query such as `"exact phrase" regex* role:user model:gpt-4 after:2025-01-01`.
+func ParseQuery(query string) (SearchQuery, error) {
+	var q SearchQuery
+
+	for _, field := range splitQuery(query) {
+		if strings.HasPrefix(field, `"`) {
+			var phrase []string
+			for _, tok := range tokenize(strings.Trim(field, `"`)) {
+				phrase = append(phrase, tok.Term)
+			}
+			if len(phrase) == 1 {
+				q.Terms = append(q.Terms, phrase[0])
+			} else if len(phrase) > 1 {
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		if name, value, ok := strings.Cut(field, ":"); ok && value != "" {
+			switch strings.ToLower(name) {
+			case "role":
+				q.Role = strings.ToLower(value)
+				continue
+			case "model":
+				q.Model = strings.ToLower(value)
+				continue
+			case "after", "since":
+				date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+				if err != nil {
+					return q, fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+				}
+				q.After = date
+				continue
+			case "before":
+				date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+				if err != nil {
+					return q, fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+				}
+				q.Before = date
+				continue
+			}
+		}
+
+		wildcard := strings.HasSuffix(field, "*")
+		tokens := tokenize(field)
+		for i, tok := range tokens {
+			term := tok.Term
+			if wildcard && i == len(tokens)-1 {
+				term += "*"
+			}
+			q.Terms = append(q.Terms, term)
+		}
+	}
+
+	return q, nil
+}
+
+// splitQuery splits a query on whitespace, keeping quoted phrases together.
+func splitQuery(query string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			current.WriteRune(r)
+			if inQuotes {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+			inQuotes = !inQuotes
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+
+	return fields
+}
+
+// IsEmpty reports whether the query has no terms or filters.
+func (q SearchQuery) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && q.Role == "" && q.Model == "" &&
+		q.After.IsZero() && q.Before.IsZero()
+}
+
+// Search ranks the indexed messages against a query and loads the matching
+// messages with highlighted snippets.
+func (idx *SearchIndex) Search(q SearchQuery) ([]SearchResult, error) {
+	if q.IsEmpty() {
+		return []SearchResult{}, nil
+	}
+
+	chats := map[string]*ChatHistory{}
+	var results []SearchResult
+
+	for _, hit := range idx.rank(q) {
+		history, ok := chats[hit.doc.ChatID]
+		if !ok {
+			loaded, err := LoadChat(hit.doc.ChatID + ".json")
+			if err != nil {
+				continue // Skip files we can't read
+			}
+			history = loaded
+			chats[hit.doc.ChatID] = history
+		}
+		if hit.doc.Index >= len(history.Messages) {
+			continue // The file changed since it was indexed
+		}
+
+		msg := history.Messages[hit.doc.Index]
+		snippet, highlights := makeSnippet(msg.Content, hit.terms)
+		results = append(results, SearchResult{
+			ChatID:       hit.doc.ChatID,
+			MessageIndex: hit.doc.Index,
+			Message:      msg,
+			Score:        hit.score,
+			Snippet:      snippet,
+			Highlights:   highlights,
+		})
+	}
+
+	return results, nil
+}
+
+// SearchChats searches through all saved chats for the given query.
+func SearchChats(query string) ([]SearchResult, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if q.IsEmpty() {
+		return []SearchResult{}, nil
+	}
+
+	index, err := OpenSearchIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return index.Search(q)
+}
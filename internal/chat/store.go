@@ -0,0 +1,292 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists chats, conversation trees and usage records. Chats and trees
+// are addressed by ID, which is their filename without the .json extension.
+type Store interface {
+	SaveChat(history *ChatHistory) error
+	LoadChat(id string) (*ChatHistory, error)
+	DeleteChat(id string) error
+	ListChats(filter ChatFilter) ([]ChatSummary, error)
+	// ChatVersions returns a stamp per chat that changes whenever the chat is
+	// saved, for the given IDs or for every chat when none are given.
+	ChatVersions(ids ...string) (map[string]time.Time, error)
+
+	// TrashChat moves a chat to the trash, stamping it with DeletedAt.
+	TrashChat(id string, at time.Time) error
+	// RestoreChat moves a chat out of the trash.
+	RestoreChat(id string) error
+	// ListTrash returns the chats in the trash, most recently deleted first.
+	ListTrash() ([]ChatSummary, error)
+	// PurgeChat permanently deletes a chat from the trash.
+	PurgeChat(id string) error
+
+	SaveTree(id string, tree *ConversationTree) error
+	LoadTree(id string) (*ConversationTree, error)
+	DeleteTree(id string) error
+	ListTrees() ([]string, error)
+
+	RecordUsage(record UsageRecord) error
+	ListUsage(filter UsageFilter) ([]UsageRecord, error)
+
+	// Update runs fn against a store whose writes are committed together when
+	// fn returns nil and discarded when it returns an error, if the store
+	// supports transactions.
+	Update(fn func(Store) error) error
+	Close() error
+}
+
+// ChatFilter selects chats by model, tag and when they were last updated.
+type ChatFilter struct {
+	Model  string    // Model name prefix, case-insensitive
+	Tag    string    // Exact tag, case-insensitive
+	After  time.Time // Updated at or after (inclusive)
+	Before time.Time // Updated before (exclusive)
+	Limit  int       // Maximum number of chats; 0 means no limit
+}
+
+// matches reports whether a chat summary passes the filter.
+func (f ChatFilter) matches(summary ChatSummary) bool {
+	if f.Model != "" && !strings.HasPrefix(strings.ToLower(summary.Model), strings.ToLower(f.Model)) {
+		return false
+	}
+	if f.Tag != "" && !HasTag(summary.Tags, f.Tag) {
+		return false
+	}
+	if !f.After.IsZero() && summary.UpdatedAt.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !summary.UpdatedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// HasTag reports whether tags contains tag, ignoring case.
+func HasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsageRecord is the token usage and estimated cost of one model response.
+type UsageRecord struct {
+	ChatID           string    `json:"chat_id,omitempty"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// key identifies a usage record for deduplication.
+func (r UsageRecord) key() string {
+	return fmt.Sprintf("%s|%s|%d|%d|%s", r.ChatID, r.Model, r.PromptTokens, r.CompletionTokens,
+		r.Timestamp.UTC().Format(time.RFC3339Nano))
+}
+
+// UsageFilter selects usage records by chat, model and time.
+type UsageFilter struct {
+	ChatID string    // Exact chat ID
+	Model  string    // Model name prefix, case-insensitive
+	After  time.Time // At or after (inclusive)
+	Before time.Time // Before (exclusive)
+}
+
+// matches reports whether a usage record passes the filter.
+func (f UsageFilter) matches(record UsageRecord) bool {
+	if f.ChatID != "" && record.ChatID != f.ChatID {
+		return false
+	}
+	if f.Model != "" && !strings.HasPrefix(strings.ToLower(record.Model), strings.ToLower(f.Model)) {
+		return false
+	}
+	if !f.After.IsZero() && record.Timestamp.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !record.Timestamp.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// summarize builds the summary of a chat.
+func summarize(history *ChatHistory) ChatSummary {
+	title := history.Title
+	if title == "" {
+		title = GenerateTitle(history.Messages)
+	}
+
+	return ChatSummary{
+		ID:           history.ID,
+		Filename:     history.ID + ".json",
+		Title:        title,
+		BuddyName:    history.BuddyName,
+		Model:        history.Model,
+		Tags:         history.Tags,
+		Pinned:       history.Pinned,
+		Archived:     history.Archived,
+		MessageCount: len(history.Messages),
+		Cost:         history.Cost,
+		CreatedAt:    history.CreatedAt,
+		UpdatedAt:    history.UpdatedAt,
+		DeletedAt:    history.DeletedAt,
+		Source:       history.Source,
+		SourceID:     history.SourceID,
+	}
+}
+
+var (
+	storeMu      sync.RWMutex
+	currentStore Store = JSONStore{}
+)
+
+// SetStore makes s the store used by the package-level functions such as
+// SaveChat and LoadTree.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	currentStore = s
+}
+
+// DefaultStore returns the store used by the package-level functions.
+func DefaultStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return currentStore
+}
+
+// OpenStore opens a store by kind: "json" (or empty) for the JSON files in the
+// home directory, "db" for the embedded database.
+func OpenStore(kind string) (Store, error) {
+	switch kind {
+	case "", "json":
+		return JSONStore{}, nil
+	case "db":
+		return OpenBoltStore("")
+	default:
+		return nil, fmt.Errorf("unknown storage %q, want \"json\" or \"db\"", kind)
+	}
+}
+
+// RecordUsage stores the usage of one model response.
+func RecordUsage(record UsageRecord) error {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	return DefaultStore().RecordUsage(record)
+}
+
+// ListUsage returns the usage records matching a filter, oldest first.
+func ListUsage(filter UsageFilter) ([]UsageRecord, error) {
+	return DefaultStore().ListUsage(filter)
+}
+
+// ImportStats counts what an import copied.
+type ImportStats struct {
+	Chats   int `json:"chats"`
+	Trees   int `json:"trees"`
+	Usage   int `json:"usage"`
+	Skipped int `json:"skipped"` // Already present and up to date, or unreadable
+}
+
+// ImportJSON copies the chats, trees and usage records from the JSON files into
+// dst in a single transaction. Chats already in dst are only replaced by newer
+// versions, so the import can be run again safely.
+func ImportJSON(dst Store) (ImportStats, error) {
+	var stats ImportStats
+	src := JSONStore{}
+
+	chats, err := src.ListChats(ChatFilter{})
+	if err != nil {
+		return stats, err
+	}
+	fileTimes, err := src.ChatVersions()
+	if err != nil {
+		return stats, err
+	}
+	trees, err := src.ListTrees()
+	if err != nil {
+		return stats, err
+	}
+	usage, err := src.ListUsage(UsageFilter{})
+	if err != nil {
+		return stats, err
+	}
+
+	err = dst.Update(func(tx Store) error {
+		existing, err := tx.ChatVersions()
+		if err != nil {
+			return err
+		}
+		for _, summary := range chats {
+			if updated, ok := existing[summary.ID]; ok && !summary.UpdatedAt.After(updated) {
+				stats.Skipped++
+				continue
+			}
+			history, err := src.LoadChat(summary.ID)
+			if err != nil {
+				stats.Skipped++
+				continue // Skip files we can't read
+			}
+			// Chats saved before timestamps existed are dated by their file
+			if history.UpdatedAt.IsZero() {
+				history.UpdatedAt = fileTimes[summary.ID]
+			}
+			if history.CreatedAt.IsZero() {
+				history.CreatedAt = history.UpdatedAt
+			}
+			if err := tx.SaveChat(history); err != nil {
+				return fmt.Errorf("failed to import chat %s: %w", summary.ID, err)
+			}
+			stats.Chats++
+		}
+
+		for _, id := range trees {
+			tree, err := src.LoadTree(id)
+			if err != nil {
+				stats.Skipped++
+				continue // Skip files we can't read
+			}
+			if current, err := tx.LoadTree(id); err == nil && !tree.UpdatedAt.After(current.UpdatedAt) {
+				stats.Skipped++
+				continue
+			}
+			if err := tx.SaveTree(id, tree); err != nil {
+				return fmt.Errorf("failed to import tree %s: %w", id, err)
+			}
+			stats.Trees++
+		}
+
+		recorded, err := tx.ListUsage(UsageFilter{})
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, record := range recorded {
+			seen[record.key()] = true
+		}
+		for _, record := range usage {
+			if seen[record.key()] {
+				stats.Skipped++
+				continue
+			}
+			if err := tx.RecordUsage(record); err != nil {
+				return fmt.Errorf("failed to import usage: %w", err)
+			}
+			stats.Usage++
+		}
+		return nil
+	})
+
+	return stats, err
+}
@@ -0,0 +1,549 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lil_guy/internal/chat"
+)
+
+const chatsUsage = `Usage: lil_guy chats <command> [flags]
+
+Commands:
+  list                 List saved chats (--model, --tag, --after, --before)
+  show <id>            Print a saved chat
+  export <id>...       Export a saved chat as markdown or HTML, or chats as
+                       OpenAI or Anthropic JSONL training data
+  rm <id>...           Delete saved chats
+  search <query>       Search messages across saved chats
+                       ("exact phrase", prefix*, role:user, model:gpt-4o,
+                        after:2025-01-01, before:2025-02-01)
+  migrate              Import the JSON chat files into the database
+  import <file>        Import a ChatGPT or Claude.ai data export (the zip,
+                       its folder or its conversations.json), or a .jsonl file
+
+Flags:
+  --json               Print machine-readable JSON
+  -o, --output <file>  Write the export to a file (export only)
+  --format <format>    markdown, html, openai or anthropic (export only; picked
+                       from the output file's .md, .html or .jsonl by default)
+  --dark               Start the HTML export in the dark theme (export only)
+  --toggle             Add a light/dark switch to the HTML export (export only)
+  --no-system          Leave out system prompts (JSONL export)
+  --redact             Mask emails, phone numbers, IPs and API keys (JSONL export)
+  --redact-pattern <re>  Also mask matches of a regular expression (JSONL export)
+  --limit <n>          Maximum number of results (list and search)
+  --model <prefix>     Only chats using a model (list only)
+  --tag <tag>          Only chats with a tag (list and JSONL export)
+  --after <date>       Only chats updated on or after YYYY-MM-DD (list only)
+  --before <date>      Only chats updated before YYYY-MM-DD (list only)
+  --db <file>          Database to import into (migrate only)
+`
+
+// RunChats runs a "chats" subcommand and returns the process exit code.
+func RunChats(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, chatsUsage)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	var err error
+	switch args[0] {
+	case "list", "ls":
+		err = runChatsList(args[1:], stdout)
+	case "show":
+		err = runChatsShow(args[1:], stdout)
+	case "export":
+		err = runChatsExport(args[1:], stdout)
+	case "rm", "delete":
+		err = runChatsRemove(args[1:], stdout)
+	case "search":
+		err = runChatsSearch(args[1:], stdout)
+	case "migrate":
+		err = runChatsMigrate(args[1:], stdout)
+	case "import":
+		err = runChatsImport(args[1:], stdout)
+	default:
+		fmt.Fprintf(stderr, "Unknown chats command: %s\n\n%s", args[0], chatsUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newFlagSet creates a flag set for a chats subcommand with the shared --json flag.
+func newFlagSet(name string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet("chats "+name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "print JSON output")
+	return fs, asJSON
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// writeJSON prints a value as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// loadChatByID resolves and loads a chat by ID or filename.
+func loadChatByID(id string) (string, *chat.ChatHistory, error) {
+	filename, err := chat.ResolveChat(id)
+	if err != nil {
+		return "", nil, err
+	}
+	history, err := chat.LoadChat(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	return filename, history, nil
+}
+
+// parseDate parses a YYYY-MM-DD flag value in local time; empty means no date.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+	}
+	return date, nil
+}
+
+func runChatsList(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("list")
+	var filter chat.ChatFilter
+	var after, before string
+	fs.StringVar(&filter.Model, "model", "", "model name prefix")
+	fs.StringVar(&filter.Tag, "tag", "", "tag")
+	fs.StringVar(&after, "after", "", "updated on or after YYYY-MM-DD")
+	fs.StringVar(&before, "before", "", "updated before YYYY-MM-DD")
+	fs.IntVar(&filter.Limit, "limit", 0, "maximum number of chats")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	var err error
+	if filter.After, err = parseDate(after); err != nil {
+		return err
+	}
+	if filter.Before, err = parseDate(before); err != nil {
+		return err
+	}
+
+	summaries, err := chat.FindChats(filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if summaries == nil {
+			summaries = []chat.ChatSummary{}
+		}
+		return writeJSON(stdout, summaries)
+	}
+
+	if len(summaries) == 0 {
+		fmt.Fprintln(stdout, "No saved chats found.")
+		return nil
+	}
+
+	for _, summary := range summaries {
+		fmt.Fprintf(stdout, "%-32s  %s  %4d msgs  $%.4f  %-28s  %s\n",
+			summary.ID,
+			summary.UpdatedAt.Format("2006-01-02 15:04"),
+			summary.MessageCount,
+			summary.Cost,
+			summary.Model,
+			summary.Title)
+	}
+	return nil
+}
+
+func runChatsShow(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("show")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("show requires exactly one chat ID")
+	}
+
+	_, history, err := loadChatByID(positional[0])
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, history)
+	}
+
+	fmt.Fprintf(stdout, "Chat %s with %s (%s)\n", history.ID, history.BuddyName, history.Model)
+	if history.Title != "" {
+		fmt.Fprintf(stdout, "Title: %s\n", history.Title)
+	}
+	fmt.Fprintln(stdout)
+	for _, msg := range history.Messages {
+		fmt.Fprintf(stdout, "[%s] %s:\n%s\n\n",
+			msg.Timestamp.Format("2006-01-02 15:04"),
+			chat.RoleLabel(msg.Role, history.BuddyName),
+			msg.Content)
+	}
+	return nil
+}
+
+func runChatsExport(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("export")
+	var output string
+	fs.StringVar(&output, "o", "", "output file")
+	fs.StringVar(&output, "output", "", "output file")
+	format := fs.String("format", "", "markdown, html, openai or anthropic")
+	dark := fs.Bool("dark", false, "start the HTML export in the dark theme")
+	toggle := fs.Bool("toggle", false, "add a light/dark switch to the HTML export")
+	var jsonl chat.JSONLOptions
+	tag := fs.String("tag", "", "export every chat with a tag")
+	fs.BoolVar(&jsonl.StripSystem, "no-system", false, "leave out system prompts")
+	fs.BoolVar(&jsonl.Redact, "redact", false, "mask emails, phone numbers, IP addresses and API keys")
+	fs.Var((*stringList)(&jsonl.RedactPatterns), "redact-pattern", "regular expression to mask")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// The output file's extension picks the format unless one is given
+	if *format == "" {
+		*format = "markdown"
+		switch strings.ToLower(filepath.Ext(output)) {
+		case ".html", ".htm":
+			*format = "html"
+		case ".jsonl":
+			*format = chat.FormatOpenAI
+		}
+	}
+	if *format == "md" {
+		*format = "markdown"
+	}
+	if *format == chat.FormatOpenAI || *format == chat.FormatAnthropic {
+		jsonl.Format = *format
+		return runChatsExportJSONL(positional, *tag, output, jsonl, *asJSON, stdout)
+	}
+	if *format != "markdown" && *format != "html" {
+		return fmt.Errorf("unknown export format %q, want markdown, html, openai or anthropic", *format)
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("export requires exactly one chat ID")
+	}
+
+	filename, history, err := loadChatByID(positional[0])
+	if err != nil {
+		return err
+	}
+
+	htmlOptions := chat.HTMLOptions{Dark: *dark, ThemeToggle: *toggle}
+	var rendered string
+	if *format == "html" {
+		rendered, err = chat.RenderHTML(*history, htmlOptions)
+		if err != nil {
+			return err
+		}
+	} else {
+		rendered = chat.RenderMarkdown(*history)
+	}
+
+	// Plain export without a destination goes to stdout so it can be piped
+	if output == "" && !*asJSON {
+		_, err := io.WriteString(stdout, rendered)
+		return err
+	}
+
+	if output == "" {
+		var exported string
+		if *format == "html" {
+			exported, err = chat.ExportToHTML(*history, htmlOptions)
+		} else {
+			exported, err = chat.ExportToMarkdown(*history)
+		}
+		if err != nil {
+			return err
+		}
+		historyDir, err := chat.GetChatHistoryDir()
+		if err != nil {
+			return err
+		}
+		output = filepath.Join(historyDir, exported)
+	} else if err := os.WriteFile(output, []byte(rendered), 0644); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, map[string]string{
+			"id":     chat.ChatIDFromFilename(filename),
+			"format": *format,
+			"output": output,
+		})
+	}
+
+	fmt.Fprintf(stdout, "Exported %s to %s\n", chat.ChatIDFromFilename(filename), output)
+	return nil
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runChatsExportJSONL exports chats chosen by ID or tag as training data.
+func runChatsExportJSONL(ids []string, tag, output string, opts chat.JSONLOptions, asJSON bool, stdout io.Writer) error {
+	if len(ids) == 0 && tag == "" {
+		return fmt.Errorf("export requires chat IDs or --tag")
+	}
+	if asJSON && output == "" {
+		return fmt.Errorf("--json needs -o, since the records go to stdout otherwise")
+	}
+
+	var histories []*chat.ChatHistory
+	for _, id := range ids {
+		_, history, err := loadChatByID(id)
+		if err != nil {
+			return err
+		}
+		histories = append(histories, history)
+	}
+	if tag != "" {
+		summaries, err := chat.FindChats(chat.ChatFilter{Tag: tag})
+		if err != nil {
+			return err
+		}
+		// Oldest first, so a dataset reads in the order it was written
+		for i := len(summaries) - 1; i >= 0; i-- {
+			history, err := chat.LoadChat(summaries[i].ID)
+			if err != nil {
+				return err
+			}
+			histories = append(histories, history)
+		}
+	}
+
+	w := stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	written, err := chat.ExportJSONL(w, histories, opts)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return nil
+	}
+
+	if asJSON {
+		return writeJSON(stdout, map[string]interface{}{
+			"format":  opts.Format,
+			"output":  output,
+			"records": written,
+			"skipped": len(histories) - written,
+		})
+	}
+
+	fmt.Fprintf(stdout, "Exported %d of %d chats to %s\n", written, len(histories), output)
+	return nil
+}
+
+func runChatsRemove(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("rm")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("rm requires at least one chat ID")
+	}
+
+	// Resolve everything first so a typo doesn't leave a half-finished cleanup
+	var filenames []string
+	for _, id := range positional {
+		filename, err := chat.ResolveChat(id)
+		if err != nil {
+			return err
+		}
+		filenames = append(filenames, filename)
+	}
+
+	deleted := []string{}
+	for _, filename := range filenames {
+		if err := chat.DeleteChat(filename); err != nil {
+			return err
+		}
+		deleted = append(deleted, chat.ChatIDFromFilename(filename))
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, map[string][]string{"deleted": deleted})
+	}
+
+	fmt.Fprintf(stdout, "Deleted %s\n", strings.Join(deleted, ", "))
+	return nil
+}
+
+func runChatsSearch(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("search")
+	limit := fs.Int("limit", 0, "maximum number of results")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("search requires a query")
+	}
+
+	results, err := chat.SearchChats(strings.Join(positional, " "))
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(results) > *limit {
+		results = results[:*limit]
+	}
+
+	if *asJSON {
+		if results == nil {
+			results = []chat.SearchResult{}
+		}
+		return writeJSON(stdout, results)
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(stdout, "No results found.")
+		return nil
+	}
+
+	for _, result := range results {
+		snippet := result.MarkSnippet(func(match string) string {
+			return "**" + match + "**"
+		})
+		fmt.Fprintf(stdout, "%s #%d [%s] %s: %s\n",
+			result.ChatID,
+			result.MessageIndex,
+			result.Message.Timestamp.Format("2006-01-02 15:04"),
+			chat.RoleLabel(result.Message.Role, ""),
+			snippet)
+	}
+	return nil
+}
+
+func runChatsMigrate(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("migrate")
+	dbPath := fs.String("db", "", "database file")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	// The database only allows one user, so reuse it if it's already open
+	store, ok := chat.DefaultStore().(*chat.BoltStore)
+	if !ok || (*dbPath != "" && *dbPath != store.Path()) {
+		opened, err := chat.OpenBoltStore(*dbPath)
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+		store = opened
+	}
+
+	stats, err := chat.ImportJSON(store)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, map[string]interface{}{
+			"database": store.Path(),
+			"imported": stats,
+		})
+	}
+
+	fmt.Fprintf(stdout, "Imported %d chats, %d trees and %d usage records into %s (%d skipped)\n",
+		stats.Chats, stats.Trees, stats.Usage, store.Path(), stats.Skipped)
+	if !ok {
+		fmt.Fprintln(stdout, `Set "storage": "db" in ~/.lil_guy_preferences.json to use it.`)
+	}
+	return nil
+}
+
+func runChatsImport(args []string, stdout io.Writer) error {
+	fs, asJSON := newFlagSet("import")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("import requires one export file")
+	}
+
+	var stats chat.ImportStats
+	if strings.EqualFold(filepath.Ext(positional[0]), ".jsonl") {
+		file, err := os.Open(positional[0])
+		if err != nil {
+			return fmt.Errorf("failed to open export: %w", err)
+		}
+		defer file.Close()
+		stats, err = chat.ImportJSONL(file)
+		if err != nil {
+			return err
+		}
+	} else if stats, err = chat.ImportConversations(positional[0]); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, map[string]int{
+			"chats":   stats.Chats,
+			"trees":   stats.Trees,
+			"skipped": stats.Skipped,
+		})
+	}
+
+	fmt.Fprintf(stdout, "Imported %d chats and %d branched conversations as trees (%d skipped)\n",
+		stats.Chats, stats.Trees, stats.Skipped)
+	return nil
+}
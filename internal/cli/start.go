@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"lil_guy/internal/tui"
+)
+
+const startUsage = `Usage: lil_guy [--resume [id]]
+       lil_guy chats <command> [flags]
+
+Flags:
+  -r, --resume [id]  Resume the most recent chat, or a chat, tree or checkpoint by ID
+`
+
+// ErrHelp is returned by ParseStartArgs when usage was requested; its message is the usage text.
+var ErrHelp = errors.New(strings.TrimSpace(startUsage))
+
+// ParseStartArgs parses the flags accepted when launching the TUI.
+func ParseStartArgs(args []string) (tui.Options, error) {
+	var opts tui.Options
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-r" || arg == "--resume":
+			opts.Resume = true
+			// The ID is optional, so only take the next argument if it isn't a flag
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				opts.ResumeID = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(arg, "--resume="):
+			opts.Resume = true
+			opts.ResumeID = strings.TrimPrefix(arg, "--resume=")
+		case arg == "-h" || arg == "--help":
+			return opts, ErrHelp
+		default:
+			return opts, fmt.Errorf("unknown argument: %s\n\n%s", arg, strings.TrimSpace(startUsage))
+		}
+	}
+
+	return opts, nil
+}
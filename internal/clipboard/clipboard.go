@@ -0,0 +1,144 @@
+package clipboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+)
+
+// Method is how text reaches the clipboard.
+type Method string
+
+const (
+	Auto   Method = "auto"   // Native when there's a local display, OSC 52 otherwise
+	OSC52  Method = "osc52"  // Terminal escape sequence, works over SSH
+	Native Method = "native" // pbcopy, wl-copy, xclip, xsel or clip
+)
+
+// Output is where OSC 52 sequences are written.
+var Output io.Writer = os.Stdout
+
+// ParseMethod reads a method from the preferences. Anything unknown is Auto.
+func ParseMethod(s string) Method {
+	switch method := Method(strings.ToLower(strings.TrimSpace(s))); method {
+	case OSC52, Native:
+		return method
+	}
+	return Auto
+}
+
+// Detect picks the method Auto uses: native tools on a local desktop, and
+// OSC 52 over SSH or where there's no clipboard tool or display.
+func Detect() Method {
+	if os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != "" {
+		return OSC52
+	}
+	if _, err := copyCommand(); err != nil {
+		return OSC52
+	}
+	return Native
+}
+
+// Copy puts text on the clipboard and returns the method used.
+func Copy(text string, method Method) (Method, error) {
+	if method == Auto {
+		method = Detect()
+	}
+	if method == OSC52 {
+		if _, err := io.WriteString(Output, Sequence(text)); err != nil {
+			return method, fmt.Errorf("failed to write OSC 52 sequence: %w", err)
+		}
+		return method, nil
+	}
+
+	args, err := copyCommand()
+	if err != nil {
+		return method, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return method, fmt.Errorf("%s failed: %w %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return method, nil
+}
+
+// Paste returns the text on the clipboard. Terminals rarely let programs
+// read the clipboard over OSC 52, so pasting always uses a native tool.
+func Paste() (string, error) {
+	args, err := pasteCommand()
+	if err != nil {
+		return "", err
+	}
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", args[0], err)
+	}
+	return string(output), nil
+}
+
+// Sequence returns the OSC 52 sequence that copies text, wrapped so tmux or
+// screen pass it through to the outer terminal.
+func Sequence(text string) string {
+	seq := osc52.New(text)
+	switch {
+	case os.Getenv("TMUX") != "":
+		seq = seq.Tmux() // Needs "set -g allow-passthrough on"
+	case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		seq = seq.Screen()
+	}
+	return seq.String()
+}
+
+// copyCommand returns the native command that reads text to copy from stdin.
+func copyCommand() ([]string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"pbcopy"}, nil
+	case "windows":
+		return []string{"clip"}, nil
+	}
+	return findTool(
+		[]string{"wl-copy"},
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xsel", "--clipboard", "--input"},
+	)
+}
+
+// pasteCommand returns the native command that writes the clipboard to stdout.
+func pasteCommand() ([]string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"pbpaste"}, nil
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"}, nil
+	}
+	return findTool(
+		[]string{"wl-paste", "--no-newline"},
+		[]string{"xclip", "-selection", "clipboard", "-out"},
+		[]string{"xsel", "--clipboard", "--output"},
+	)
+}
+
+// findTool returns the first installed tool that can reach a display: Wayland
+// tools need WAYLAND_DISPLAY and X11 tools need DISPLAY.
+func findTool(wayland, xclip, xsel []string) ([]string, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath(wayland[0]); err == nil {
+			return wayland, nil
+		}
+	}
+	if os.Getenv("DISPLAY") != "" {
+		for _, args := range [][]string{xclip, xsel} {
+			if _, err := exec.LookPath(args[0]); err == nil {
+				return args, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
@@ -14,13 +14,21 @@ const (
 
 // Preferences struct to store user preferences.
 type Preferences struct {
-	BuddyName     string `json:"buddy_name"`
-	SystemMessage string `json:"system_message"`
-	Model         string `json:"model"`
-	Theme         string `json:"theme"`
-	AutoSave      bool   `json:"auto_save"`
-	Personality   string `json:"personality"`
-	RetroTheme    string `json:"retro_theme"`
+	BuddyName     string   `json:"buddy_name"`
+	SystemMessage string   `json:"system_message"`
+	Model         string   `json:"model"`
+	Theme         string   `json:"theme"`
+	AutoSave      bool     `json:"auto_save"`
+	Personality   string   `json:"personality"`
+	RetroTheme    string   `json:"retro_theme"`
+	Temperature   *float64 `json:"temperature,omitempty"` // Nil uses the provider default
+	MaxTokens     int      `json:"max_tokens,omitempty"`
+	Storage       string   `json:"storage,omitempty"`   // "json" (default) or "db"
+	Clipboard     string   `json:"clipboard,omitempty"` // "auto" (default), "osc52" or "native"
+
+	// AutoCheckpointTurns is how many replies pass between automatic
+	// checkpoints: 0 uses the default of 10, and a negative number turns them off.
+	AutoCheckpointTurns int `json:"auto_checkpoint_turns,omitempty"`
 }
 
 // GetPreferencesFilePath returns the absolute path to the preferences file.
@@ -0,0 +1,314 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/config"
+)
+
+// action is something the chat can do, run by its key, its slash command or
+// from the command palette.
+type action struct {
+	Name    string
+	Keys    []string // Key strings that run it in the chat, as tea.KeyMsg reports them
+	Command string   // Slash command that runs it, if any
+	Run     func(m *model) tea.Cmd
+}
+
+// chatActions is the registry of chat actions. The chat dispatches keys and
+// slash commands from it, and the command palette and /help list it.
+func chatActions() []action {
+	return []action{
+		{Name: "Regenerate last response", Keys: []string{"ctrl+r"}, Run: (*model).regenerateLast},
+		{Name: "Select a message", Keys: []string{"alt+e"}, Run: (*model).openSelectMessage},
+		{Name: "Code blocks: copy, save or run", Keys: []string{"alt+c"}, Command: "/code", Run: (*model).openCodeBlocks},
+		{Name: "Previous alternative", Keys: []string{"alt+,"}, Run: func(m *model) tea.Cmd { return m.flipAlternative(-1) }},
+		{Name: "Next alternative", Keys: []string{"alt+."}, Run: func(m *model) tea.Cmd { return m.flipAlternative(1) }},
+		{Name: "Write the message in $EDITOR", Keys: []string{"ctrl+x ctrl+e"}, Run: (*model).openEditor},
+		{Name: "Paste from the clipboard", Keys: []string{"ctrl+v"}, Run: func(m *model) tea.Cmd { return pasteFromClipboard }},
+		{Name: "Copy last response", Keys: []string{"ctrl+y"}, Run: (*model).copyLastResponse},
+		{Name: "Clear conversation", Keys: []string{"ctrl+l"}, Command: "/clear", Run: func(m *model) tea.Cmd {
+			m.clearConversation()
+			return clearStatusAfterDelay()
+		}},
+		{Name: "Switch to the next model", Keys: []string{"alt+m"}, Run: (*model).nextModel},
+		{Name: "Save chat", Keys: []string{"ctrl+s"}, Run: (*model).saveChat},
+		{Name: "Export to markdown", Keys: []string{"ctrl+e"}, Run: (*model).exportMarkdown},
+		{Name: "Export as an HTML page", Command: "/export html", Run: (*model).exportHTML},
+		{Name: "Show token usage", Keys: []string{"ctrl+t"}, Run: func(m *model) tea.Cmd {
+			m.statusMessage = fmt.Sprintf("Tokens: %d | Requests: %d | Cost: $%.4f",
+				m.tokenUsage.TotalTokens, m.tokenUsage.RequestCount, m.tokenUsage.EstimatedCost)
+			return clearStatusAfterDelay()
+		}},
+		{Name: "Generate a new title", Command: "/title", Run: func(m *model) tea.Cmd { return m.setTitle("") }},
+		{Name: "Browse saved chats", Keys: []string{"ctrl+b"}, Run: (*model).openChatBrowser},
+		{Name: "Search chat history", Keys: []string{"ctrl+f"}, Run: (*model).openSearch},
+		{Name: "Create checkpoint", Keys: []string{"ctrl+k"}, Run: (*model).openCreateCheckpoint},
+		{Name: "Branch manager", Keys: []string{"alt+h"}, Run: (*model).openBranchManager},
+		{Name: "Open a saved tree", Command: "/trees", Run: (*model).openTreeBrowser},
+		{Name: "Templates", Keys: []string{"ctrl+p"}, Run: func(m *model) tea.Cmd {
+			m.appState = stateTemplateSelector
+			m.selectedTemplate = 0
+			m.statusMessage = "Template selector - Use arrows to navigate, Enter to apply, Esc to return"
+			return clearStatusAfterDelay()
+		}},
+		{Name: "Personality", Keys: []string{"ctrl+o"}, Run: func(m *model) tea.Cmd {
+			m.appState = statePersonalitySelector
+			m.selectedPersonality = 0
+			m.statusMessage = "Personality selector - Use arrows to navigate, Enter to apply, Esc to return"
+			return clearStatusAfterDelay()
+		}},
+		{Name: "Next theme", Keys: []string{"ctrl+d"}, Run: func(m *model) tea.Cmd {
+			m.cycleTheme()
+			return clearStatusAfterDelay()
+		}},
+		{Name: "Retro themes", Keys: []string{"ctrl+g"}, Run: func(m *model) tea.Cmd {
+			m.appState = stateRetroThemeSelector
+			m.selectedRetroTheme = 0
+			m.statusMessage = "Retro theme selector - Use arrows to navigate, Enter to apply, Esc to return"
+			return clearStatusAfterDelay()
+		}},
+		{Name: "Toggle auto-save", Keys: []string{"ctrl+a"}, Run: (*model).toggleAutoSave},
+		{Name: "Help", Command: "/help", Run: (*model).showHelp},
+		{Name: "Quit", Keys: []string{"ctrl+c"}, Run: func(m *model) tea.Cmd { return tea.Quit }},
+	}
+}
+
+// findAction returns the chat action bound to a key, or nil.
+func findAction(key string) *action {
+	actions := chatActions()
+	for i := range actions {
+		if slices.Contains(actions[i].Keys, key) {
+			return &actions[i]
+		}
+	}
+	return nil
+}
+
+// findCommand returns the chat action a slash command runs, or nil.
+func findCommand(command string) *action {
+	command = strings.ToLower(strings.TrimSpace(command))
+	actions := chatActions()
+	for i := range actions {
+		if actions[i].Command != "" && actions[i].Command == command {
+			return &actions[i]
+		}
+	}
+	return nil
+}
+
+// keyLabel formats a key string for display, e.g. "ctrl+x ctrl+e" as
+// "Ctrl+X Ctrl+E".
+func keyLabel(key string) string {
+	chords := strings.Fields(key)
+	for i, chord := range chords {
+		parts := strings.Split(chord, "+")
+		for j, part := range parts {
+			if part != "" {
+				parts[j] = strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		chords[i] = strings.Join(parts, "+")
+	}
+	return strings.Join(chords, " ")
+}
+
+// binding returns how to run an action: its key, or its slash command.
+func (a action) binding() string {
+	if len(a.Keys) > 0 {
+		return keyLabel(a.Keys[0])
+	}
+	return a.Command
+}
+
+// regenerateLast asks for a new reply to the last message. The old reply
+// stays in the conversation tree as an alternative.
+func (m *model) regenerateLast() tea.Cmd {
+	if m.lastUserMessage == "" || m.isThinking {
+		return nil
+	}
+
+	// Keep the reply being replaced in the conversation tree
+	m.recordConversation()
+
+	// Remove the last assistant message if there is one
+	if len(m.messages) > 0 && m.messages[len(m.messages)-1].Role == "assistant" {
+		m.messages = m.messages[:len(m.messages)-1]
+		if len(m.chatMessages) > 0 && m.chatMessages[len(m.chatMessages)-1].Role == "assistant" {
+			m.chatMessages = m.chatMessages[:len(m.chatMessages)-1]
+		}
+	}
+	m.updateViewportContent()
+
+	// Resend the last user message
+	m.isThinking = true
+	if m.currentPersonality != nil {
+		m.loadingMessage = GetPersonalityThinkingMessage(m.currentPersonality)
+	} else {
+		m.loadingMessage = loadingMessages[time.Now().UnixNano()%int64(len(loadingMessages))]
+	}
+	m.statusMessage = "Regenerating response..."
+	return tea.Batch(sendToAI(*m, m.lastUserMessage), m.spinner.Tick, clearStatusAfterDelay())
+}
+
+// flipAlternative flips between alternatives of the latest message that has them.
+func (m *model) flipAlternative(delta int) tea.Cmd {
+	if _, err := m.switchAlternative(m.lastAlternative(), delta); err != nil {
+		m.statusMessage = err.Error()
+	}
+	return clearStatusAfterDelay()
+}
+
+// copyLastResponse copies the last reply to the clipboard.
+func (m *model) copyLastResponse() tea.Cmd {
+	lastMsg := m.getLastAssistantMessage()
+	if lastMsg == "" {
+		m.statusMessage = "No assistant message to copy"
+	} else if err := m.copyToClipboard(lastMsg); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to copy: %v", err)
+	} else {
+		m.statusMessage = "Last response copied to clipboard"
+	}
+	return clearStatusAfterDelay()
+}
+
+// nextModel cycles through the available models.
+func (m *model) nextModel() tea.Cmd {
+	availableModels := getAvailableModels(m.client)
+	currentIndex := slices.Index(availableModels, m.currentModel)
+	m.setModel(availableModels[(currentIndex+1)%len(availableModels)])
+	return clearStatusAfterDelay()
+}
+
+// setModel switches to a model and remembers it in the preferences.
+func (m *model) setModel(name string) {
+	m.currentModel = name
+	if m.preferences != nil {
+		m.preferences.Model = name
+		config.SavePreferences(m.preferences)
+	}
+	m.statusMessage = fmt.Sprintf("Switched to %s", name)
+}
+
+// saveChat saves the conversation to chat history.
+func (m *model) saveChat() tea.Cmd {
+	if err := m.saveCurrentChat(); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save: %v", err)
+	} else {
+		m.statusMessage = "Chat history saved"
+		m.messagesSinceLastSave = 0 // Reset auto-save counter
+	}
+	return clearStatusAfterDelay()
+}
+
+// exportMarkdown exports the conversation to a markdown file.
+func (m *model) exportMarkdown() tea.Cmd {
+	if err := m.exportToMarkdown(); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to export: %v", err)
+	} else {
+		m.statusMessage = "Chat exported to markdown"
+	}
+	return clearStatusAfterDelay()
+}
+
+// exportHTML exports the conversation as a standalone HTML page.
+func (m *model) exportHTML() tea.Cmd {
+	if filename, err := m.exportToHTML(); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to export: %v", err)
+	} else {
+		m.statusMessage = fmt.Sprintf("Chat exported to %s", filename)
+	}
+	return clearStatusAfterDelay()
+}
+
+// openChatBrowser lists the saved chats.
+func (m *model) openChatBrowser() tea.Cmd {
+	m.appState = stateChatBrowser
+	m.showTrash = false
+	m.refreshChatList()
+	m.statusMessage = "Chat browser - Use arrows to navigate, Enter to load, Esc to return"
+	return clearStatusAfterDelay()
+}
+
+// openSearch starts a search of the chat history.
+func (m *model) openSearch() tea.Cmd {
+	m.appState = stateSearch
+	m.searchQuery = ""
+	m.lastSearchQuery = ""
+	m.searchResults = []chat.SearchResult{}
+	m.selectedResult = 0
+	m.statusMessage = "Search chat history - Type to search, Enter to perform search, Esc to return"
+	return clearStatusAfterDelay()
+}
+
+// openCreateCheckpoint asks for the name of a new checkpoint.
+func (m *model) openCreateCheckpoint() tea.Cmd {
+	if len(m.chatMessages) == 0 {
+		return nil
+	}
+	m.appState = stateCreateCheckpoint
+	m.checkpointName = chat.GenerateCheckpointName(m.chatMessages)
+	m.textInput.SetValue(m.checkpointName)
+	m.textInput.Focus()
+	m.statusMessage = "Create checkpoint - Enter name and press Enter"
+	return nil
+}
+
+// openBranchManager shows the branch graph.
+func (m *model) openBranchManager() tea.Cmd {
+	m.recordConversation()
+	m.appState = stateBranchManager
+	m.refreshBranchList()
+	m.statusMessage = "Branch manager - Use arrows to navigate, Enter to switch, B to branch, Esc to return"
+	return clearStatusAfterDelay()
+}
+
+// toggleAutoSave turns saving after every reply on or off.
+func (m *model) toggleAutoSave() tea.Cmd {
+	if m.preferences == nil {
+		return nil
+	}
+	m.preferences.AutoSave = !m.preferences.AutoSave
+	config.SavePreferences(m.preferences)
+	status := "disabled"
+	if m.preferences.AutoSave {
+		status = "enabled"
+	}
+	m.statusMessage = fmt.Sprintf("Auto-save %s", status)
+	return clearStatusAfterDelay()
+}
+
+// showHelp adds the commands and keys to the chat as a notice.
+func (m *model) showHelp() tea.Cmd {
+	var commands, keys strings.Builder
+	for _, a := range chatActions() {
+		if a.Command != "" {
+			fmt.Fprintf(&commands, "%s - %s\n", a.Command, a.Name)
+		}
+		if len(a.Keys) > 0 {
+			fmt.Fprintf(&keys, "%s - %s\n", keyLabel(a.Keys[0]), a.Name)
+		}
+	}
+
+	helpText := "Available commands:\n" + commands.String() +
+		`/title <name> - Rename the conversation
+/import <file> - Import a ChatGPT or Claude.ai data export
+
+Keyboard shortcuts:
+Enter - Send message
+Alt+Enter / Ctrl+J - New line
+↑/↓ - Scroll chat or navigate message history
+PgUp/PgDn - Scroll by page
+Home/End - Jump to top/bottom
+: - Command palette (with an empty message)
+` + strings.TrimRight(keys.String(), "\n")
+	m.addChatMessage("system", helpText)
+	m.updateViewportContent()
+	return nil
+}
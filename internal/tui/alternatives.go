@@ -0,0 +1,152 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"lil_guy/internal/ai"
+	"lil_guy/internal/chat"
+)
+
+// messageNodes returns the conversation tree node of each entry of
+// chatMessages, or "" for messages the tree doesn't have yet, such as
+// notices or a reply that is still being typed.
+func (m model) messageNodes() []string {
+	nodes := make([]string, len(m.chatMessages))
+	if m.conversationTree == nil {
+		return nodes
+	}
+
+	path := m.conversationTree.Path(m.conversationTree.Head())
+	step := 0
+	for i, msg := range m.chatMessages {
+		if msg.Role == "system" {
+			continue
+		}
+		if step >= len(path) {
+			break
+		}
+
+		// The head can lag behind an edit that hasn't been answered yet
+		node := m.conversationTree.Nodes[path[step]].Message
+		if node.Role != msg.Role || node.Content != msg.Content || !node.Timestamp.Equal(msg.Timestamp) {
+			break
+		}
+		nodes[i] = path[step]
+		step++
+	}
+	return nodes
+}
+
+// alternativeIndicator returns "< 2/3 >" for a message that has alternatives,
+// or "" if it has none.
+func (m model) alternativeIndicator(nodeID string) string {
+	if nodeID == "" {
+		return ""
+	}
+
+	siblings := m.conversationTree.Siblings(nodeID)
+	if len(siblings) < 2 {
+		return ""
+	}
+	for i, sibling := range siblings {
+		if sibling.ID == nodeID {
+			return fmt.Sprintf("< %d/%d >", i+1, len(siblings))
+		}
+	}
+	return ""
+}
+
+// messageBadges returns the alternatives indicator and provenance shown next
+// to a message.
+func (m model) messageBadges(nodeID string) string {
+	badges := m.alternativeIndicator(nodeID)
+	if nodeID == "" {
+		return badges
+	}
+	if node, ok := m.conversationTree.Nodes[nodeID]; ok && node.PickedFrom != "" {
+		badges = strings.TrimSpace(badges + " (cherry-picked)")
+	} else if ok && len(node.MergedFrom) > 0 {
+		badges = strings.TrimSpace(badges + " (merged)")
+	}
+	return badges
+}
+
+// lastAlternative returns the index in chatMessages of the latest message
+// that has alternatives, or -1 if none do.
+func (m model) lastAlternative() int {
+	nodes := m.messageNodes()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if m.alternativeIndicator(nodes[i]) != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// switchAlternative replaces the message at index with its previous (delta
+// -1) or next (delta 1) alternative, followed by the newest conversation
+// below it. It returns the message's index in the new conversation.
+func (m *model) switchAlternative(index, delta int) (int, error) {
+	if m.isThinking || m.isTyping {
+		return index, fmt.Errorf("wait for the reply to finish")
+	}
+	m.recordConversation()
+
+	nodes := m.messageNodes()
+	if index < 0 || index >= len(nodes) || m.alternativeIndicator(nodes[index]) == "" {
+		return index, fmt.Errorf("this message has no alternatives")
+	}
+
+	tree := m.conversationTree
+	siblings := tree.Siblings(nodes[index])
+	next := -1
+	for i, sibling := range siblings {
+		if sibling.ID == nodes[index] {
+			next = i + delta
+		}
+	}
+	if next < 0 || next >= len(siblings) {
+		return index, fmt.Errorf("no more alternatives")
+	}
+
+	// Follow the most recent reply at each step below the alternative
+	leaf := siblings[next].ID
+	for children := tree.Children(leaf); len(children) > 0; children = tree.Children(leaf) {
+		leaf = children[len(children)-1].ID
+	}
+	if err := tree.SetHead(leaf); err != nil {
+		return index, err
+	}
+
+	m.showConversation(tree.Messages(leaf))
+	m.statusMessage = fmt.Sprintf("Showing alternative %d of %d", next+1, len(siblings))
+
+	// Notices above the message are gone, so its index may have moved
+	return len(tree.Path(siblings[next].ID)) - 1, nil
+}
+
+// showConversation replaces the messages on screen, keeping the system prompt.
+func (m *model) showConversation(messages []chat.ChatMessage) {
+	m.messages = []ai.UnifiedMessage{
+		{Role: "system", Content: m.messages[0].Content}, // Keep system message
+	}
+	m.chatMessages = []chat.ChatMessage{}
+	m.lastUserMessage = ""
+
+	for _, msg := range messages {
+		if msg.Role != "system" {
+			m.messages = append(m.messages, ai.UnifiedMessage{
+				Role:    msg.Role,
+				Content: msg.Content,
+			})
+			m.chatMessages = append(m.chatMessages, msg)
+			if msg.Role == "user" {
+				m.lastUserMessage = msg.Content
+			}
+		}
+	}
+
+	m.clearFocus()
+	m.updateViewportContent()
+}
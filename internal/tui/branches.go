@@ -0,0 +1,368 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+// graphRow is a line of the branch graph: a message where the conversation
+// forks or ends, or that has a branch head or checkpoint on it.
+type graphRow struct {
+	graph       string // Lines and marker drawn before the labels
+	nodeID      string
+	messages    int // Messages from the start of the conversation
+	branches    []*chat.Branch
+	checkpoints []chat.Checkpoint
+}
+
+// buildBranchGraph lays out a conversation tree like git log --graph. Only
+// messages where something happens get a row; the ones in between are
+// counted.
+func buildBranchGraph(tree *chat.ConversationTree) []graphRow {
+	heads := map[string][]*chat.Branch{}
+	checkpoints := map[string][]chat.Checkpoint{}
+	for _, branch := range tree.Branches {
+		if branch.Head != "" {
+			heads[branch.Head] = append(heads[branch.Head], branch)
+		}
+		for _, checkpoint := range branch.Checkpoints {
+			checkpoints[checkpoint.NodeID] = append(checkpoints[checkpoint.NodeID], checkpoint)
+		}
+	}
+	for _, branches := range heads {
+		sort.Slice(branches, func(i, j int) bool {
+			return branches[i].CreatedAt.Before(branches[j].CreatedAt)
+		})
+	}
+	for _, list := range checkpoints {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		})
+	}
+
+	var rows []graphRow
+	var walk func(node *chat.MessageNode, lead, indent string)
+	walkChildren := func(children []*chat.MessageNode, indent string) {
+		if len(children) == 1 {
+			walk(children[0], indent, indent)
+			return
+		}
+		for i, child := range children {
+			if i < len(children)-1 {
+				walk(child, indent+"├─", indent+"│ ")
+			} else {
+				walk(child, indent+"└─", indent+"  ")
+			}
+		}
+	}
+	walk = func(node *chat.MessageNode, lead, indent string) {
+		// Skip ahead to the next message worth a row
+		children := tree.Children(node.ID)
+		for len(children) == 1 && len(heads[node.ID]) == 0 && len(checkpoints[node.ID]) == 0 {
+			node = children[0]
+			children = tree.Children(node.ID)
+		}
+
+		marker := "●"
+		if node.ID == tree.Head() {
+			marker = "◉"
+		}
+		rows = append(rows, graphRow{
+			graph:       lead + marker,
+			nodeID:      node.ID,
+			messages:    len(tree.Path(node.ID)),
+			branches:    heads[node.ID],
+			checkpoints: checkpoints[node.ID],
+		})
+		walkChildren(children, indent)
+	}
+
+	walkChildren(tree.Children(""), "")
+	return rows
+}
+
+// refreshBranchList rebuilds the branch graph and selects the current message.
+func (m *model) refreshBranchList() {
+	m.graphRows = nil
+	m.selectedGraphRow = 0
+	if m.conversationTree == nil {
+		return
+	}
+
+	m.graphRows = buildBranchGraph(m.conversationTree)
+	for i, row := range m.graphRows {
+		if row.nodeID == m.conversationTree.Head() {
+			m.selectedGraphRow = i
+		}
+	}
+}
+
+// selectedGraphBranch returns the branch the selected row is the head of,
+// or nil if none is.
+func (m model) selectedGraphBranch() *chat.Branch {
+	if m.selectedGraphRow >= len(m.graphRows) {
+		return nil
+	}
+	return m.graphRowBranch(m.graphRows[m.selectedGraphRow])
+}
+
+// graphRowBranch returns the branch a row is the head of, preferring the
+// current branch, or nil if none is.
+func (m model) graphRowBranch(row graphRow) *chat.Branch {
+	branches := row.branches
+	for _, branch := range branches {
+		if branch.ID == m.conversationTree.CurrentBranch {
+			return branch
+		}
+	}
+	if len(branches) > 0 {
+		return branches[0]
+	}
+	return nil
+}
+
+// saveBranchTree saves the conversation tree after a change in the branch
+// manager and reports the outcome.
+func (m *model) saveBranchTree(status string) tea.Cmd {
+	m.initializeConversationTree()
+	if err := chat.SaveTree(m.conversationTree, m.treeFilename); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save tree: %v", err)
+	} else {
+		m.statusMessage = status
+	}
+	m.refreshBranchList()
+	return clearStatusAfterDelay()
+}
+
+// updateBranchManager handles keys in the branch manager.
+func (m *model) updateBranchManager(msg tea.KeyMsg) tea.Cmd {
+	if m.confirmDeleteBranch {
+		m.confirmDeleteBranch = false
+		branch := m.selectedGraphBranch()
+		if branch == nil || (msg.String() != "y" && msg.String() != "Y") {
+			m.statusMessage = "Cancelled"
+			return clearStatusAfterDelay()
+		}
+		if err := m.conversationTree.DeleteBranch(branch.ID); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to delete branch: %v", err)
+			return clearStatusAfterDelay()
+		}
+		return m.saveBranchTree("Deleted branch " + branch.Name)
+	}
+	if m.browserPrompt == promptRenameBranch {
+		return m.updateBranchPrompt(msg)
+	}
+
+	switch msg.String() {
+	case "esc":
+		if m.diffFrom != "" {
+			m.diffFrom, m.diffFromLabel = "", ""
+			m.statusMessage = "Comparison cancelled"
+			return clearStatusAfterDelay()
+		}
+		m.appState = stateChatting
+		m.statusMessage = "Back to chat"
+		return clearStatusAfterDelay()
+	case "ctrl+c", "q":
+		m.appState = stateChatting
+		m.statusMessage = "Back to chat"
+		return clearStatusAfterDelay()
+	case "up", "k":
+		if m.selectedGraphRow > 0 {
+			m.selectedGraphRow--
+		}
+	case "down", "j":
+		if m.selectedGraphRow < len(m.graphRows)-1 {
+			m.selectedGraphRow++
+		}
+	case "enter":
+		// Continue the conversation from the selected message
+		if m.selectedGraphRow >= len(m.graphRows) {
+			return nil
+		}
+		if m.isThinking || m.isTyping {
+			m.statusMessage = "Wait for the reply to finish"
+			return clearStatusAfterDelay()
+		}
+		row := m.graphRows[m.selectedGraphRow]
+		tree := m.conversationTree
+		if branch := m.selectedGraphBranch(); branch != nil {
+			tree.SwitchBranch(branch.ID)
+			m.statusMessage = "Switched to branch " + branch.Name
+		} else {
+			m.statusMessage = fmt.Sprintf("Branch %s now continues from here", tree.GetCurrentBranch().Name)
+		}
+		if err := tree.SetHead(row.nodeID); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to switch: %v", err)
+			return clearStatusAfterDelay()
+		}
+		m.showConversation(tree.Messages(row.nodeID))
+		m.appState = stateChatting
+		return clearStatusAfterDelay()
+	case "b":
+		// Create branch from the selected checkpoint
+		if m.selectedGraphRow >= len(m.graphRows) {
+			return nil
+		}
+		row := m.graphRows[m.selectedGraphRow]
+		if m.isThinking || m.isTyping {
+			m.statusMessage = "Wait for the reply to finish"
+			return clearStatusAfterDelay()
+		}
+		if len(row.checkpoints) == 0 {
+			m.statusMessage = "Branches start at a checkpoint; select a row with one"
+			return clearStatusAfterDelay()
+		}
+		checkpoint := row.checkpoints[len(row.checkpoints)-1]
+		newBranch, err := m.conversationTree.CreateBranch(
+			checkpoint.ID,
+			fmt.Sprintf("branch_%d", time.Now().Unix()),
+			fmt.Sprintf("Branched from: %s", checkpoint.Name),
+		)
+		if err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to create branch: %v", err)
+			return clearStatusAfterDelay()
+		}
+		m.conversationTree.SwitchBranch(newBranch.ID)
+		m.loadCheckpoint(checkpoint.ID)
+		m.appState = stateChatting
+		return m.saveBranchTree(fmt.Sprintf("Created new branch from checkpoint: %s", checkpoint.Name))
+	case "r":
+		if branch := m.selectedGraphBranch(); branch != nil {
+			m.openBrowserPrompt(promptRenameBranch, branch.Name, "Branch name")
+		} else {
+			m.statusMessage = "No branch ends here"
+			return clearStatusAfterDelay()
+		}
+	case "d":
+		branch := m.selectedGraphBranch()
+		switch {
+		case branch == nil:
+			m.statusMessage = "No branch ends here"
+			return clearStatusAfterDelay()
+		case branch.ID == m.conversationTree.RootBranchID:
+			m.statusMessage = "The main branch can't be deleted"
+			return clearStatusAfterDelay()
+		}
+		m.confirmDeleteBranch = true
+	case "s":
+		if m.conversationTree != nil {
+			return m.saveBranchTree("Conversation tree saved")
+		}
+	case "v":
+		return m.markForDiff()
+	case "o":
+		return m.openTreeBrowser()
+	case "c":
+		return m.openCherryPick()
+	case "m":
+		return m.requestMerge()
+	}
+	return nil
+}
+
+// updateBranchPrompt handles typing a new branch name.
+func (m *model) updateBranchPrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.closeBrowserPrompt()
+		return nil
+	case "enter":
+		name := m.textInput.Value()
+		m.closeBrowserPrompt()
+		branch := m.selectedGraphBranch()
+		if branch == nil {
+			return nil
+		}
+		if err := m.conversationTree.RenameBranch(branch.ID, name); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to rename branch: %v", err)
+			return clearStatusAfterDelay()
+		}
+		return m.saveBranchTree("Renamed branch to " + branch.Name)
+	}
+
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	return cmd
+}
+
+// renderBranchManager draws the branch graph with its prompts and help.
+func (m model) renderBranchManager() string {
+	s := lipgloss.NewStyle().Bold(true).Render("🌿 Branch Manager") + "\n\n"
+
+	switch {
+	case m.conversationTree == nil:
+		s += "No conversation tree loaded. Create a checkpoint first (Ctrl+K) or open a saved tree (O)\n"
+	case len(m.graphRows) == 0:
+		s += fmt.Sprintf("Current branch: %s\n\nNo messages yet\n", m.conversationTree.GetCurrentBranch().Name)
+	default:
+		s += fmt.Sprintf("Current branch: %s\n\n", m.conversationTree.GetCurrentBranch().Name)
+
+		branchStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.AssistantMessage)).Bold(true)
+		checkpointStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.UserMessage))
+		faint := lipgloss.NewStyle().Faint(true)
+		selectedStyle := lipgloss.NewStyle().Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
+		for i, row := range m.graphRows {
+			// The selected row is drawn in one colour
+			branchStyle, checkpointStyle, faint := branchStyle, checkpointStyle, faint
+			if i == m.selectedGraphRow {
+				branchStyle, checkpointStyle, faint = lipgloss.NewStyle(), lipgloss.NewStyle(), lipgloss.NewStyle()
+			}
+
+			var labels []string
+			for _, branch := range row.branches {
+				name := branch.Name
+				if branch.ID == m.conversationTree.CurrentBranch {
+					name += "*"
+				}
+				labels = append(labels, branchStyle.Render(name))
+			}
+			labels = append(labels, fmt.Sprintf("%d messages", row.messages))
+			for _, checkpoint := range row.checkpoints {
+				label := "✓ " + checkpoint.Name
+				if checkpoint.Auto {
+					label += " (auto)"
+				}
+				labels = append(labels, checkpointStyle.Render(label))
+			}
+
+			message := m.conversationTree.Nodes[row.nodeID].Message
+			preview := strings.Join(strings.Fields(message.Content), " ")
+			preview = faint.Render(fmt.Sprintf("%s: %s", chat.RoleLabel(message.Role, m.buddyName), wrap.Truncate(preview, 40, "…")))
+
+			line := fmt.Sprintf("  %s %s  %s", row.graph, strings.Join(labels, " · "), preview)
+			if i == m.selectedGraphRow {
+				line = selectedStyle.Render(line)
+			}
+			s += line + "\n"
+		}
+		s += "\n" + lipgloss.NewStyle().Faint(true).Render("◉ current message · name* current branch · ✓ checkpoint") + "\n\n"
+	}
+
+	switch {
+	case m.confirmDeleteBranch:
+		if branch := m.selectedGraphBranch(); branch != nil {
+			s += fmt.Sprintf("Delete branch %q? Its messages stay in the tree. (y/n)\n", branch.Name)
+		}
+	case m.browserPrompt == promptRenameBranch:
+		s += "Rename branch: " + m.textInput.View() + "\n"
+	default:
+		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+		s += helpStyle.Render("↑↓: Navigate | Enter: Switch here | B: Branch from checkpoint | R: Rename | D: Delete | V: Compare | C: Cherry-pick | M: Merge | S: Save tree | O: Open saved tree | Esc: Back") + "\n"
+	}
+
+	if m.statusMessage != "" {
+		statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+		s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
+	}
+
+	return s
+}
@@ -0,0 +1,601 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sahilm/fuzzy"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+// chatSort is a column the chat browser can be sorted by.
+type chatSort int
+
+const (
+	sortByUpdated chatSort = iota
+	sortByTitle
+	sortByMessages
+	sortByCost
+	sortByModel
+	chatSortCount
+)
+
+// String returns the column name shown in the browser.
+func (s chatSort) String() string {
+	switch s {
+	case sortByTitle:
+		return "title"
+	case sortByMessages:
+		return "messages"
+	case sortByCost:
+		return "cost"
+	case sortByModel:
+		return "model"
+	default:
+		return "last updated"
+	}
+}
+
+// browserPrompt is what the chat browser's text input is being used for.
+type browserPrompt int
+
+const (
+	promptNone browserPrompt = iota
+	promptRename
+	promptTags
+	promptFilter
+	promptRenameBranch // Renaming a branch in the branch manager
+)
+
+// browserAction is a destructive browser action that needs confirming.
+type browserAction int
+
+const (
+	actionNone browserAction = iota
+	actionTrash
+	actionPurge
+	actionEmptyTrash
+)
+
+// sortChats orders chats by a column, pinned chats first: text columns A-Z,
+// everything else largest or newest first. Ties fall back to the most
+// recently updated.
+func sortChats(chats []chat.ChatSummary, by chatSort) {
+	sort.SliceStable(chats, func(i, j int) bool {
+		a, b := chats[i], chats[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		switch by {
+		case sortByTitle:
+			if !strings.EqualFold(a.Title, b.Title) {
+				return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+			}
+		case sortByMessages:
+			if a.MessageCount != b.MessageCount {
+				return a.MessageCount > b.MessageCount
+			}
+		case sortByCost:
+			if a.Cost != b.Cost {
+				return a.Cost > b.Cost
+			}
+		case sortByModel:
+			if a.Model != b.Model {
+				return a.Model < b.Model
+			}
+		}
+		return a.UpdatedAt.After(b.UpdatedAt)
+	})
+}
+
+// selectedSummary returns the chat selected in the browser, or nil if the list is empty.
+func (m *model) selectedSummary() *chat.ChatSummary {
+	if m.selectedChat < 0 || m.selectedChat >= len(m.savedChats) {
+		return nil
+	}
+	return &m.savedChats[m.selectedChat]
+}
+
+// selectChatID selects the chat with the given ID, or keeps the selection in
+// range if it is no longer listed.
+func (m *model) selectChatID(id string) {
+	for i, summary := range m.savedChats {
+		if summary.ID == id {
+			m.selectedChat = i
+			return
+		}
+	}
+	m.selectedChat = max(min(m.selectedChat, len(m.savedChats)-1), 0)
+}
+
+// sortChatList re-sorts the browser list, keeping the same chat selected.
+func (m *model) sortChatList() {
+	selectedID := ""
+	if summary := m.selectedSummary(); summary != nil {
+		selectedID = summary.ID
+	}
+
+	sortChats(m.savedChats, m.browserSort)
+	m.selectChatID(selectedID)
+}
+
+// refreshChatList reloads the saved chats, or the trash, into the browser.
+func (m *model) refreshChatList() {
+	var chats []chat.ChatSummary
+	var err error
+	if m.showTrash {
+		chats, err = chat.ListTrash()
+	} else {
+		chats, err = chat.ListChatSummaries()
+	}
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to load chats: %v", err)
+		return
+	}
+
+	m.allChats = chats
+	m.applyBrowserFilter()
+}
+
+// applyBrowserFilter rebuilds the visible list from allChats. Archived chats
+// are hidden unless showArchived is set; a filter starting with # or "tag:"
+// matches a tag, anything else is fuzzy-matched against titles.
+func (m *model) applyBrowserFilter() {
+	selectedID := ""
+	if summary := m.selectedSummary(); summary != nil {
+		selectedID = summary.ID
+	}
+
+	filter := strings.TrimSpace(m.browserFilter)
+	tag := ""
+	switch {
+	case strings.HasPrefix(filter, "#"):
+		tag = strings.TrimLeft(filter, "#")
+	case len(filter) >= 4 && strings.EqualFold(filter[:4], "tag:"):
+		tag = strings.TrimSpace(filter[4:])
+	}
+
+	var titleMatches map[int]bool
+	if filter != "" && tag == "" {
+		titles := make([]string, len(m.allChats))
+		for i, summary := range m.allChats {
+			titles[i] = summary.Title
+		}
+		titleMatches = make(map[int]bool)
+		for _, match := range fuzzy.Find(filter, titles) {
+			titleMatches[match.Index] = true
+		}
+	}
+
+	visible := []chat.ChatSummary{}
+	for i, summary := range m.allChats {
+		if summary.Archived && !m.showArchived && !m.showTrash {
+			continue
+		}
+		if tag != "" && !chat.HasTag(summary.Tags, tag) {
+			continue
+		}
+		if titleMatches != nil && !titleMatches[i] {
+			continue
+		}
+		visible = append(visible, summary)
+	}
+
+	m.savedChats = visible
+	if !m.showTrash {
+		sortChats(m.savedChats, m.browserSort) // The trash stays newest-deleted first
+	}
+	m.selectChatID(selectedID)
+}
+
+// updateChatBrowser handles a key press in the chat browser.
+func (m *model) updateChatBrowser(msg tea.KeyMsg) tea.Cmd {
+	if m.browserConfirm != actionNone {
+		return m.confirmBrowserAction(msg.String() == "y" || msg.String() == "Y")
+	}
+	if m.browserPrompt != promptNone {
+		return m.updateBrowserPrompt(msg)
+	}
+
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.appState = stateChatting
+		m.statusMessage = "Back to chat"
+		return clearStatusAfterDelay()
+	case "esc":
+		// Step back out of the filter and the trash before leaving
+		switch {
+		case m.browserFilter != "":
+			m.browserFilter = ""
+			m.applyBrowserFilter()
+		case m.showTrash:
+			m.showTrash = false
+			m.refreshChatList()
+		default:
+			m.appState = stateChatting
+			m.statusMessage = "Back to chat"
+			return clearStatusAfterDelay()
+		}
+	case "up", "k":
+		if m.selectedChat > 0 {
+			m.selectedChat--
+		}
+	case "down", "j":
+		if m.selectedChat < len(m.savedChats)-1 {
+			m.selectedChat++
+		}
+	case "r":
+		m.refreshChatList()
+		m.statusMessage = "Chat list refreshed"
+		return clearStatusAfterDelay()
+	case "/":
+		m.openBrowserPrompt(promptFilter, m.browserFilter, "#tag or title")
+	case "T":
+		// Switch between the saved chats and the trash
+		m.showTrash = !m.showTrash
+		m.browserFilter = ""
+		m.selectedChat = 0
+		m.refreshChatList()
+	case "d", "delete":
+		if m.selectedSummary() != nil {
+			m.browserConfirm = actionTrash
+			if m.showTrash {
+				m.browserConfirm = actionPurge
+			}
+		}
+	default:
+		if m.showTrash {
+			return m.updateTrashBrowser(msg)
+		}
+		return m.updateChatList(msg)
+	}
+	return nil
+}
+
+// updateChatList handles the keys that act on saved chats.
+func (m *model) updateChatList(msg tea.KeyMsg) tea.Cmd {
+	summary := m.selectedSummary()
+
+	switch msg.String() {
+	case "enter":
+		if summary == nil {
+			return nil
+		}
+		if err := m.loadChatHistory(summary.Filename); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to load chat: %v", err)
+		} else {
+			m.appState = stateChatting
+		}
+		return clearStatusAfterDelay()
+	case "s":
+		// Cycle the sort column, keeping the selected chat selected
+		m.browserSort = (m.browserSort + 1) % chatSortCount
+		m.sortChatList()
+		m.statusMessage = fmt.Sprintf("Sorted by %s", m.browserSort)
+		return clearStatusAfterDelay()
+	case "A":
+		m.showArchived = !m.showArchived
+		m.applyBrowserFilter()
+		if m.showArchived {
+			m.statusMessage = "Showing archived chats"
+		} else {
+			m.statusMessage = "Hiding archived chats"
+		}
+		return clearStatusAfterDelay()
+	}
+
+	if summary == nil {
+		return nil
+	}
+
+	switch msg.String() {
+	case "p":
+		return m.updateSelectedChat(summary, func(id string) (string, error) {
+			if summary.Pinned {
+				return "Unpinned " + summary.Title, chat.SetChatPinned(id, false)
+			}
+			return "Pinned " + summary.Title, chat.SetChatPinned(id, true)
+		})
+	case "a":
+		return m.updateSelectedChat(summary, func(id string) (string, error) {
+			if summary.Archived {
+				return "Unarchived " + summary.Title, chat.SetChatArchived(id, false)
+			}
+			return "Archived " + summary.Title + " (Shift+A shows archived chats)", chat.SetChatArchived(id, true)
+		})
+	case "e":
+		m.openBrowserPrompt(promptRename, summary.Title, "New title")
+	case "t":
+		m.openBrowserPrompt(promptTags, strings.Join(summary.Tags, ", "), "Tags, separated by commas")
+	}
+	return nil
+}
+
+// updateTrashBrowser handles the keys that act on the trash.
+func (m *model) updateTrashBrowser(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "u":
+		if summary := m.selectedSummary(); summary != nil {
+			return m.updateSelectedChat(summary, func(id string) (string, error) {
+				return "Restored " + summary.Title, chat.RestoreChat(id)
+			})
+		}
+	case "E":
+		if len(m.allChats) > 0 {
+			m.browserConfirm = actionEmptyTrash
+		}
+	}
+	return nil
+}
+
+// updateSelectedChat runs fn on the selected chat, reports how it went and
+// reloads the list.
+func (m *model) updateSelectedChat(summary *chat.ChatSummary, fn func(id string) (string, error)) tea.Cmd {
+	status, err := fn(summary.ID)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to update chat: %v", err)
+	} else {
+		m.statusMessage = status
+	}
+	m.refreshChatList()
+	return clearStatusAfterDelay()
+}
+
+// openBrowserPrompt uses the text input for editing value.
+func (m *model) openBrowserPrompt(prompt browserPrompt, value, placeholder string) {
+	m.browserPrompt = prompt
+	m.textInput.SetValue(value)
+	m.textInput.CursorEnd()
+	m.textInput.Placeholder = placeholder
+	m.textInput.Focus()
+}
+
+// closeBrowserPrompt clears the text input after a prompt.
+func (m *model) closeBrowserPrompt() {
+	m.browserPrompt = promptNone
+	m.textInput.Reset()
+}
+
+// updateBrowserPrompt handles typing into a rename, tags or filter prompt.
+// The filter applies as you type.
+func (m *model) updateBrowserPrompt(msg tea.KeyMsg) tea.Cmd {
+	prompt := m.browserPrompt
+	switch msg.String() {
+	case "esc":
+		if prompt == promptFilter {
+			m.browserFilter = ""
+			m.applyBrowserFilter()
+		}
+		m.closeBrowserPrompt()
+		return nil
+	case "enter":
+		value := m.textInput.Value()
+		m.closeBrowserPrompt()
+		summary := m.selectedSummary()
+		if summary == nil || prompt == promptFilter {
+			return nil
+		}
+
+		if prompt == promptRename {
+			return m.updateSelectedChat(summary, func(id string) (string, error) {
+				if err := chat.RenameChat(id, value); err != nil {
+					return "", err
+				}
+				if id == m.chatID {
+					m.chatTitle = strings.TrimSpace(value)
+				}
+				return "Renamed to " + strings.TrimSpace(value), nil
+			})
+		}
+		return m.updateSelectedChat(summary, func(id string) (string, error) {
+			tags := chat.ParseTags(value)
+			if len(tags) == 0 {
+				return "Removed tags", chat.SetChatTags(id, nil)
+			}
+			return "Tagged #" + strings.Join(tags, " #"), chat.SetChatTags(id, tags)
+		})
+	}
+
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	if prompt == promptFilter {
+		m.browserFilter = m.textInput.Value()
+		m.applyBrowserFilter()
+	}
+	return cmd
+}
+
+// confirmBrowserAction carries out the pending delete if confirmed.
+func (m *model) confirmBrowserAction(confirmed bool) tea.Cmd {
+	action := m.browserConfirm
+	m.browserConfirm = actionNone
+	if !confirmed {
+		m.statusMessage = "Cancelled"
+		return clearStatusAfterDelay()
+	}
+
+	if action == actionEmptyTrash {
+		count, err := chat.EmptyTrash()
+		if err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to empty trash: %v", err)
+		} else {
+			m.statusMessage = fmt.Sprintf("Permanently deleted %d chats", count)
+		}
+		m.refreshChatList()
+		return clearStatusAfterDelay()
+	}
+
+	summary := m.selectedSummary()
+	if summary == nil {
+		return nil
+	}
+	if action == actionPurge {
+		return m.updateSelectedChat(summary, func(id string) (string, error) {
+			return "Permanently deleted " + summary.Title, chat.PurgeChat(id)
+		})
+	}
+	return m.updateSelectedChat(summary, func(id string) (string, error) {
+		if err := chat.TrashChat(id); err != nil {
+			return "", err
+		}
+		if id == m.chatID {
+			m.chatID = "" // Saving the open chat again starts a new one
+		}
+		return "Moved " + summary.Title + " to the trash (Shift+T shows the trash)", nil
+	})
+}
+
+// confirmPrompt returns the question shown for the pending browser action.
+func (m model) confirmPrompt() string {
+	switch m.browserConfirm {
+	case actionTrash:
+		return fmt.Sprintf("Move %q to the trash? (y/n)", m.savedChats[m.selectedChat].Title)
+	case actionPurge:
+		return fmt.Sprintf("Permanently delete %q? This can't be undone. (y/n)", m.savedChats[m.selectedChat].Title)
+	case actionEmptyTrash:
+		return fmt.Sprintf("Permanently delete all %d chats in the trash? (y/n)", len(m.allChats))
+	}
+	return ""
+}
+
+// chatLabel is a chat's title with its pin, archive and tag markers.
+func chatLabel(summary chat.ChatSummary) string {
+	label := summary.Title
+	if summary.Pinned {
+		label = "★ " + label
+	}
+	if summary.Archived {
+		label += " (archived)"
+	}
+	for _, tag := range summary.Tags {
+		label += " #" + tag
+	}
+	return label
+}
+
+// renderChatBrowser renders the chat browser or the trash.
+func (m model) renderChatBrowser() string {
+	title := "📁 Chat Browser"
+	if m.showTrash {
+		title = "🗑️ Trash"
+	}
+	s := lipgloss.NewStyle().Bold(true).Render(title) + "\n\n"
+
+	if m.browserFilter != "" && m.browserPrompt != promptFilter {
+		s += fmt.Sprintf("Filter: %s (%d of %d)\n\n", m.browserFilter, len(m.savedChats), len(m.allChats))
+	}
+
+	switch {
+	case len(m.savedChats) > 0:
+		s += m.renderChatTable() + "\n"
+	case m.browserFilter != "":
+		s += "No chats match the filter.\n"
+	case m.showTrash:
+		s += "The trash is empty.\n"
+	default:
+		s += "No saved chats found.\n"
+	}
+	s += "\n"
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	switch {
+	case m.browserConfirm != actionNone:
+		s += lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.currentTheme.Highlight)).Render(m.confirmPrompt()) + "\n"
+	case m.browserPrompt != promptNone:
+		labels := map[browserPrompt]string{promptRename: "Rename: ", promptTags: "Tags: ", promptFilter: "Filter: "}
+		s += labels[m.browserPrompt] + m.textInput.View() + "\n"
+		s += helpStyle.Render("Enter: Done | Esc: Cancel") + "\n"
+	case m.showTrash:
+		s += helpStyle.Render("↑↓: Navigate | U: Restore | D: Delete forever | Shift+E: Empty trash | /: Filter | Shift+T, Esc: Back to chats") + "\n"
+	default:
+		archived := "Shift+A: Show archived"
+		if m.showArchived {
+			archived = "Shift+A: Hide archived"
+		}
+		s += helpStyle.Render(fmt.Sprintf("↑↓: Navigate | Enter: Load | S: Sort (%s) | R: Refresh | Esc: Back", m.browserSort)) + "\n"
+		s += helpStyle.Render(fmt.Sprintf("P: Pin | E: Rename | T: Tags | A: Archive | %s | /: Filter | D: Trash | Shift+T: View trash", archived)) + "\n"
+	}
+
+	if m.statusMessage != "" {
+		statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+		s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
+	}
+
+	return s
+}
+
+// renderChatTable renders the saved chats as a table, scrolled to keep the
+// selected chat visible.
+func (m model) renderChatTable() string {
+	const (
+		countWidth   = 5
+		modelWidth   = 26
+		updatedWidth = 16
+		costWidth    = 8
+		gaps         = 4 * 2
+	)
+
+	width := m.viewport.Width
+	if width <= 0 {
+		width = 100
+	}
+	titleWidth := max(width-2-countWidth-modelWidth-updatedWidth-costWidth-gaps, 12)
+
+	row := func(title, count, modelName, updated, cost string) string {
+		return fmt.Sprintf("  %s  %*s  %s  %-*s  %*s",
+			wrap.Pad(wrap.Truncate(title, titleWidth, "…"), titleWidth),
+			countWidth, count,
+			wrap.Pad(wrap.Truncate(modelName, modelWidth, "…"), modelWidth),
+			updatedWidth, updated,
+			costWidth, cost)
+	}
+
+	dateHeader := "Updated"
+	if m.showTrash {
+		dateHeader = "Deleted"
+	}
+
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.currentTheme.Status))
+	var b strings.Builder
+	b.WriteString(headerStyle.Render(row("Title", "Msgs", "Model", dateHeader, "Cost")) + "\n")
+
+	// Show a window of rows around the selection
+	visible := max(m.viewport.Height-2, 5)
+	start := 0
+	if m.selectedChat >= visible {
+		start = m.selectedChat - visible + 1
+	}
+	end := min(start+visible, len(m.savedChats))
+
+	for i := start; i < end; i++ {
+		summary := m.savedChats[i]
+		style := lipgloss.NewStyle()
+		if i == m.selectedChat {
+			style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
+		}
+
+		date := summary.UpdatedAt
+		if m.showTrash {
+			date = summary.DeletedAt
+		}
+
+		b.WriteString(style.Render(row(
+			chatLabel(summary),
+			fmt.Sprintf("%d", summary.MessageCount),
+			summary.Model,
+			date.Format("2006-01-02 15:04"),
+			fmt.Sprintf("$%.4f", summary.Cost),
+		)) + "\n")
+	}
+
+	if len(m.savedChats) > visible {
+		b.WriteString(fmt.Sprintf("  (%d-%d of %d)\n", start+1, end, len(m.savedChats)))
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,446 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+
+	"lil_guy/internal/wrap"
+)
+
+const (
+	codeRunTimeout   = time.Minute // How long a piped command may run
+	maxRunOutput     = 20000       // Bytes of command output kept in the message
+	codePreviewLines = 12          // Lines of the selected block shown in the picker
+)
+
+// codeBlock is a fenced or indented code block in a reply.
+type codeBlock struct {
+	Language string
+	Filename string // Suggested by the fence or a comment on the first line
+	Code     string
+}
+
+// codePrompt is what the code block picker is asking for.
+type codePrompt int
+
+const (
+	codePromptNone codePrompt = iota
+	codePromptSave            // Filename to save the block to
+	codePromptRun             // Command to pipe the block into
+)
+
+// codeRunMsg carries the output of a block piped into a command.
+type codeRunMsg struct {
+	Number  int
+	Command string
+	Output  string
+	Err     error
+}
+
+var (
+	// fenceFilename matches a filename in a fence's info string, as in
+	// ```go title="main.go"
+	fenceFilename = regexp.MustCompile(`^(?:(?:title|file|filename|name)=)?["']?([\w./-]+\.[A-Za-z]\w*)["']?$`)
+
+	// commentFilename matches a first line naming the file, such as
+	// "// main.go" or "# file: setup.py"
+	commentFilename = regexp.MustCompile(`^\s*(?://|#|--|;|/\*|<!--)\s*(?:(?:file|filename|path):\s*)?([\w./-]+\.[A-Za-z]\w*)\s*(?:\*/|-->)?\s*$`)
+)
+
+// blockRunners are the commands offered for running blocks in a language.
+var blockRunners = map[string]string{
+	"sh":         "sh",
+	"shell":      "sh",
+	"console":    "sh",
+	"bash":       "bash",
+	"zsh":        "zsh",
+	"fish":       "fish",
+	"python":     "python3",
+	"py":         "python3",
+	"javascript": "node",
+	"js":         "node",
+	"ruby":       "ruby",
+	"perl":       "perl",
+	"powershell": "pwsh",
+}
+
+// newCodeBlock reads a code block node, picking up a filename hint from its
+// fence or first line.
+func newCodeBlock(node ast.Node, source []byte) codeBlock {
+	block := codeBlock{Code: rawLines(node, source)}
+
+	if fenced, ok := node.(*ast.FencedCodeBlock); ok && fenced.Info != nil {
+		for i, field := range strings.Fields(string(fenced.Info.Segment.Value(source))) {
+			if match := fenceFilename.FindStringSubmatch(field); match != nil {
+				block.Filename = match[1]
+			} else if i == 0 {
+				block.Language = field
+			}
+		}
+		// ```main.go names the file and the language
+		if block.Language == "" && block.Filename != "" {
+			block.Language = strings.TrimPrefix(path.Ext(block.Filename), ".")
+		}
+	}
+
+	if block.Filename == "" {
+		firstLine, _, _ := strings.Cut(block.Code, "\n")
+		if match := commentFilename.FindStringSubmatch(firstLine); match != nil {
+			block.Filename = match[1]
+		}
+	}
+	return block
+}
+
+// codeBlocks returns the code blocks in markdown, in the order they're
+// rendered and numbered.
+func codeBlocks(content string) []codeBlock {
+	source := []byte(content)
+	var blocks []codeBlock
+	ast.Walk(markdownParser.Parse(text.NewReader(source)), func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch node.(type) {
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if entering {
+				blocks = append(blocks, newCodeBlock(node, source))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return blocks
+}
+
+// codeBlockCounts holds how many code blocks each reply has, so numbering
+// them doesn't parse every reply on every spinner tick.
+var codeBlockCounts = struct {
+	sync.Mutex
+	entries map[string]int
+}{entries: map[string]int{}}
+
+// countCodeBlocks returns len(codeBlocks(content)), parsing each reply once.
+func countCodeBlocks(content string) int {
+	codeBlockCounts.Lock()
+	count, ok := codeBlockCounts.entries[content]
+	codeBlockCounts.Unlock()
+	if ok {
+		return count
+	}
+
+	count = len(codeBlocks(content))
+	codeBlockCounts.Lock()
+	if len(codeBlockCounts.entries) >= markdownCacheSize {
+		codeBlockCounts.entries = map[string]int{}
+	}
+	codeBlockCounts.entries[content] = count
+	codeBlockCounts.Unlock()
+	return count
+}
+
+// conversationCodeBlocks returns the code blocks of every reply, so block N
+// is the one labelled [N] in the chat.
+func (m model) conversationCodeBlocks() []codeBlock {
+	var blocks []codeBlock
+	for _, msg := range m.chatMessages {
+		if msg.Role == "assistant" {
+			blocks = append(blocks, codeBlocks(msg.Content)...)
+		}
+	}
+	return blocks
+}
+
+// defaultBlockFilename suggests a file to save a block to.
+func defaultBlockFilename(number int, block codeBlock) string {
+	if block.Filename != "" {
+		return block.Filename
+	}
+	ext := ".txt"
+	if lexer := lexers.Get(block.Language); lexer != nil && block.Language != "" {
+		if patterns := lexer.Config().Filenames; len(patterns) > 0 && strings.HasPrefix(patterns[0], "*.") {
+			ext = patterns[0][1:]
+		}
+	}
+	return fmt.Sprintf("block_%d%s", number, ext)
+}
+
+// openCodeBlocks lists the code blocks in the conversation, starting at the
+// newest.
+func (m *model) openCodeBlocks() tea.Cmd {
+	blocks := m.conversationCodeBlocks()
+	if len(blocks) == 0 {
+		m.statusMessage = "No code blocks in the conversation"
+		return clearStatusAfterDelay()
+	}
+
+	m.codeBlockList = blocks
+	m.selectedCodeBlock = len(blocks) - 1
+	m.codePrompt = codePromptNone
+	m.codeConfirm = codePromptNone
+	m.appState = stateCodeBlocks
+	m.statusMessage = ""
+	return nil
+}
+
+// updateCodeBlocks handles keys in the code block picker.
+func (m *model) updateCodeBlocks(msg tea.KeyMsg) tea.Cmd {
+	if m.codeConfirm != codePromptNone {
+		return m.confirmCodeAction(msg)
+	}
+	if m.codePrompt != codePromptNone {
+		return m.updateCodePrompt(msg)
+	}
+
+	block := m.codeBlockList[m.selectedCodeBlock]
+	number := m.selectedCodeBlock + 1
+	switch key := msg.String(); key {
+	case "ctrl+c", "q", "esc":
+		m.appState = stateChatting
+		m.codeBlockList = nil
+	case "up", "k":
+		if m.selectedCodeBlock > 0 {
+			m.selectedCodeBlock--
+		}
+	case "down", "j":
+		if m.selectedCodeBlock < len(m.codeBlockList)-1 {
+			m.selectedCodeBlock++
+		}
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		if n, _ := strconv.Atoi(key); n <= len(m.codeBlockList) {
+			m.selectedCodeBlock = n - 1
+		}
+	case "enter", "c", "y":
+		if err := m.copyToClipboard(block.Code); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to copy: %v", err)
+		} else {
+			m.statusMessage = fmt.Sprintf("Copied code block %d", number)
+		}
+		return clearStatusAfterDelay()
+	case "s":
+		m.codePrompt = codePromptSave
+		m.textInput.SetValue(defaultBlockFilename(number, block))
+		m.textInput.CursorEnd()
+		m.textInput.Placeholder = "filename"
+		m.textInput.Focus()
+	case "r", "|":
+		if m.isThinking || m.isTyping {
+			m.statusMessage = "Wait for the reply to finish"
+			return clearStatusAfterDelay()
+		}
+		m.codePrompt = codePromptRun
+		m.textInput.SetValue(blockRunners[strings.ToLower(block.Language)])
+		m.textInput.CursorEnd()
+		m.textInput.Placeholder = "command, e.g. python3 or wc -l"
+		m.textInput.Focus()
+	}
+	return nil
+}
+
+// updateCodePrompt handles typing a filename or command for the selected block.
+func (m *model) updateCodePrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.codePrompt = codePromptNone
+		m.textInput.Reset()
+		return nil
+	case "enter":
+		value := strings.TrimSpace(m.textInput.Value())
+		prompt := m.codePrompt
+		m.codePrompt = codePromptNone
+		m.textInput.Reset()
+		if value == "" {
+			return nil
+		}
+
+		m.codeTarget = value
+		if prompt == codePromptRun {
+			// Running anything needs a second look
+			m.codeConfirm = codePromptRun
+			return nil
+		}
+		if _, err := os.Stat(expandHome(value)); err == nil {
+			m.codeConfirm = codePromptSave
+			return nil
+		}
+		return m.saveCodeBlock(value)
+	}
+
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	return cmd
+}
+
+// confirmCodeAction runs or saves once the user answers y.
+func (m *model) confirmCodeAction(msg tea.KeyMsg) tea.Cmd {
+	action := m.codeConfirm
+	m.codeConfirm = codePromptNone
+	if msg.String() != "y" && msg.String() != "Y" {
+		m.statusMessage = "Cancelled"
+		return clearStatusAfterDelay()
+	}
+
+	if action == codePromptSave {
+		return m.saveCodeBlock(m.codeTarget)
+	}
+
+	number, code := m.selectedCodeBlock+1, m.codeBlockList[m.selectedCodeBlock].Code
+	m.appState = stateChatting
+	m.codeBlockList = nil
+	m.statusMessage = fmt.Sprintf("Running %s on code block %d...", m.codeTarget, number)
+	return runCodeBlock(number, m.codeTarget, code)
+}
+
+// saveCodeBlock writes the selected block to a file.
+func (m *model) saveCodeBlock(filename string) tea.Cmd {
+	block := m.codeBlockList[m.selectedCodeBlock]
+	filename = expandHome(filename)
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to create directory: %v", err)
+			return clearStatusAfterDelay()
+		}
+	}
+	if err := os.WriteFile(filename, []byte(block.Code), 0644); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save: %v", err)
+	} else {
+		m.statusMessage = fmt.Sprintf("Saved code block %d to %s", m.selectedCodeBlock+1, filename)
+	}
+	return clearStatusAfterDelay()
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(filename string) string {
+	if rest, ok := strings.CutPrefix(filename, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return filename
+}
+
+// runCodeBlock pipes code into a shell command in the background.
+func runCodeBlock(number int, command, code string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), codeRunTimeout)
+		defer cancel()
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		cmd.Stdin = strings.NewReader(code)
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", codeRunTimeout)
+		}
+		return codeRunMsg{Number: number, Command: command, Output: string(output), Err: err}
+	}
+}
+
+// applyCodeRun adds a command's output to the conversation as a message, so
+// the next question can refer to it.
+func (m *model) applyCodeRun(msg codeRunMsg) {
+	output := strings.TrimRight(msg.Output, "\n")
+	if len(output) > maxRunOutput {
+		output = strings.ToValidUTF8(output[:maxRunOutput], "") + "\n... (output truncated)"
+	}
+
+	// Fence the output with more backticks than it contains
+	fence := "```"
+	for strings.Contains(output, fence) {
+		fence += "`"
+	}
+	content := fmt.Sprintf("Output of `%s` on code block %d:\n\n%s\n%s\n%s", msg.Command, msg.Number, fence, output, fence)
+	if msg.Err != nil {
+		content += fmt.Sprintf("\n\n(%v)", msg.Err)
+	}
+
+	// Don't interleave with a reply that's coming in
+	if m.isThinking || m.isTyping {
+		m.setComposer(content)
+		m.statusMessage = "Command finished during a reply - its output is in the message box"
+		return
+	}
+
+	m.addChatMessage("user", content)
+	m.updateViewportContent()
+	if msg.Err != nil {
+		m.statusMessage = fmt.Sprintf("%s failed: %v", msg.Command, msg.Err)
+	} else {
+		m.statusMessage = fmt.Sprintf("Added the output of %s", msg.Command)
+	}
+}
+
+// renderCodeBlocks draws the code block picker.
+func (m model) renderCodeBlocks() string {
+	s := lipgloss.NewStyle().Bold(true).Render("📦 Code Blocks") + "\n\n"
+
+	width := max(m.viewport.Width, 40)
+	for i, block := range m.codeBlockList {
+		style := lipgloss.NewStyle()
+		if i == m.selectedCodeBlock {
+			style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
+		}
+		name := block.Language
+		if name == "" {
+			name = "code"
+		}
+		if block.Filename != "" {
+			name += " · " + block.Filename
+		}
+		lines := strings.Count(strings.TrimRight(block.Code, "\n"), "\n") + 1
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(block.Code), "\n")
+		line := fmt.Sprintf("  [%d] %s (%d lines)  %s", i+1, name, lines, firstLine)
+		s += style.Render(wrap.Truncate(line, width-2, "…")) + "\n"
+	}
+
+	// Show the start of the selected block
+	block := m.codeBlockList[m.selectedCodeBlock]
+	previewStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.Status))
+	code := strings.Split(strings.TrimRight(strings.ReplaceAll(block.Code, "\t", "    "), "\n"), "\n")
+	s += "\n"
+	for i, line := range code {
+		if i == codePreviewLines {
+			s += previewStyle.Render(fmt.Sprintf("    ... %d more lines", len(code)-codePreviewLines)) + "\n"
+			break
+		}
+		s += previewStyle.Render("    "+wrap.Truncate(line, width-6, "…")) + "\n"
+	}
+	s += "\n"
+
+	switch {
+	case m.codeConfirm == codePromptRun:
+		s += fmt.Sprintf("Run %q with code block %d as its input? Its output is added to the chat. (y/n)\n\n", m.codeTarget, m.selectedCodeBlock+1)
+	case m.codeConfirm == codePromptSave:
+		s += fmt.Sprintf("%s already exists. Overwrite it? (y/n)\n\n", m.codeTarget)
+	case m.codePrompt == codePromptSave:
+		s += "Save to: " + m.textInput.View() + "\n\n"
+	case m.codePrompt == codePromptRun:
+		s += "Pipe into: " + m.textInput.View() + "\n\n"
+	}
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	s += helpStyle.Render("↑↓/1-9: Select | Enter/C: Copy | S: Save to file | R: Pipe into a command | Esc: Back") + "\n"
+
+	if m.statusMessage != "" {
+		statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+		s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
+	}
+	return s
+}
@@ -0,0 +1,126 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// composerMaxHeight is how many lines the message composer grows to before
+// it scrolls.
+const composerMaxHeight = 8
+
+// editorMsg reports that the external editor has exited.
+type editorMsg struct {
+	Path string // Temp file holding the message
+	Err  error
+}
+
+// createComposer creates the multi-line message input. Enter sends, so new
+// lines are on Alt+Enter and Ctrl+J.
+func createComposer() textarea.Model {
+	ta := textarea.New()
+	ta.Placeholder = "Type your message..."
+	ta.Prompt = textInputPrompt
+	ta.ShowLineNumbers = false
+	ta.CharLimit = 0 // No limit, for pasted logs and code
+	ta.MaxHeight = 0 // Height is managed by resizeComposer
+	ta.SetWidth(textInputWidth)
+	ta.SetHeight(1)
+	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "shift+enter", "ctrl+j"))
+	ta.Focus()
+	return ta
+}
+
+// resizeComposer fits the composer to its text, up to composerMaxHeight
+// lines, and gives the rest of the screen to the chat.
+func (m *model) resizeComposer() {
+	height := min(max(m.composer.LineCount(), 1), composerMaxHeight)
+	if delta := height - m.composer.Height(); delta != 0 {
+		m.composer.SetHeight(height)
+		m.viewport.Height = max(m.viewport.Height-delta, 1)
+	}
+}
+
+// setComposer replaces the text in the composer.
+func (m *model) setComposer(value string) {
+	m.composer.SetValue(value)
+	m.resizeComposer()
+}
+
+// resetComposer empties the composer.
+func (m *model) resetComposer() {
+	m.composer.Reset()
+	m.resizeComposer()
+}
+
+// openEditor writes the draft to a temp file and opens it in $VISUAL or
+// $EDITOR. The saved file is sent when the editor exits.
+func (m *model) openEditor() tea.Cmd {
+	if m.isThinking || m.isTyping {
+		m.statusMessage = "Wait for the reply to finish"
+		return clearStatusAfterDelay()
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	file, err := os.CreateTemp("", "lil_guy-*.md")
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to create temp file: %v", err)
+		return clearStatusAfterDelay()
+	}
+	_, err = file.WriteString(m.composer.Value())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		m.statusMessage = fmt.Sprintf("Failed to write temp file: %v", err)
+		return clearStatusAfterDelay()
+	}
+
+	// $EDITOR may carry flags, e.g. "code --wait"
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], file.Name())...)
+	path := file.Name()
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorMsg{Path: path, Err: err}
+	})
+}
+
+// applyEditor sends what was saved in the external editor.
+func (m *model) applyEditor(msg editorMsg) tea.Cmd {
+	defer os.Remove(msg.Path)
+	if msg.Err != nil {
+		m.statusMessage = fmt.Sprintf("Editor failed: %v", msg.Err)
+		return clearStatusAfterDelay()
+	}
+
+	data, err := os.ReadFile(msg.Path)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to read message: %v", err)
+		return clearStatusAfterDelay()
+	}
+
+	// Editors end the file with a newline
+	value := strings.TrimRight(string(data), "\r\n")
+	if strings.TrimSpace(value) == "" {
+		m.statusMessage = "Empty message - nothing sent"
+		return clearStatusAfterDelay()
+	}
+	m.setComposer(value)
+	return m.submitInput(value)
+}
@@ -0,0 +1,196 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+// graphRowRef returns the ID the diff loads a graph row's conversation by,
+// and a label for it: the row's branch, else its checkpoint, else the message.
+func (m model) graphRowRef(row graphRow) (string, string) {
+	if branch := m.graphRowBranch(row); branch != nil {
+		return branch.ID, branch.Name
+	}
+	if n := len(row.checkpoints); n > 0 {
+		return row.checkpoints[n-1].ID, "✓ " + row.checkpoints[n-1].Name
+	}
+	return row.nodeID, fmt.Sprintf("message %d", row.messages)
+}
+
+// markForDiff picks the selected graph row as one side of a diff. Picking a
+// second row opens the diff.
+func (m *model) markForDiff() tea.Cmd {
+	if m.selectedGraphRow >= len(m.graphRows) {
+		return nil
+	}
+	ref, label := m.graphRowRef(m.graphRows[m.selectedGraphRow])
+	if m.diffFrom == "" {
+		m.diffFrom, m.diffFromLabel = ref, label
+		m.statusMessage = fmt.Sprintf("Comparing %s - select another row and press V", label)
+		return nil
+	}
+
+	from, fromLabel := m.diffFrom, m.diffFromLabel
+	m.diffFrom, m.diffFromLabel = "", ""
+	diff, err := m.conversationTree.Diff(from, ref)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to compare: %v", err)
+		return clearStatusAfterDelay()
+	}
+
+	width, _, _ := term.GetSize(os.Stdout.Fd())
+	m.diffTitle = fmt.Sprintf("%s ↔ %s", fromLabel, label)
+	m.diffLines = m.renderConversationDiff(diff, width)
+	m.diffScroll = 0
+	m.appState = stateBranchDiff
+	m.statusMessage = ""
+	return nil
+}
+
+// diffCell truncates and pads text to a column of the side-by-side diff,
+// then styles it.
+func diffCell(text string, width int, style lipgloss.Style) string {
+	text = wrap.Truncate(strings.ReplaceAll(text, "\t", "    "), width, "…")
+	return style.Render(wrap.Pad(text, width))
+}
+
+// renderConversationDiff lays out two diverging conversations side by side.
+// Messages in the same position with the same role get a line-level diff.
+func (m model) renderConversationDiff(diff chat.ConversationDiff, width int) []string {
+	column := max(20, (width-7)/2)
+	plain := lipgloss.NewStyle()
+	removed := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	added := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	header := lipgloss.NewStyle().Bold(true)
+	row := func(left, right string, leftStyle, rightStyle lipgloss.Style) string {
+		return " " + diffCell(left, column, leftStyle) + " │ " + diffCell(right, column, rightStyle)
+	}
+
+	lines := []string{fmt.Sprintf("Both start with the same %d messages", len(diff.Common))}
+	if n := len(diff.Common); n > 0 {
+		last := diff.Common[n-1]
+		preview := strings.Join(strings.Fields(last.Content), " ")
+		lines = append(lines, lipgloss.NewStyle().Faint(true).Render(
+			fmt.Sprintf("Last shared: %s: %s", chat.RoleLabel(last.Role, m.buddyName), wrap.Truncate(preview, max(20, width-20), "…"))))
+	}
+	if len(diff.Left) == 0 && len(diff.Right) == 0 {
+		return append(lines, "", "The conversations are the same")
+	}
+
+	for i := 0; i < max(len(diff.Left), len(diff.Right)); i++ {
+		var left, right *chat.ChatMessage
+		leftTitle, rightTitle := "", ""
+		if i < len(diff.Left) {
+			left = &diff.Left[i]
+			leftTitle = fmt.Sprintf("#%d %s", len(diff.Common)+i+1, chat.RoleLabel(left.Role, m.buddyName))
+		}
+		if i < len(diff.Right) {
+			right = &diff.Right[i]
+			rightTitle = fmt.Sprintf("#%d %s", len(diff.Common)+i+1, chat.RoleLabel(right.Role, m.buddyName))
+		}
+		lines = append(lines, "", row(leftTitle, rightTitle, header, header))
+
+		if left == nil || right == nil || left.Role != right.Role {
+			// Nothing to line up, so show each side whole
+			var leftLines, rightLines []string
+			if left != nil {
+				leftLines = strings.Split(left.Content, "\n")
+			}
+			if right != nil {
+				rightLines = strings.Split(right.Content, "\n")
+			}
+			for j := 0; j < max(len(leftLines), len(rightLines)); j++ {
+				l, r := "", ""
+				if j < len(leftLines) {
+					l = leftLines[j]
+				}
+				if j < len(rightLines) {
+					r = rightLines[j]
+				}
+				lines = append(lines, row(l, r, plain, plain))
+			}
+			continue
+		}
+
+		// Pair each run of removed lines with the added lines that replace it
+		lineDiff := chat.DiffLines(left.Content, right.Content)
+		for j := 0; j < len(lineDiff); {
+			if lineDiff[j].Op == chat.DiffEqual {
+				lines = append(lines, row("  "+lineDiff[j].Text, "  "+lineDiff[j].Text, plain, plain))
+				j++
+				continue
+			}
+
+			var deleted, inserted []string
+			for ; j < len(lineDiff) && lineDiff[j].Op != chat.DiffEqual; j++ {
+				if lineDiff[j].Op == chat.DiffDelete {
+					deleted = append(deleted, "- "+lineDiff[j].Text)
+				} else {
+					inserted = append(inserted, "+ "+lineDiff[j].Text)
+				}
+			}
+			for k := 0; k < max(len(deleted), len(inserted)); k++ {
+				l, r := "", ""
+				if k < len(deleted) {
+					l = deleted[k]
+				}
+				if k < len(inserted) {
+					r = inserted[k]
+				}
+				lines = append(lines, row(l, r, removed, added))
+			}
+		}
+	}
+	return lines
+}
+
+// diffPageSize is how many diff lines fit on screen.
+func diffPageSize() int {
+	_, height, _ := term.GetSize(os.Stdout.Fd())
+	return max(5, height-8)
+}
+
+// updateBranchDiff handles scrolling the diff view.
+func (m *model) updateBranchDiff(msg tea.KeyMsg) tea.Cmd {
+	page := diffPageSize()
+	lastScroll := max(0, len(m.diffLines)-page)
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.appState = stateBranchManager
+		m.diffLines = nil
+	case "up", "k":
+		m.diffScroll = max(0, m.diffScroll-1)
+	case "down", "j":
+		m.diffScroll = min(lastScroll, m.diffScroll+1)
+	case "pgup":
+		m.diffScroll = max(0, m.diffScroll-page)
+	case "pgdown", " ":
+		m.diffScroll = min(lastScroll, m.diffScroll+page)
+	case "home", "g":
+		m.diffScroll = 0
+	case "end", "G":
+		m.diffScroll = lastScroll
+	}
+	return nil
+}
+
+// renderBranchDiff draws the visible part of the diff.
+func (m model) renderBranchDiff() string {
+	s := lipgloss.NewStyle().Bold(true).Render("🔀 Diff: "+m.diffTitle) + "\n\n"
+
+	end := min(len(m.diffLines), m.diffScroll+diffPageSize())
+	s += strings.Join(m.diffLines[m.diffScroll:end], "\n") + "\n\n"
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	s += helpStyle.Render(fmt.Sprintf("Lines %d-%d of %d | ↑↓/PgUp/PgDn: Scroll | Esc: Back to branches",
+		min(m.diffScroll+1, end), end, len(m.diffLines))) + "\n"
+	return s
+}
@@ -0,0 +1,47 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"lil_guy/internal/chat"
+)
+
+// importMsg reports the result of importing a ChatGPT or Claude.ai export.
+type importMsg struct {
+	Stats chat.ImportStats
+	Err   error
+}
+
+// importExport imports a data export in the background.
+func importExport(path string) tea.Cmd {
+	path = strings.Trim(strings.TrimSpace(path), `"'`)
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+
+	return func() tea.Msg {
+		stats, err := chat.ImportConversations(path)
+		return importMsg{Stats: stats, Err: err}
+	}
+}
+
+// applyImport reports how an import went and refreshes the chat browser if it's open.
+func (m *model) applyImport(msg importMsg) {
+	if msg.Err != nil {
+		m.statusMessage = fmt.Sprintf("Import failed: %v", msg.Err)
+		return
+	}
+
+	m.statusMessage = fmt.Sprintf("Imported %d chats and %d branched conversations (%d skipped) - Ctrl+B to browse",
+		msg.Stats.Chats, msg.Stats.Trees, msg.Stats.Skipped)
+	if m.appState == stateChatBrowser {
+		m.refreshChatList()
+	}
+}
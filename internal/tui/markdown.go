@@ -0,0 +1,402 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+
+	"lil_guy/internal/wrap"
+)
+
+// markdownParser parses CommonMark with the GitHub extensions: tables, task
+// lists, strikethrough and autolinks.
+var markdownParser = goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser()
+
+// markdownCacheSize bounds the rendered replies kept between frames.
+const markdownCacheSize = 256
+
+// markdownCache holds rendered replies, since the chat is redrawn on every
+// spinner tick.
+var markdownCache = struct {
+	sync.Mutex
+	entries map[markdownKey]string
+}{entries: map[markdownKey]string{}}
+
+// markdownKey is everything a rendered reply depends on.
+type markdownKey struct {
+	content    string
+	width      int
+	theme      Theme
+	dark       bool
+	firstBlock int
+}
+
+// renderMarkdown renders markdown for the terminal, wrapped to width and
+// coloured by the theme. Code blocks are highlighted with chroma and numbered
+// from firstBlock, to match codeBlocks.
+func renderMarkdown(content string, width int, theme Theme, dark bool, firstBlock int) string {
+	width = max(width, 20)
+	key := markdownKey{content, width, theme, dark, firstBlock}
+
+	markdownCache.Lock()
+	rendered, ok := markdownCache.entries[key]
+	markdownCache.Unlock()
+	if ok {
+		return rendered
+	}
+
+	r := &markdownRenderer{source: []byte(content), theme: theme, dark: dark, nextBlock: firstBlock}
+	doc := markdownParser.Parse(text.NewReader(r.source))
+	rendered = strings.Join(r.blocks(doc, width), "\n")
+
+	markdownCache.Lock()
+	if len(markdownCache.entries) >= markdownCacheSize {
+		markdownCache.entries = map[markdownKey]string{}
+	}
+	markdownCache.entries[key] = rendered
+	markdownCache.Unlock()
+	return rendered
+}
+
+// markdownRenderer turns a markdown AST into styled terminal lines.
+type markdownRenderer struct {
+	source    []byte
+	theme     Theme
+	dark      bool
+	nextBlock int // Number of the next code block
+}
+
+// blocks renders the children of a container node, separated by blank lines.
+func (r *markdownRenderer) blocks(parent ast.Node, width int) []string {
+	var lines []string
+	for node := parent.FirstChild(); node != nil; node = node.NextSibling() {
+		block := r.block(node, width)
+		if len(block) == 0 {
+			continue
+		}
+		if len(lines) > 0 && !isTightItem(node) {
+			lines = append(lines, "")
+		}
+		lines = append(lines, block...)
+	}
+	return lines
+}
+
+// isTightItem reports whether a block sits in a tight list item, where
+// paragraphs and nested lists aren't separated by blank lines.
+func isTightItem(node ast.Node) bool {
+	item, ok := node.Parent().(*ast.ListItem)
+	if !ok {
+		return false
+	}
+	list, ok := item.Parent().(*ast.List)
+	return ok && list.IsTight
+}
+
+// block renders a single block node.
+func (r *markdownRenderer) block(node ast.Node, width int) []string {
+	switch node := node.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return wrapStyled(r.inlines(node), width)
+
+	case *ast.Heading:
+		style := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(r.theme.AssistantMessage))
+		if node.Level == 1 {
+			style = style.Underline(true)
+		}
+		title := r.inlines(node)
+		if node.Level > 2 {
+			title = strings.Repeat("#", node.Level) + " " + title
+		}
+		return wrapStyled(style.Render(title), width)
+
+	case *ast.ThematicBreak:
+		return []string{lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Status)).Render(strings.Repeat("─", width))}
+
+	case *ast.Blockquote:
+		bar := lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Status)).Render("│ ")
+		quote := lipgloss.NewStyle().Italic(true)
+		lines := r.blocks(node, width-2)
+		for i, line := range lines {
+			lines[i] = bar + quote.Render(line)
+		}
+		return lines
+
+	case *ast.List:
+		return r.list(node, width)
+
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		return r.codeBlock(newCodeBlock(node, r.source), width)
+
+	case *ast.HTMLBlock:
+		return wrapStyled(lipgloss.NewStyle().Faint(true).Render(strings.TrimRight(r.rawLines(node), "\n")), width)
+
+	case *east.Table:
+		return r.table(node, width)
+	}
+
+	// Anything else, such as footnotes, is shown as its text
+	return wrapStyled(r.inlines(node), width)
+}
+
+// list renders bullet or numbered items, indenting what follows the marker.
+func (r *markdownRenderer) list(list *ast.List, width int) []string {
+	markerStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Spinner))
+	number := list.Start
+	if number == 0 {
+		number = 1
+	}
+
+	// Numbers are right-aligned so items line up
+	markerWidth := 2
+	if list.IsOrdered() {
+		markerWidth = len(fmt.Sprintf("%d.", number+list.ChildCount()-1)) + 1
+	}
+
+	var lines []string
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "•"
+		if list.IsOrdered() {
+			marker = fmt.Sprintf("%d.", number)
+			number++
+		}
+		marker = fmt.Sprintf("%*s ", markerWidth-1, marker)
+
+		if len(lines) > 0 && !list.IsTight {
+			lines = append(lines, "")
+		}
+		body := r.blocks(item, width-markerWidth)
+		if len(body) == 0 {
+			body = []string{""}
+		}
+		for i, line := range body {
+			if i == 0 {
+				lines = append(lines, markerStyle.Render(marker)+line)
+			} else {
+				lines = append(lines, strings.Repeat(" ", markerWidth)+line)
+			}
+		}
+	}
+	return lines
+}
+
+// rawLines returns the source text of a code or HTML block.
+func (r *markdownRenderer) rawLines(node ast.Node) string {
+	return rawLines(node, r.source)
+}
+
+// rawLines returns the source text of a block node.
+func rawLines(node ast.Node, source []byte) string {
+	var b strings.Builder
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		b.Write(segment.Value(source))
+	}
+	return b.String()
+}
+
+// codeBlock highlights code and draws it in a box labelled with its number,
+// language and filename.
+func (r *markdownRenderer) codeBlock(block codeBlock, width int) []string {
+	code, language := strings.TrimRight(block.Code, "\n"), block.Language
+	r.nextBlock++
+
+	var lexer chroma.Lexer
+	if language != "" {
+		lexer = lexers.Get(language)
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	// Choose appropriate style based on theme
+	styleName := "github"
+	if r.dark {
+		styleName = "github-dark"
+	}
+	style := styles.Get(styleName)
+	if style == nil {
+		style = styles.Fallback
+	}
+	formatter := formatters.Get("terminal256")
+	if formatter == nil {
+		formatter = formatters.Fallback
+	}
+
+	highlighted := code
+	if tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code); err == nil {
+		var buf strings.Builder
+		if err := formatter.Format(&buf, style, tokens); err == nil {
+			highlighted = strings.TrimRight(buf.String(), "\n")
+		}
+	}
+
+	if language == "" {
+		language = "code"
+	}
+	if block.Filename != "" {
+		language += " · " + block.Filename
+	}
+	number := lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Status)).Render(fmt.Sprintf("[%d] ", r.nextBlock))
+	label := number + lipgloss.NewStyle().Foreground(lipgloss.Color("12")).Bold(true).Render(language)
+
+	codeStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		Padding(0, 1)
+
+	// Long lines wrap inside the box rather than breaking its border
+	if lipgloss.Width(highlighted)+4 > width {
+		codeStyle = codeStyle.Width(width - 2)
+	}
+	return strings.Split(codeStyle.Render(label+"\n"+highlighted), "\n")
+}
+
+// table lays out a GFM table, squeezing its columns to fit the width.
+func (r *markdownRenderer) table(node *east.Table, width int) []string {
+	var headers []string
+	var rows [][]string
+	for row := node.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, r.inlines(cell))
+		}
+		if _, ok := row.(*east.TableHeader); ok {
+			headers = cells
+		} else {
+			rows = append(rows, cells)
+		}
+	}
+
+	borderStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Status))
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(r.theme.AssistantMessage)).Padding(0, 1)
+	cellStyle := lipgloss.NewStyle().Padding(0, 1)
+	alignments := node.Alignments
+
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		BorderStyle(borderStyle).
+		Headers(headers...).
+		Rows(rows...).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			style := cellStyle
+			if row == table.HeaderRow {
+				style = headerStyle
+			}
+			if col < len(alignments) {
+				switch alignments[col] {
+				case east.AlignCenter:
+					style = style.Align(lipgloss.Center)
+				case east.AlignRight:
+					style = style.Align(lipgloss.Right)
+				}
+			}
+			return style
+		})
+
+	rendered := t.Render()
+	if lipgloss.Width(rendered) > width {
+		rendered = t.Width(width).Render()
+	}
+	return strings.Split(rendered, "\n")
+}
+
+// inlines renders the inline children of a node as one styled string.
+func (r *markdownRenderer) inlines(parent ast.Node) string {
+	var b strings.Builder
+	for node := parent.FirstChild(); node != nil; node = node.NextSibling() {
+		b.WriteString(r.inline(node))
+	}
+	return b.String()
+}
+
+// inline renders a single inline node.
+func (r *markdownRenderer) inline(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.Text:
+		s := string(node.Segment.Value(r.source))
+		switch {
+		case node.HardLineBreak():
+			s += "\n"
+		case node.SoftLineBreak():
+			s += " "
+		}
+		return s
+
+	case *ast.String:
+		return string(node.Value)
+
+	case *ast.Emphasis:
+		style := lipgloss.NewStyle().Italic(true)
+		if node.Level >= 2 {
+			style = lipgloss.NewStyle().Bold(true)
+		}
+		return style.Render(r.inlines(node))
+
+	case *east.Strikethrough:
+		return lipgloss.NewStyle().Strikethrough(true).Render(r.inlines(node))
+
+	case *ast.CodeSpan:
+		var code strings.Builder
+		for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+			if t, ok := child.(*ast.Text); ok {
+				code.Write(t.Segment.Value(r.source))
+			}
+		}
+		return lipgloss.NewStyle().
+			Background(lipgloss.Color("236")).
+			Foreground(lipgloss.Color("15")).
+			Render(code.String())
+
+	case *ast.Link:
+		label := r.inlines(node)
+		link := lipgloss.NewStyle().Underline(true).Foreground(lipgloss.Color(r.theme.InputLabel)).Render(label)
+		if url := string(node.Destination); url != "" && url != label {
+			link += " " + lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Status)).Render("("+url+")")
+		}
+		return link
+
+	case *ast.AutoLink:
+		return lipgloss.NewStyle().Underline(true).Foreground(lipgloss.Color(r.theme.InputLabel)).Render(string(node.URL(r.source)))
+
+	case *ast.Image:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.Status)).Render(fmt.Sprintf("[image: %s]", r.inlines(node)))
+
+	case *east.TaskCheckBox:
+		if node.IsChecked {
+			return lipgloss.NewStyle().Foreground(lipgloss.Color(r.theme.InputLabel)).Render("☑") + " "
+		}
+		return "☐ "
+
+	case *ast.RawHTML:
+		var raw strings.Builder
+		for i := 0; i < node.Segments.Len(); i++ {
+			segment := node.Segments.At(i)
+			raw.Write(segment.Value(r.source))
+		}
+		return raw.String()
+	}
+	return r.inlines(node)
+}
+
+// wrapStyled word-wraps styled text to width without breaking its escape
+// codes.
+func wrapStyled(s string, width int) []string {
+	return strings.Split(wrap.Wrap(s, width), "\n")
+}
@@ -0,0 +1,240 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"lil_guy/internal/ai"
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+const mergeSystemPrompt = `You merge two versions of a conversation that split from the same start.
+Write the single reply that should follow the shared part: keep what both versions agree on, choose
+the better approach where they differ and briefly say why, and include any code in full. Reply with
+the merged answer only.`
+
+// mergeMsg carries a synthesis of two branches written in the background.
+type mergeMsg struct {
+	From             []string // Heads of the merged branches
+	Content          string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Err              error
+}
+
+// tailText formats the messages after a split for the merge prompt.
+func (m model) tailText(messages []chat.ChatMessage) string {
+	if len(messages) == 0 {
+		return "(nothing after the split)"
+	}
+	var parts []string
+	for _, msg := range messages {
+		parts = append(parts, fmt.Sprintf("%s: %s", chat.RoleLabel(msg.Role, m.buddyName), msg.Content))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// requestMerge asks the current model to combine the current branch with the
+// conversation at the selected graph row.
+func (m *model) requestMerge() tea.Cmd {
+	if m.selectedGraphRow >= len(m.graphRows) {
+		return nil
+	}
+	if m.isThinking || m.isTyping {
+		m.statusMessage = "Wait for the reply to finish"
+		return clearStatusAfterDelay()
+	}
+	if m.client == nil {
+		m.statusMessage = "Merging needs an API key"
+		return clearStatusAfterDelay()
+	}
+
+	tree := m.conversationTree
+	row := m.graphRows[m.selectedGraphRow]
+	ref, label := m.graphRowRef(row)
+	diff, err := tree.Diff(tree.Head(), ref)
+	switch {
+	case err != nil:
+		m.statusMessage = fmt.Sprintf("Failed to compare: %v", err)
+		return clearStatusAfterDelay()
+	case len(diff.Right) == 0:
+		m.statusMessage = "The current branch already has everything in " + label
+		return clearStatusAfterDelay()
+	case len(diff.Left) == 0:
+		m.statusMessage = "Nothing to merge: the current branch is where " + label + " started - switch to it or cherry-pick (C)"
+		return clearStatusAfterDelay()
+	}
+
+	// The shared part is the history; the two versions go in one request
+	var messages []ai.UnifiedMessage
+	for _, msg := range diff.Common {
+		messages = append(messages, ai.UnifiedMessage{Role: msg.Role, Content: msg.Content})
+	}
+	request := fmt.Sprintf("The conversation split here into two versions.\n\n## Version A (%s)\n\n%s\n\n## Version B (%s)\n\n%s\n\nWrite the merged reply.",
+		tree.GetCurrentBranch().Name, m.tailText(diff.Left), label, m.tailText(diff.Right))
+	messages = append(messages, ai.UnifiedMessage{Role: "user", Content: request})
+
+	from := []string{tree.Head(), row.nodeID}
+	client, modelName, options := m.client, m.currentModel, m.generation
+	m.appState = stateChatting
+	m.isThinking = true
+	m.loadingMessage = "Merging branches..."
+	m.statusMessage = fmt.Sprintf("Merging %s into %s", label, tree.GetCurrentBranch().Name)
+
+	return tea.Batch(m.spinner.Tick, func() tea.Msg {
+		response, err := client.SendMessageWithOptions(modelName, messages, mergeSystemPrompt, options)
+		if err != nil {
+			return mergeMsg{Err: err}
+		}
+		return mergeMsg{
+			From:             from,
+			Content:          strings.TrimSpace(response.Content),
+			Model:            modelName,
+			PromptTokens:     response.PromptTokens,
+			CompletionTokens: response.CompletionTokens,
+		}
+	})
+}
+
+// applyMerge appends a finished synthesis to the current branch.
+func (m *model) applyMerge(msg mergeMsg) {
+	m.isThinking = false
+	if msg.Err != nil {
+		m.statusMessage = fmt.Sprintf("Merge failed: %v", msg.Err)
+		return
+	}
+	m.updateTokenUsage(msg.Model, msg.PromptTokens, msg.CompletionTokens)
+
+	tree := m.conversationTree
+	node, err := tree.AddMerge(chat.ChatMessage{
+		Role:      "assistant",
+		Content:   msg.Content,
+		Timestamp: time.Now(),
+		Model:     msg.Model,
+	}, msg.From)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Merge failed: %v", err)
+		return
+	}
+
+	m.showConversation(tree.Messages(node.ID))
+	m.saveMergedTree("Merged branches into " + tree.GetCurrentBranch().Name)
+}
+
+// saveMergedTree saves the tree after a cherry-pick or merge.
+func (m *model) saveMergedTree(status string) {
+	m.describeConversationTree()
+	if err := chat.SaveTree(m.conversationTree, m.treeFilename); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save tree: %v", err)
+		return
+	}
+	m.statusMessage = status
+}
+
+// openCherryPick lists the messages at the selected graph row that the
+// current branch doesn't have, to pick from.
+func (m *model) openCherryPick() tea.Cmd {
+	if m.selectedGraphRow >= len(m.graphRows) {
+		return nil
+	}
+	if m.isThinking || m.isTyping {
+		m.statusMessage = "Wait for the reply to finish"
+		return clearStatusAfterDelay()
+	}
+
+	tree := m.conversationTree
+	row := m.graphRows[m.selectedGraphRow]
+	_, label := m.graphRowRef(row)
+	diff := chat.DiffConversations(tree.Messages(tree.Head()), tree.Messages(row.nodeID))
+	if len(diff.Right) == 0 {
+		m.statusMessage = "The current branch already has everything in " + label
+		return clearStatusAfterDelay()
+	}
+
+	m.pickNodes = tree.Path(row.nodeID)[len(diff.Common):]
+	m.pickSelected = map[int]bool{}
+	m.pickCursor = 0
+	m.pickSource = label
+	m.appState = stateCherryPick
+	return nil
+}
+
+// updateCherryPick handles choosing messages to copy to the current branch.
+func (m *model) updateCherryPick(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.appState = stateBranchManager
+	case "up", "k":
+		if m.pickCursor > 0 {
+			m.pickCursor--
+		}
+	case "down", "j":
+		if m.pickCursor < len(m.pickNodes)-1 {
+			m.pickCursor++
+		}
+	case " ", "x":
+		m.pickSelected[m.pickCursor] = !m.pickSelected[m.pickCursor]
+	case "a":
+		// Select all, or none if all are selected
+		all := len(m.pickSelected) == len(m.pickNodes)
+		for _, selected := range m.pickSelected {
+			all = all && selected
+		}
+		for i := range m.pickNodes {
+			m.pickSelected[i] = !all
+		}
+	case "enter":
+		var picked []string
+		for i, id := range m.pickNodes {
+			if m.pickSelected[i] {
+				picked = append(picked, id)
+			}
+		}
+		if len(picked) == 0 {
+			picked = []string{m.pickNodes[m.pickCursor]}
+		}
+
+		head, err := m.conversationTree.CherryPick(picked)
+		if err != nil {
+			m.statusMessage = fmt.Sprintf("Cherry-pick failed: %v", err)
+			return clearStatusAfterDelay()
+		}
+		m.showConversation(m.conversationTree.Messages(head))
+		m.appState = stateChatting
+		m.saveMergedTree(fmt.Sprintf("Copied %d messages from %s", len(picked), m.pickSource))
+		return clearStatusAfterDelay()
+	}
+	return nil
+}
+
+// renderCherryPick draws the messages that can be picked.
+func (m model) renderCherryPick() string {
+	s := lipgloss.NewStyle().Bold(true).Render("🍒 Cherry-pick from "+m.pickSource) + "\n\n"
+	s += fmt.Sprintf("Selected messages are added after the latest message on %s.\n\n", m.conversationTree.GetCurrentBranch().Name)
+
+	for i, id := range m.pickNodes {
+		msg := m.conversationTree.Nodes[id].Message
+		check := "[ ]"
+		if m.pickSelected[i] {
+			check = "[x]"
+		}
+		preview := strings.Join(strings.Fields(msg.Content), " ")
+		line := fmt.Sprintf("  %s %s: %s", check, chat.RoleLabel(msg.Role, m.buddyName), wrap.Truncate(preview, 70, "…"))
+
+		style := lipgloss.NewStyle()
+		if i == m.pickCursor {
+			style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
+		}
+		s += style.Render(line) + "\n"
+	}
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	s += "\n" + helpStyle.Render("↑↓: Navigate | Space: Select | A: All | Enter: Copy selected (or the highlighted one) | Esc: Back") + "\n"
+	return s
+}
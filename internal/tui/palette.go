@@ -0,0 +1,209 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sahilm/fuzzy"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+// paletteRows is how many matches the command palette shows at once.
+const paletteRows = 12
+
+// paletteItem is an entry in the command palette.
+type paletteItem struct {
+	Group   string // "Action", "Model", "Template", ...
+	Name    string
+	Binding string // Key or command shown next to the entry
+	Run     func(m *model) tea.Cmd
+}
+
+// title is what the palette matches the query against.
+func (p paletteItem) title() string {
+	if p.Group == "Action" {
+		return p.Name
+	}
+	return p.Group + ": " + p.Name
+}
+
+// paletteItems lists everything the palette can run: the chat actions, then
+// models, templates, personalities, themes and saved chats.
+func (m model) paletteItems() []paletteItem {
+	var items []paletteItem
+	for _, a := range chatActions() {
+		items = append(items, paletteItem{Group: "Action", Name: a.Name, Binding: a.binding(), Run: a.Run})
+	}
+
+	for _, name := range getAvailableModels(m.client) {
+		items = append(items, paletteItem{Group: "Model", Name: name, Run: func(m *model) tea.Cmd {
+			m.setModel(name)
+			return clearStatusAfterDelay()
+		}})
+	}
+	for _, template := range builtinTemplates {
+		items = append(items, paletteItem{Group: "Template", Name: template.Name, Binding: keyLabel("ctrl+p"), Run: func(m *model) tea.Cmd {
+			m.applyTemplate(template)
+			return clearStatusAfterDelay()
+		}})
+	}
+	for i := range personalities {
+		personality := &personalities[i]
+		items = append(items, paletteItem{Group: "Personality", Name: personality.Emoji + " " + personality.Name, Binding: keyLabel("ctrl+o"), Run: func(m *model) tea.Cmd {
+			m.applyPersonality(personality)
+			return clearStatusAfterDelay()
+		}})
+	}
+	for _, name := range themeNames {
+		items = append(items, paletteItem{Group: "Theme", Name: themes[name].Name, Binding: keyLabel("ctrl+d"), Run: func(m *model) tea.Cmd {
+			m.setTheme(name)
+			return clearStatusAfterDelay()
+		}})
+	}
+	for i := range retroThemes {
+		theme := &retroThemes[i]
+		items = append(items, paletteItem{Group: "Retro theme", Name: theme.Name, Binding: keyLabel("ctrl+g"), Run: func(m *model) tea.Cmd {
+			m.applyRetroTheme(theme)
+			return clearStatusAfterDelay()
+		}})
+	}
+
+	// Saved chats, most recent first as the store lists them
+	if chats, err := chat.ListChatSummaries(); err == nil {
+		for _, summary := range chats {
+			if summary.Archived {
+				continue
+			}
+			items = append(items, paletteItem{Group: "Chat", Name: summary.Title, Binding: keyLabel("ctrl+b"), Run: func(m *model) tea.Cmd {
+				if err := m.loadChatHistory(summary.Filename); err != nil {
+					m.statusMessage = fmt.Sprintf("Failed to load chat: %v", err)
+				}
+				return clearStatusAfterDelay()
+			}})
+		}
+	}
+	return items
+}
+
+// openPalette shows the command palette.
+func (m *model) openPalette() tea.Cmd {
+	m.palette = m.paletteItems()
+	m.appState = statePalette
+	m.textInput.Reset()
+	m.textInput.Placeholder = "Type a command, model, template, theme or chat..."
+	m.textInput.Focus()
+	m.statusMessage = ""
+	m.filterPalette()
+	return nil
+}
+
+// filterPalette fuzzy-matches the query against the palette, best first.
+func (m *model) filterPalette() {
+	m.selectedPaletteItem = 0
+	query := strings.TrimSpace(m.textInput.Value())
+	if query == "" {
+		m.paletteMatches = make([]int, len(m.palette))
+		for i := range m.palette {
+			m.paletteMatches[i] = i
+		}
+		return
+	}
+
+	titles := make([]string, len(m.palette))
+	for i, item := range m.palette {
+		titles[i] = item.title()
+	}
+	m.paletteMatches = nil
+	for _, match := range fuzzy.Find(query, titles) {
+		m.paletteMatches = append(m.paletteMatches, match.Index)
+	}
+}
+
+// updatePalette handles keys in the command palette.
+func (m *model) updatePalette(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c", "esc":
+		m.closePalette()
+		return nil
+	case "up", "ctrl+k":
+		if m.selectedPaletteItem > 0 {
+			m.selectedPaletteItem--
+		}
+		return nil
+	case "down", "ctrl+j", "tab":
+		if m.selectedPaletteItem < len(m.paletteMatches)-1 {
+			m.selectedPaletteItem++
+		}
+		return nil
+	case "enter":
+		if len(m.paletteMatches) == 0 {
+			return nil
+		}
+		item := m.palette[m.paletteMatches[m.selectedPaletteItem]]
+		m.closePalette()
+		return item.Run(m)
+	}
+
+	var cmd tea.Cmd
+	before := m.textInput.Value()
+	m.textInput, cmd = m.textInput.Update(msg)
+	if m.textInput.Value() != before {
+		m.filterPalette()
+	}
+	return cmd
+}
+
+// closePalette goes back to the chat.
+func (m *model) closePalette() {
+	m.appState = stateChatting
+	m.palette = nil
+	m.paletteMatches = nil
+	m.textInput.Reset()
+}
+
+// renderPalette draws the command palette.
+func (m model) renderPalette() string {
+	s := lipgloss.NewStyle().Bold(true).Render("🔎 Command Palette") + "\n\n"
+	s += m.textInput.View() + "\n\n"
+
+	width := max(m.viewport.Width, 40) - 4
+	groupStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.Status))
+	bindingStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+	// Scroll so the selection stays on screen
+	first := max(m.selectedPaletteItem-paletteRows+1, 0)
+	for i := first; i < len(m.paletteMatches) && i < first+paletteRows; i++ {
+		item := m.palette[m.paletteMatches[i]]
+		group := ""
+		if item.Group != "Action" {
+			group = item.Group + ": "
+		}
+		name := wrap.Truncate(item.Name, width-wrap.Width(group)-wrap.Width(item.Binding)-2, "…")
+		gap := strings.Repeat(" ", max(width-wrap.Width(group)-wrap.Width(name)-wrap.Width(item.Binding), 1))
+
+		if i == m.selectedPaletteItem {
+			s += lipgloss.NewStyle().Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight)).
+				Render("  "+group+name+gap+item.Binding) + "\n"
+		} else {
+			s += "  " + groupStyle.Render(group) + name + gap + bindingStyle.Render(item.Binding) + "\n"
+		}
+	}
+	if len(m.paletteMatches) == 0 {
+		s += "  No matches\n"
+	} else if len(m.paletteMatches) > paletteRows {
+		s += bindingStyle.Render(fmt.Sprintf("  %d of %d", m.selectedPaletteItem+1, len(m.paletteMatches))) + "\n"
+	}
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	s += "\n" + helpStyle.Render("Type to filter | ↑/↓: Select | Enter: Run | Esc: Back") + "\n"
+
+	if m.statusMessage != "" {
+		statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+		s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
+	}
+	return s
+}
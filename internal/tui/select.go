@@ -0,0 +1,334 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+// openSelectMessage enters message-select mode on the latest message.
+func (m *model) openSelectMessage() tea.Cmd {
+	m.selectedMessage = -1
+	for i := len(m.chatMessages) - 1; i >= 0; i-- {
+		if m.chatMessages[i].Role != "system" {
+			m.selectedMessage = i
+			break
+		}
+	}
+	if m.selectedMessage < 0 {
+		m.statusMessage = "No messages to select"
+		return clearStatusAfterDelay()
+	}
+
+	m.appState = stateSelectMessage
+	m.confirmDeleteMessage = false
+	m.viewingRaw = false
+	m.statusMessage = ""
+	m.showSelection()
+	return nil
+}
+
+// closeSelectMessage goes back to the chat.
+func (m *model) closeSelectMessage() {
+	m.appState = stateChatting
+	m.viewingRaw = false
+	m.updateViewportContent()
+}
+
+// highlightedMessage returns the index in chatMessages of the message drawn
+// with a bar in the margin, or -1 for none.
+func (m model) highlightedMessage() int {
+	if m.appState == stateSelectMessage {
+		return m.selectedMessage
+	}
+	return m.focusedMessage
+}
+
+// showSelection redraws the chat and scrolls the selected message into view.
+func (m *model) showSelection() {
+	content, offsets := m.renderChat()
+	m.viewport.SetContent(content)
+	if m.selectedMessage < 0 || m.selectedMessage >= len(offsets) {
+		return
+	}
+	offset := offsets[m.selectedMessage]
+	if offset >= 0 && (offset < m.viewport.YOffset || offset >= m.viewport.YOffset+m.viewport.Height) {
+		m.viewport.SetYOffset(offset)
+	}
+}
+
+// moveSelection selects the next (or previous) message, skipping notices.
+func (m *model) moveSelection(delta int) {
+	for i := m.selectedMessage + delta; i >= 0 && i < len(m.chatMessages); i += delta {
+		if m.chatMessages[i].Role != "system" {
+			m.selectedMessage = i
+			break
+		}
+	}
+	m.showSelection()
+}
+
+// updateSelectMessage handles keys in message-select mode.
+func (m *model) updateSelectMessage(msg tea.KeyMsg) tea.Cmd {
+	if m.confirmDeleteMessage {
+		m.confirmDeleteMessage = false
+		if msg.String() != "y" && msg.String() != "Y" {
+			m.statusMessage = "Cancelled"
+			return clearStatusAfterDelay()
+		}
+		return m.deleteMessage(m.selectedMessage)
+	}
+	if m.viewingRaw {
+		return m.updateRawMessage(msg)
+	}
+	if m.selectedMessage < 0 || m.selectedMessage >= len(m.chatMessages) {
+		m.closeSelectMessage()
+		return nil
+	}
+
+	selected := m.chatMessages[m.selectedMessage]
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.closeSelectMessage()
+	case "up", "k":
+		m.moveSelection(-1)
+	case "down", "j":
+		m.moveSelection(1)
+	case "home", "g":
+		m.selectedMessage = -1
+		m.moveSelection(1)
+	case "end", "G":
+		m.selectedMessage = len(m.chatMessages)
+		m.moveSelection(-1)
+	case "left", "h", "right", "l":
+		// Flip between alternatives of the selected message
+		delta := 1
+		if msg.String() == "left" || msg.String() == "h" {
+			delta = -1
+		}
+		index, err := m.switchAlternative(m.selectedMessage, delta)
+		if err != nil {
+			m.statusMessage = err.Error()
+		}
+		m.selectedMessage = index
+		m.showSelection()
+	case "enter", "e":
+		// Edit the selected message in the composer
+		if m.isThinking || m.isTyping {
+			m.statusMessage = "Wait for the reply to finish"
+			return clearStatusAfterDelay()
+		}
+		if selected.Role != "user" {
+			m.statusMessage = "Can only edit your own messages"
+			return clearStatusAfterDelay()
+		}
+		m.setComposer(selected.Content)
+		m.editingMessageIndex = m.selectedMessage
+		m.closeSelectMessage()
+		m.statusMessage = "Edit message and press Enter to regenerate from this point"
+	case "y", "c":
+		if err := m.copyToClipboard(selected.Content); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to copy: %v", err)
+		} else {
+			m.statusMessage = "Message copied to clipboard"
+		}
+		return clearStatusAfterDelay()
+	case "d":
+		if m.isThinking || m.isTyping {
+			m.statusMessage = "Wait for the reply to finish"
+			return clearStatusAfterDelay()
+		}
+		m.confirmDeleteMessage = true
+	case "r":
+		return m.regenerateFrom(m.selectedMessage)
+	case "b":
+		return m.branchFromMessage(m.selectedMessage)
+	case "p":
+		return m.togglePin(selected.Content)
+	case ">":
+		// Quote the message into the composer
+		quote := "> " + strings.ReplaceAll(strings.TrimRight(selected.Content, "\n"), "\n", "\n> ") + "\n\n"
+		if draft := m.composer.Value(); draft != "" {
+			quote = strings.TrimRight(draft, "\n") + "\n\n" + quote
+		}
+		m.setComposer(quote)
+		m.composer.CursorEnd()
+		m.closeSelectMessage()
+		m.statusMessage = "Quoted into your message"
+		return clearStatusAfterDelay()
+	case "v":
+		// Show the message as written, without markdown rendering
+		m.viewingRaw = true
+		m.viewport.SetContent(wrap.Wrap(selected.Content, max(m.viewport.Width-2, 20)))
+		m.viewport.GotoTop()
+	}
+	return nil
+}
+
+// updateRawMessage handles keys while a message is shown as raw text.
+func (m *model) updateRawMessage(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c", "q", "esc", "v":
+		m.viewingRaw = false
+		m.showSelection()
+		return nil
+	case "y", "c":
+		if err := m.copyToClipboard(m.chatMessages[m.selectedMessage].Content); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to copy: %v", err)
+		} else {
+			m.statusMessage = "Message copied to clipboard"
+		}
+		return clearStatusAfterDelay()
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return cmd
+}
+
+// deleteMessage removes a message from the conversation. The conversation as
+// it was stays in the conversation tree.
+func (m *model) deleteMessage(index int) tea.Cmd {
+	m.recordConversation()
+
+	m.chatMessages = slices.Delete(m.chatMessages, index, index+1)
+	m.showConversation(m.treeMessages())
+	m.recordConversation()
+	m.titleSeq++
+
+	if len(m.chatMessages) == 0 {
+		m.closeSelectMessage()
+	} else {
+		m.selectedMessage = min(index, len(m.chatMessages)-1)
+		m.showSelection()
+	}
+	m.statusMessage = "Message deleted"
+	return clearStatusAfterDelay()
+}
+
+// regenerateFrom asks for a new reply to the selected message, or to the
+// question the selected reply answers, dropping what came after it. The old
+// replies stay in the tree as alternatives.
+func (m *model) regenerateFrom(index int) tea.Cmd {
+	if m.isThinking || m.isTyping {
+		m.statusMessage = "Wait for the reply to finish"
+		return clearStatusAfterDelay()
+	}
+
+	question := index
+	for question >= 0 && m.chatMessages[question].Role != "user" {
+		question--
+	}
+	if question < 0 {
+		m.statusMessage = "No message of yours to reply to before this one"
+		return clearStatusAfterDelay()
+	}
+
+	m.recordConversation()
+	m.autoCheckpoint("Automatic checkpoint before regenerating")
+	prompt := m.chatMessages[question].Content
+	m.showConversation(m.chatMessages[:question+1])
+	m.closeSelectMessage()
+
+	m.isThinking = true
+	if m.currentPersonality != nil {
+		m.loadingMessage = GetPersonalityThinkingMessage(m.currentPersonality)
+	} else {
+		m.loadingMessage = loadingMessages[time.Now().UnixNano()%int64(len(loadingMessages))]
+	}
+	m.statusMessage = "Regenerating from the selected message..."
+	return tea.Batch(sendToAI(*m, prompt), m.spinner.Tick, clearStatusAfterDelay())
+}
+
+// branchFromMessage starts a new branch at the selected message and switches
+// to it. The current branch keeps the rest of the conversation.
+func (m *model) branchFromMessage(index int) tea.Cmd {
+	if m.isThinking || m.isTyping {
+		m.statusMessage = "Wait for the reply to finish"
+		return clearStatusAfterDelay()
+	}
+
+	m.recordConversation()
+	nodeID := m.messageNodes()[index]
+	if nodeID == "" {
+		m.statusMessage = "This message isn't in the conversation tree yet"
+		return clearStatusAfterDelay()
+	}
+
+	tree := m.conversationTree
+	branch, err := tree.BranchAt(nodeID, fmt.Sprintf("branch_%d", time.Now().Unix()), "Branched from: "+chat.GenerateCheckpointName(tree.Messages(nodeID)))
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to create branch: %v", err)
+		return clearStatusAfterDelay()
+	}
+	tree.SwitchBranch(branch.ID)
+	m.describeConversationTree()
+	m.showConversation(tree.Messages(nodeID))
+	m.closeSelectMessage()
+
+	if err := chat.SaveTree(tree, m.treeFilename); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save tree: %v", err)
+	} else {
+		m.statusMessage = fmt.Sprintf("Created %s from this message - Alt+H to switch back", branch.Name)
+	}
+	return clearStatusAfterDelay()
+}
+
+// togglePin pins a message into the context, or unpins it. Pinned messages
+// are sent with the system prompt, so they stay in the context after the
+// conversation is cleared, edited or branched.
+func (m *model) togglePin(content string) tea.Cmd {
+	if i := slices.Index(m.pinnedMessages, content); i >= 0 {
+		m.pinnedMessages = slices.Delete(m.pinnedMessages, i, i+1)
+		m.statusMessage = "Unpinned message"
+	} else {
+		m.pinnedMessages = append(m.pinnedMessages, content)
+		m.statusMessage = "Pinned message - it stays in the context until you unpin it"
+	}
+	m.showSelection()
+	return clearStatusAfterDelay()
+}
+
+// pinnedContext returns the system prompt with the pinned messages added.
+func (m model) pinnedContext(systemPrompt string) string {
+	if len(m.pinnedMessages) == 0 {
+		return systemPrompt
+	}
+	return systemPrompt + "\n\nThe user pinned these messages for you to keep in mind:\n\n" + strings.Join(m.pinnedMessages, "\n\n---\n\n")
+}
+
+// renderSelectMessage draws the chat with the selected message highlighted
+// and the actions for it.
+func (m model) renderSelectMessage() string {
+	if !m.viewingRaw {
+		// Pick up a reply that's still coming in
+		m.viewport.SetContent(m.buildChatContent())
+	}
+	s := m.viewport.View() + "\n\n"
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	switch {
+	case m.confirmDeleteMessage:
+		s += "Delete this message? The conversation before the delete stays in the branch manager. (y/n)\n"
+	case m.viewingRaw:
+		s += lipgloss.NewStyle().Bold(true).Render("📄 Raw message") + "\n"
+		s += helpStyle.Render("↑/↓/PgUp/PgDn: Scroll | Y: Copy | Esc/V: Back") + "\n"
+	default:
+		s += lipgloss.NewStyle().Bold(true).Render("👆 Select Message") + "\n"
+		s += helpStyle.Render("↑/↓: Select | ←/→: Alternatives | Enter/E: Edit | Y: Copy | D: Delete | R: Regenerate from here") + "\n"
+		s += helpStyle.Render("B: Branch from here | P: Pin/unpin in context | >: Quote | V: View raw | Esc: Back") + "\n"
+	}
+
+	if m.statusMessage != "" {
+		statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+		s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package tui
+
+import (
+	"fmt"
+
+	"lil_guy/internal/ai"
+	"lil_guy/internal/chat"
+)
+
+// Options controls how the TUI starts.
+type Options struct {
+	Resume   bool   // Start in a saved conversation instead of a fresh one
+	ResumeID string // Chat ID/filename, tree ID/filename or checkpoint ID; empty means the latest chat
+}
+
+// generationSettings captures the current session's generation settings for saving.
+func (m *model) generationSettings() *chat.GenerationSettings {
+	settings := &chat.GenerationSettings{
+		Temperature: m.generation.Temperature,
+		MaxTokens:   m.generation.MaxTokens,
+	}
+	if len(m.messages) > 0 && m.messages[0].Role == "system" {
+		settings.SystemPrompt = m.messages[0].Content
+	}
+	if m.currentPersonality != nil {
+		settings.Personality = m.currentPersonality.ID
+	}
+	return settings
+}
+
+// restoreSession replaces the current conversation with saved messages and the
+// buddy name, model and generation settings stored alongside them. It returns a
+// note for the status line when the saved model can't be used.
+func (m *model) restoreSession(buddyName, modelName string, settings *chat.GenerationSettings, messages []chat.ChatMessage) string {
+	note := ""
+	if buddyName != "" {
+		m.buddyName = buddyName
+	}
+
+	if modelName != "" {
+		if m.client == nil || m.client.IsModelSupported(modelName) {
+			m.currentModel = modelName
+		} else {
+			note = fmt.Sprintf(" (%s unavailable, using %s)", modelName, m.currentModel)
+		}
+	}
+
+	systemPrompt := ""
+	if settings != nil {
+		if settings.Personality != "" {
+			m.currentPersonality = GetPersonality(settings.Personality)
+		}
+		m.generation = ai.GenerationOptions{
+			Temperature: settings.Temperature,
+			MaxTokens:   settings.MaxTokens,
+		}
+		systemPrompt = settings.SystemPrompt
+	}
+	if systemPrompt == "" {
+		systemPrompt = createSystemMessage(m.preferences, m.buddyName, m.currentPersonality)
+	}
+
+	// Convert to unified messages format
+	m.messages = []ai.UnifiedMessage{
+		{Role: "system", Content: systemPrompt},
+	}
+	m.chatMessages = []chat.ChatMessage{}
+	m.chatID = ""
+	m.resetTitle()
+	m.lastUserMessage = ""
+
+	for _, msg := range messages {
+		if msg.Role != "system" {
+			m.messages = append(m.messages, ai.UnifiedMessage{
+				Role:    msg.Role,
+				Content: msg.Content,
+			})
+			if msg.Role == "user" {
+				m.lastUserMessage = msg.Content
+			}
+		}
+		m.chatMessages = append(m.chatMessages, msg)
+	}
+
+	m.clearFocus()
+	m.updateViewportContent()
+	return note
+}
+
+// resume loads the conversation requested on the command line.
+func (m *model) resume(opts Options) error {
+	if opts.ResumeID == "" {
+		filename, err := chat.LatestChat()
+		if err != nil {
+			return err
+		}
+		return m.resumeChat(filename)
+	}
+
+	if filename, err := chat.ResolveChat(opts.ResumeID); err == nil {
+		return m.resumeChat(filename)
+	}
+
+	if filename, err := chat.ResolveTree(opts.ResumeID); err == nil {
+		tree, err := chat.LoadTree(filename)
+		if err != nil {
+			return err
+		}
+		return m.resumeTree(filename, tree, "")
+	}
+
+	filename, tree, err := chat.FindCheckpoint(opts.ResumeID)
+	if err != nil {
+		return fmt.Errorf("no chat, tree or checkpoint matches %q", opts.ResumeID)
+	}
+	return m.resumeTree(filename, tree, opts.ResumeID)
+}
+
+// resumeChat loads a saved chat and leaves onboarding behind.
+func (m *model) resumeChat(filename string) error {
+	if err := m.loadChatHistory(filename); err != nil {
+		return err
+	}
+	m.appState = stateChatting
+	return nil
+}
+
+// resumeTree loads a conversation tree at a checkpoint, or at the head of its
+// current branch when no checkpoint is given.
+func (m *model) resumeTree(filename string, tree *chat.ConversationTree, checkpointID string) error {
+	var messages []chat.ChatMessage
+	if checkpointID == "" {
+		if tree.Head() == "" {
+			return fmt.Errorf("tree %s has no messages on its current branch", filename)
+		}
+		messages = tree.Messages(tree.Head())
+	} else {
+		if branch := tree.BranchForCheckpoint(checkpointID); branch != nil {
+			tree.SwitchBranch(branch.ID)
+		}
+		var err error
+		if messages, err = tree.LoadFromCheckpoint(checkpointID); err != nil {
+			return err
+		}
+	}
+
+	m.conversationTree = tree
+	m.treeFilename = filename
+	note := m.restoreSession(tree.BuddyName, tree.Model, tree.Settings, messages)
+	m.appState = stateChatting
+	m.statusMessage = fmt.Sprintf("Resumed %s on branch %s%s", filename, tree.GetCurrentBranch().Name, note)
+	return nil
+}
@@ -0,0 +1,184 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"lil_guy/internal/ai"
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+const (
+	titleSystemPrompt = "You name conversations. Reply with a short, specific title of at most six words and nothing else."
+	titleRequest      = "Write a title for the conversation above."
+	titleExcerptChars = 1000 // Per message; the opening is enough to name a chat
+	maxTitleWidth     = 60
+)
+
+// titleMsg carries a conversation title generated in the background.
+type titleMsg struct {
+	Seq              int // Matches titleSeq when the conversation hasn't changed since the request
+	Title            string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// resetTitle forgets the title and cost of the current conversation and
+// ignores any title still being generated for it.
+func (m *model) resetTitle() {
+	m.chatTitle = ""
+	m.chatCost = 0
+	m.titlePending = false
+	m.titleSeq++
+}
+
+// needsTitle reports whether the conversation has had its first exchange but
+// has no title yet.
+func (m *model) needsTitle() bool {
+	if m.chatTitle != "" || m.titlePending {
+		return false
+	}
+	hasUser, hasAssistant := false, false
+	for _, msg := range m.chatMessages {
+		hasUser = hasUser || msg.Role == "user"
+		hasAssistant = hasAssistant || (msg.Role == "assistant" && msg.Content != "")
+	}
+	return hasUser && hasAssistant
+}
+
+// requestTitle names the conversation with the cheapest available model,
+// falling back to a title built from the first message.
+func (m *model) requestTitle() tea.Cmd {
+	m.titlePending = true
+	seq := m.titleSeq
+	fallback := chat.GenerateTitle(m.chatMessages)
+
+	titleModel := ""
+	if m.client != nil {
+		titleModel = m.client.CheapestModel()
+	}
+	if titleModel == "" {
+		return func() tea.Msg {
+			return titleMsg{Seq: seq, Title: fallback}
+		}
+	}
+
+	// Send the first exchange only, trimmed, then ask for the title
+	var excerpt []ai.UnifiedMessage
+	for _, msg := range m.chatMessages {
+		if msg.Role == "system" {
+			continue
+		}
+		content := msg.Content
+		if runes := []rune(content); len(runes) > titleExcerptChars {
+			content = string(runes[:titleExcerptChars])
+		}
+		excerpt = append(excerpt, ai.UnifiedMessage{Role: msg.Role, Content: content})
+		if len(excerpt) == 2 {
+			break
+		}
+	}
+	excerpt = append(excerpt, ai.UnifiedMessage{Role: "user", Content: titleRequest})
+
+	client := m.client
+	return func() tea.Msg {
+		temperature := 0.3
+		options := ai.GenerationOptions{Temperature: &temperature, MaxTokens: 20}
+		response, err := client.SendMessageWithOptions(titleModel, excerpt, titleSystemPrompt, options)
+		if err != nil {
+			return titleMsg{Seq: seq, Title: fallback}
+		}
+
+		title := cleanTitle(response.Content)
+		if title == "" {
+			title = fallback
+		}
+		return titleMsg{
+			Seq:              seq,
+			Title:            title,
+			Model:            titleModel,
+			PromptTokens:     response.PromptTokens,
+			CompletionTokens: response.CompletionTokens,
+		}
+	}
+}
+
+// cleanTitle strips the quotes, labels and punctuation models like to wrap titles in.
+func cleanTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		title := strings.Trim(line, " \t\"'`*#")
+		if len(title) >= 6 && strings.EqualFold(title[:6], "title:") {
+			title = strings.Trim(title[6:], " \t\"'`*#")
+		}
+		title = strings.TrimSuffix(title, ".")
+		if title == "" {
+			continue
+		}
+		if wrap.Width(title) > maxTitleWidth {
+			title = strings.TrimSpace(wrap.Truncate(title, maxTitleWidth-3, "")) + "..."
+		}
+		return title
+	}
+	return ""
+}
+
+// applyTitle stores a generated title, counts what it cost and saves it if the
+// chat has been saved before.
+func (m *model) applyTitle(msg titleMsg) {
+	if msg.Seq != m.titleSeq {
+		// The conversation changed while the title was generated; the session
+		// still paid for it, the new conversation didn't
+		if msg.Model != "" {
+			chatCost := m.chatCost
+			m.updateTokenUsage(msg.Model, msg.PromptTokens, msg.CompletionTokens)
+			m.chatCost = chatCost
+		}
+		return
+	}
+	if msg.Model != "" {
+		m.updateTokenUsage(msg.Model, msg.PromptTokens, msg.CompletionTokens)
+	}
+
+	m.titlePending = false
+	if m.chatTitle != "" {
+		return // Named by the user in the meantime
+	}
+	m.chatTitle = msg.Title
+
+	if m.chatID != "" {
+		if err := m.saveCurrentChat(); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to save title: %v", err)
+		}
+	}
+}
+
+// setTitle renames the conversation, or generates a new title when title is empty.
+func (m *model) setTitle(title string) tea.Cmd {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		m.chatTitle = ""
+		m.titlePending = false
+		m.titleSeq++
+		if !m.needsTitle() {
+			m.statusMessage = "Nothing to title yet"
+			return clearStatusAfterDelay()
+		}
+		m.statusMessage = "Generating a new title..."
+		return tea.Batch(m.requestTitle(), clearStatusAfterDelay())
+	}
+
+	m.chatTitle = title
+	m.titlePending = false
+	m.titleSeq++
+	m.statusMessage = fmt.Sprintf("Title: %s", title)
+	if m.chatID != "" {
+		if err := m.saveCurrentChat(); err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to save title: %v", err)
+		}
+	}
+	return clearStatusAfterDelay()
+}
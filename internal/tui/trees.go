@@ -0,0 +1,132 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+// openTreeBrowser lists the saved conversation trees.
+func (m *model) openTreeBrowser() tea.Cmd {
+	trees, err := chat.ListTreeSummaries()
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to list trees: %v", err)
+		return clearStatusAfterDelay()
+	}
+
+	m.savedTrees = trees
+	m.selectedTree = 0
+	m.treeBrowserFrom = m.appState
+	m.appState = stateTreeBrowser
+	m.statusMessage = ""
+	return nil
+}
+
+// updateTreeBrowser handles keys in the tree browser.
+func (m *model) updateTreeBrowser(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		m.appState = m.treeBrowserFrom
+		m.refreshBranchList()
+	case "up", "k":
+		if m.selectedTree > 0 {
+			m.selectedTree--
+		}
+	case "down", "j":
+		if m.selectedTree < len(m.savedTrees)-1 {
+			m.selectedTree++
+		}
+	case "enter":
+		if m.selectedTree >= len(m.savedTrees) {
+			return nil
+		}
+		if m.isThinking || m.isTyping {
+			m.statusMessage = "Wait for the reply to finish"
+			return clearStatusAfterDelay()
+		}
+
+		filename := m.savedTrees[m.selectedTree].Filename
+		tree, err := chat.LoadTree(filename)
+		if err == nil {
+			err = m.resumeTree(filename, tree, "")
+		}
+		if err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to load tree: %v", err)
+		}
+		m.updateViewportContent()
+		return clearStatusAfterDelay()
+	}
+	return nil
+}
+
+// renderTreeBrowser draws the list of saved trees.
+func (m model) renderTreeBrowser() string {
+	s := lipgloss.NewStyle().Bold(true).Render("🌳 Saved Conversation Trees") + "\n\n"
+
+	if len(m.savedTrees) == 0 {
+		s += "No saved trees. Checkpoints (Ctrl+K) save the conversation tree.\n"
+	} else {
+		const (
+			branchesWidth = 8
+			messagesWidth = 8
+			updatedWidth  = 16
+			gaps          = 3 * 2
+		)
+		width := m.viewport.Width
+		if width <= 0 {
+			width = 100
+		}
+		nameWidth := max(width-2-branchesWidth-messagesWidth-updatedWidth-gaps, 12)
+		row := func(name, branches, messages, updated string) string {
+			return fmt.Sprintf("  %s  %*s  %*s  %-*s",
+				wrap.Pad(wrap.Truncate(name, nameWidth, "…"), nameWidth),
+				branchesWidth, branches,
+				messagesWidth, messages,
+				updatedWidth, updated)
+		}
+
+		headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.currentTheme.Status))
+		var b strings.Builder
+		b.WriteString(headerStyle.Render(row("Name", "Branches", "Msgs", "Updated")) + "\n")
+
+		// Show a window of rows around the selection
+		visible := max(m.viewport.Height-2, 5)
+		start := 0
+		if m.selectedTree >= visible {
+			start = m.selectedTree - visible + 1
+		}
+		end := min(start+visible, len(m.savedTrees))
+
+		for i := start; i < end; i++ {
+			summary := m.savedTrees[i]
+			style := lipgloss.NewStyle()
+			if i == m.selectedTree {
+				style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
+			}
+			b.WriteString(style.Render(row(
+				summary.Name,
+				fmt.Sprintf("%d", summary.Branches),
+				fmt.Sprintf("%d", summary.Messages),
+				summary.UpdatedAt.Format("2006-01-02 15:04"),
+			)) + "\n")
+		}
+		if len(m.savedTrees) > visible {
+			b.WriteString(fmt.Sprintf("  (%d-%d of %d)\n", start+1, end, len(m.savedTrees)))
+		}
+		s += b.String()
+	}
+
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	s += "\n" + helpStyle.Render("↑↓: Navigate | Enter: Load and continue | Esc: Back") + "\n"
+
+	if m.statusMessage != "" {
+		statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+		s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
+	}
+	return s
+}
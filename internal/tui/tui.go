@@ -3,17 +3,13 @@ package tui
 import (
 	"fmt"
 	"os"
-	"os/exec"
-	"regexp"
-	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/alecthomas/chroma/v2"
-	"github.com/alecthomas/chroma/v2/formatters"
-	"github.com/alecthomas/chroma/v2/lexers"
-	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -22,7 +18,9 @@ import (
 
 	"lil_guy/internal/ai"
 	"lil_guy/internal/chat"
+	"lil_guy/internal/clipboard"
 	"lil_guy/internal/config"
+	"lil_guy/internal/wrap"
 )
 
 // Constants for UI layout and configuration
@@ -30,10 +28,10 @@ const (
 	defaultBuddyName     = "AI"
 	defaultSystemMessage = "You are a helpful AI assistant."
 	defaultModel         = "gpt-4o"
-	textInputCharLimit   = 2000 // Increased for longer messages
+	textInputCharLimit   = 2000 // For names, tags and other one-line prompts
 	textInputWidth       = 80
 	textInputPrompt      = "> "
-	viewportHeightOffset = 6 // Height offset for input and status lines
+	viewportHeightOffset = 7 // Height offset for input and status lines
 	textInputWidthOffset = 4 // Width offset for prompt and padding
 	maxOnboardingSteps   = 2
 )
@@ -50,6 +48,9 @@ type Theme struct {
 	Highlight        string
 }
 
+// themeNames is the order Ctrl+D cycles through the themes.
+var themeNames = []string{"default", "dark", "ocean", "sunset", "forest"}
+
 // Available themes
 var themes = map[string]Theme{
 	"default": {
@@ -154,8 +155,13 @@ const (
 	stateChatBrowser
 	stateTemplateSelector
 	stateSearch
-	stateEditMessage
+	stateSelectMessage
 	stateBranchManager
+	stateBranchDiff
+	stateTreeBrowser
+	stateCherryPick
+	stateCodeBlocks
+	statePalette
 	stateCreateCheckpoint
 	statePersonalitySelector
 	stateRetroThemeSelector
@@ -166,7 +172,8 @@ type model struct {
 	client         *ai.UnifiedClient
 	messages       []ai.UnifiedMessage
 	chatMessages   []chat.ChatMessage // Our enhanced message format
-	textInput      textinput.Model
+	textInput      textinput.Model // One-line prompts such as names and filters
+	composer       textarea.Model  // Multi-line chat message input
 	error          error
 	spinner        spinner.Model
 	isThinking     bool
@@ -178,19 +185,33 @@ type model struct {
 	currentModel   string
 	statusMessage  string
 	tokenUsage     ai.TokenUsage
+	generation     ai.GenerationOptions // Temperature and token limits for requests
 
 	// Chat browser fields
-	savedChats     []string // List of saved chat files
-	selectedChat   int      // Currently selected chat in browser
+	allChats       []chat.ChatSummary // Every chat in the current view, before filtering
+	savedChats     []chat.ChatSummary // Chats shown in the browser, in display order
+	selectedChat   int                // Currently selected chat in browser
+	browserSort    chatSort           // Column the browser is sorted by
+	browserFilter  string             // "#tag" or text fuzzy-matched against titles
+	showArchived   bool               // Include archived chats in the list
+	showTrash      bool               // Browse the trash instead of saved chats
+	browserConfirm browserAction      // Destructive action waiting for y/n
+	browserPrompt  browserPrompt      // What the text input is editing in the browser
 
 	// Template selector fields
 	selectedTemplate int // Currently selected template
 
 	// Search fields
 	searchQuery      string             // Current search query
-	searchResults    []chat.ChatMessage // Found messages
+	lastSearchQuery  string             // Query the current results are for
+	searchResults    []chat.SearchResult // Found messages
 	selectedResult   int                // Currently selected search result
 
+	// Search match navigation in the chat view
+	focusedMessage  int   // Index into chatMessages of the highlighted match, -1 for none
+	focusMatches    []int // Message indexes of the matches in the loaded chat
+	focusMatchIndex int   // Position in focusMatches
+
 	// Theme
 	currentTheme Theme // Current color theme
 
@@ -201,12 +222,18 @@ type model struct {
 	messageHistory      []string // Previous user messages
 	historyIndex        int      // Current position in message history
 	tempInput          string   // Temporary storage when navigating history
+	ctrlXPending       bool     // Ctrl+X was pressed, waiting for Ctrl+E
 	
 	// Last user message for regeneration
 	lastUserMessage    string // Store last user message for Ctrl+R
 	
 	// Auto-save tracking
 	messagesSinceLastSave int  // Counter for auto-save feature
+	chatID                string // ID of the saved chat this conversation writes to; empty until first save
+	chatTitle             string  // Title of the conversation; empty until generated or set
+	chatCost              float64 // Estimated API cost of the conversation so far
+	titlePending          bool    // Whether a title is being generated
+	titleSeq              int     // Bumped whenever the conversation changes, to drop stale titles
 	
 	// Typing animation
 	typingContent      string // Full content being typed
@@ -217,11 +244,35 @@ type model struct {
 	editingMessageIndex int      // Index of message being edited
 	editingMessage      string   // Temporary content while editing
 	selectedMessage     int      // Currently selected message for navigation
+	confirmDeleteMessage bool    // Waiting for y/n before deleting the selected message
+	viewingRaw          bool     // Showing the selected message without markdown rendering
+	pinnedMessages      []string // Messages sent with the system prompt
 	
 	// Branching
 	conversationTree    *chat.ConversationTree // Conversation tree for branching
-	checkpoints         []chat.Checkpoint      // Available checkpoints
-	selectedCheckpoint  int                    // Currently selected checkpoint
+	graphRows           []graphRow             // Rows of the branch graph
+	selectedGraphRow    int                    // Currently selected graph row
+	confirmDeleteBranch bool                   // Waiting for y/n before deleting a branch
+	diffFrom            string                 // Checkpoint, branch or message picked to compare
+	diffFromLabel       string                 // Name of diffFrom for the status line
+	diffTitle           string                 // What the diff view compares
+	diffLines           []string               // Rendered side-by-side diff
+	diffScroll          int                    // First diff line on screen
+	savedTrees          []chat.TreeSummary     // Trees listed in the tree browser
+	selectedTree        int                    // Currently selected tree
+	treeBrowserFrom     appState               // Where Esc returns to from the tree browser
+	pickNodes           []string               // Messages offered for cherry-picking
+	pickSelected        map[int]bool           // Indexes into pickNodes to copy
+	pickCursor          int                    // Highlighted entry of pickNodes
+	pickSource          string                 // Branch or checkpoint being picked from
+	codeBlockList       []codeBlock            // Code blocks in the replies, numbered from 1
+	selectedCodeBlock   int                    // Highlighted entry of codeBlockList
+	codePrompt          codePrompt             // What the text input is asking for
+	codeConfirm         codePrompt             // Action waiting for y/n
+	codeTarget          string                 // File or command being confirmed
+	palette             []paletteItem          // Everything the command palette can run
+	paletteMatches      []int                  // Indexes into palette matching the query, best first
+	selectedPaletteItem int                    // Highlighted entry of paletteMatches
 	branches            []*chat.Branch         // Available branches
 	selectedBranch      int                    // Currently selected branch
 	checkpointName      string                 // Name for new checkpoint
@@ -246,7 +297,7 @@ func (m model) formatChatMessage(msg ai.UnifiedMessage, width int) string {
 		content = msg.Content
 	} else if msg.Role == "assistant" {
 		label = lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.AssistantMessage)).Bold(true).Render(m.buddyName + ": ")
-		content = highlightCode(msg.Content, m.isDarkTheme())
+		content = m.renderReply(msg.Content, lipgloss.Width(label), width-2, 0)
 	}
 
 	// Use lipgloss to handle proper wrapping
@@ -259,7 +310,8 @@ func (m model) formatChatMessage(msg ai.UnifiedMessage, width int) string {
 }
 
 // formatChatMessageWithTimestamp formats a chat message with timestamp.
-func (m model) formatChatMessageWithTimestamp(msg chat.ChatMessage, width int) string {
+// A highlighted message gets a bar in the margin so it stands out in the viewport.
+func (m model) formatChatMessageWithTimestamp(msg chat.ChatMessage, width int, highlighted bool, badges string, firstBlock int) string {
 	var label, content string
 	timeStr := msg.Timestamp.Format("15:04")
 
@@ -268,12 +320,15 @@ func (m model) formatChatMessageWithTimestamp(msg chat.ChatMessage, width int) s
 		content = msg.Content
 	} else if msg.Role == "assistant" {
 		label = lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.AssistantMessage)).Bold(true).Render(m.buddyName + ": ")
-		content = highlightCode(msg.Content, m.isDarkTheme())
+		content = m.renderReply(msg.Content, lipgloss.Width(label), width-2, firstBlock)
 	}
 
 	// Add timestamp
 	timestampStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.Status)).Faint(true)
 	timestamp := timestampStyle.Render(fmt.Sprintf("[%s]", timeStr))
+	if badges != "" {
+		timestamp += " " + timestampStyle.Render(badges)
+	}
 
 	// Use lipgloss to handle proper wrapping
 	messageStyle := lipgloss.NewStyle().
@@ -281,19 +336,46 @@ func (m model) formatChatMessageWithTimestamp(msg chat.ChatMessage, width int) s
 		PaddingLeft(0).
 		PaddingRight(2)
 
+	if highlighted {
+		messageStyle = messageStyle.
+			Width(width - 2).
+			PaddingLeft(1).
+			Border(lipgloss.ThickBorder(), false, false, false, true).
+			BorderForeground(lipgloss.Color(m.currentTheme.Spinner))
+	}
+
 	return messageStyle.Render(label+content+" "+timestamp) + "\n\n"
 }
 
 // buildChatContent generates the formatted chat history string for the viewport.
 func (m model) buildChatContent() string {
+	content, _ := m.renderChat()
+	return content
+}
+
+// renderChat formats the chat history for the viewport and returns the line
+// each entry of chatMessages starts on (-1 for messages that aren't shown).
+func (m model) renderChat() (string, []int) {
 	width, _, _ := term.GetSize(os.Stdout.Fd())
 	var chatContent string
+	offsets := make([]int, len(m.chatMessages))
 
 	// Use chatMessages if we have them (with timestamps), otherwise fall back to OpenAI messages
 	if len(m.chatMessages) > 0 {
-		for _, msg := range m.chatMessages {
+		nodes := m.messageNodes()
+		blocks := 0 // Code blocks are numbered across the conversation
+		for i, msg := range m.chatMessages {
+			offsets[i] = -1
 			if msg.Role != "system" {
-				chatContent += m.formatChatMessageWithTimestamp(msg, width-4) // Account for padding
+				offsets[i] = strings.Count(chatContent, "\n")
+				badges := m.messageBadges(nodes[i])
+				if slices.Contains(m.pinnedMessages, msg.Content) {
+					badges = strings.TrimSpace(badges + " (pinned)")
+				}
+				chatContent += m.formatChatMessageWithTimestamp(msg, width-4, i == m.highlightedMessage(), badges, blocks) // Account for padding
+			}
+			if msg.Role == "assistant" {
+				blocks += countCodeBlocks(msg.Content)
 			}
 		}
 	} else {
@@ -305,7 +387,7 @@ func (m model) buildChatContent() string {
 	}
 
 	// Add some spacing at the end to separate from input
-	return chatContent + "\n"
+	return chatContent + "\n", offsets
 }
 
 // updateViewportContent updates the viewport content and scrolls to bottom.
@@ -314,6 +396,66 @@ func (m *model) updateViewportContent() {
 	m.viewport.GotoBottom()
 }
 
+// scrollToMessage highlights a message and scrolls the viewport so it is at the top.
+func (m *model) scrollToMessage(index int) {
+	m.focusedMessage = index
+	content, offsets := m.renderChat()
+	m.viewport.SetContent(content)
+	if index >= 0 && index < len(offsets) && offsets[index] >= 0 {
+		m.viewport.SetYOffset(offsets[index])
+	}
+}
+
+// clearFocus removes the search match highlight.
+func (m *model) clearFocus() {
+	m.focusedMessage = -1
+	m.focusMatches = nil
+	m.focusMatchIndex = 0
+}
+
+// openSearchResult loads the chat a search result came from and jumps to the
+// matched message. Other matches in the same chat can be stepped through with
+// Alt+N and Alt+Shift+N.
+func (m *model) openSearchResult(result chat.SearchResult) error {
+	if err := m.loadChatHistory(result.ChatID + ".json"); err != nil {
+		return err
+	}
+
+	m.clearFocus()
+	for _, other := range m.searchResults {
+		if other.ChatID == result.ChatID {
+			m.focusMatches = append(m.focusMatches, other.MessageIndex)
+		}
+	}
+	sort.Ints(m.focusMatches)
+	for i, index := range m.focusMatches {
+		if index == result.MessageIndex {
+			m.focusMatchIndex = i
+		}
+	}
+
+	m.appState = stateChatting
+	m.scrollToMessage(result.MessageIndex)
+	m.statusMessage = m.focusStatus()
+	return nil
+}
+
+// stepFocus moves to the next (or previous) search match in the current chat.
+func (m *model) stepFocus(delta int) {
+	if len(m.focusMatches) == 0 {
+		return
+	}
+	m.focusMatchIndex = (m.focusMatchIndex + delta + len(m.focusMatches)) % len(m.focusMatches)
+	m.scrollToMessage(m.focusMatches[m.focusMatchIndex])
+	m.statusMessage = m.focusStatus()
+}
+
+// focusStatus describes the current search match position for the status line.
+func (m model) focusStatus() string {
+	return fmt.Sprintf("Match %d/%d for %q | Alt+N/Alt+Shift+N: Next/Previous match | Esc: Clear",
+		m.focusMatchIndex+1, len(m.focusMatches), m.lastSearchQuery)
+}
+
 // createTextInput creates and configures the text input component.
 func createTextInput() textinput.Model {
 	ti := textinput.New()
@@ -336,7 +478,8 @@ func createSpinner(theme Theme) spinner.Model {
 // createViewport creates and configures the viewport component.
 func createViewport() viewport.Model {
 	termWidth, termHeight, _ := term.GetSize(os.Stdout.Fd())
-	vp := viewport.New(termWidth, termHeight-viewportHeightOffset)
+	// Keep the height positive when stdout isn't a terminal
+	vp := viewport.New(termWidth, max(termHeight-viewportHeightOffset, 1))
 	vp.SetContent("")
 	return vp
 }
@@ -354,15 +497,35 @@ func determineInitialState(prefs *config.Preferences) (appState, int) {
 
 // clearConversation clears all messages except the system message.
 func (m *model) clearConversation() {
+	saved := m.autoCheckpoint("Automatic checkpoint before clearing")
+
 	systemMsg := m.messages[0] // Keep the system message
 	m.messages = []ai.UnifiedMessage{systemMsg}
 	m.chatMessages = []chat.ChatMessage{} // Clear chat history
+	m.startNewChat()
 	m.updateViewportContent()
 	m.statusMessage = "Conversation cleared"
+	if saved {
+		m.statusMessage = "Conversation cleared - saved a checkpoint, /trees to get it back"
+	}
+}
+
+// startNewChat makes the next save start a new chat with its own conversation
+// tree, so it doesn't carry the conversations before it.
+func (m *model) startNewChat() {
+	m.chatID = ""
+	m.conversationTree = nil
+	m.treeFilename = ""
+	m.resetTitle()
+	m.clearFocus()
 }
 
 // truncateAndRegenerate truncates the conversation at the given index and regenerates from there
 func (m *model) truncateAndRegenerate(index int, newContent string) {
+	// The conversation tree keeps the path being replaced
+	m.recordConversation()
+	m.autoCheckpoint("Automatic checkpoint before editing a message")
+
 	// Keep messages up to the edited message
 	// Find where to truncate in the unified messages
 	truncateAt := 1 // Start after system message
@@ -383,6 +546,7 @@ func (m *model) truncateAndRegenerate(index int, newContent string) {
 	// Add the edited message
 	m.addChatMessage("user", newContent)
 	m.lastUserMessage = newContent
+	m.updateViewportContent()
 	
 	// Start regeneration
 	m.isThinking = true
@@ -390,30 +554,30 @@ func (m *model) truncateAndRegenerate(index int, newContent string) {
 	m.statusMessage = "Regenerating from edited message..."
 }
 
-// copyToClipboard copies text to clipboard using platform-specific commands.
-func copyToClipboard(text string) error {
-	var cmd *exec.Cmd
+// copyToClipboard copies text with the clipboard method from the preferences.
+func (m model) copyToClipboard(text string) error {
+	_, err := clipboard.Copy(text, m.clipboardMethod())
+	return err
+}
 
-	switch runtime.GOOS {
-	case "darwin": // macOS
-		cmd = exec.Command("pbcopy")
-	case "linux":
-		// Try xclip first, then xsel
-		if _, err := exec.LookPath("xclip"); err == nil {
-			cmd = exec.Command("xclip", "-selection", "clipboard")
-		} else if _, err := exec.LookPath("xsel"); err == nil {
-			cmd = exec.Command("xsel", "--clipboard", "--input")
-		} else {
-			return fmt.Errorf("no clipboard utility found (install xclip or xsel)")
-		}
-	case "windows":
-		cmd = exec.Command("clip")
-	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+// clipboardMethod returns how the preferences say to reach the clipboard.
+func (m model) clipboardMethod() clipboard.Method {
+	if m.preferences == nil {
+		return clipboard.Auto
 	}
+	return clipboard.ParseMethod(m.preferences.Clipboard)
+}
+
+// clipboardPasteMsg carries the text read from the clipboard.
+type clipboardPasteMsg struct {
+	Text string
+	Err  error
+}
 
-	cmd.Stdin = strings.NewReader(text)
-	return cmd.Run()
+// pasteFromClipboard reads the clipboard in the background.
+func pasteFromClipboard() tea.Msg {
+	text, err := clipboard.Paste()
+	return clipboardPasteMsg{Text: text, Err: err}
 }
 
 // getLastAssistantMessage returns the last message from the assistant.
@@ -434,44 +598,20 @@ func (m *model) loadChatHistory(filename string) error {
 	}
 
 	// Load the chat into current session
-	m.chatMessages = history.Messages
-	m.buddyName = history.BuddyName
-	m.currentModel = history.Model
-
-	// Convert to unified messages format
-	m.messages = []ai.UnifiedMessage{
-		{Role: "system", Content: createSystemMessage(m.preferences, m.buddyName, m.currentPersonality)},
-	}
-
-	for _, msg := range history.Messages {
-		if msg.Role != "system" {
-			m.messages = append(m.messages, ai.UnifiedMessage{
-				Role:    msg.Role,
-				Content: msg.Content,
-			})
-		}
-	}
-
-	m.updateViewportContent()
+	note := m.restoreSession(history.BuddyName, history.Model, history.Settings, history.Messages)
+	m.chatID = history.ID
+	m.chatTitle = history.Title
+	m.chatCost = history.Cost
+	m.conversationTree = history.Tree
+	m.treeFilename = ""
+	m.statusMessage = fmt.Sprintf("Loaded chat: %s%s", chat.ChatIDFromFilename(filename), note)
 	return nil
 }
 
-// refreshChatList refreshes the list of saved chats.
-func (m *model) refreshChatList() {
-	chats, err := chat.ListChats()
-	if err != nil {
-		m.statusMessage = fmt.Sprintf("Failed to load chats: %v", err)
-		return
-	}
-
-	m.savedChats = chats
-	if len(m.savedChats) > 0 && m.selectedChat >= len(m.savedChats) {
-		m.selectedChat = len(m.savedChats) - 1
-	}
-}
-
 // applyTemplate applies a system prompt template to the current session.
 func (m *model) applyTemplate(template SystemPromptTemplate) {
+	m.autoCheckpoint(fmt.Sprintf("Automatic checkpoint before applying the %s template", template.Name))
+
 	// Update buddy name and system prompt
 	m.buddyName = template.BuddyName
 	m.preferences.BuddyName = template.BuddyName
@@ -486,53 +626,22 @@ func (m *model) applyTemplate(template SystemPromptTemplate) {
 		{Role: "system", Content: systemMessage},
 	}
 	m.chatMessages = []chat.ChatMessage{}
+	m.startNewChat()
 
 	m.updateViewportContent()
 	m.statusMessage = fmt.Sprintf("Applied template: %s", template.Name)
 }
 
-// searchChats searches through all saved chats for the given query.
-func searchChats(query string) ([]chat.ChatMessage, error) {
-	if query == "" {
-		return []chat.ChatMessage{}, nil
-	}
-
-	chats, err := chat.ListChats()
-	if err != nil {
-		return nil, err
-	}
-
-	var results []chat.ChatMessage
-	queryLower := strings.ToLower(query)
-
-	for _, filename := range chats {
-		history, err := chat.LoadChat(filename)
-		if err != nil {
-			continue // Skip files we can't read
-		}
-
-		// Search through messages in this chat
-		for _, msg := range history.Messages {
-			if msg.Role != "system" {
-				if strings.Contains(strings.ToLower(msg.Content), queryLower) {
-					results = append(results, msg)
-				}
-			}
-		}
-	}
-
-	return results, nil
-}
-
 // performSearch performs a search and updates the search results.
 func (m *model) performSearch() {
-	results, err := searchChats(m.searchQuery)
+	results, err := chat.SearchChats(m.searchQuery)
 	if err != nil {
 		m.statusMessage = fmt.Sprintf("Search failed: %v", err)
 		return
 	}
 
 	m.searchResults = results
+	m.lastSearchQuery = m.searchQuery
 	m.selectedResult = 0
 	if len(results) == 0 {
 		m.statusMessage = "No results found"
@@ -543,7 +652,6 @@ func (m *model) performSearch() {
 
 // cycleTheme cycles to the next available theme.
 func (m *model) cycleTheme() {
-	themeNames := []string{"default", "dark", "ocean", "sunset", "forest"}
 	currentIndex := 0
 
 	// Find current theme index
@@ -555,107 +663,75 @@ func (m *model) cycleTheme() {
 	}
 
 	// Move to next theme
-	nextIndex := (currentIndex + 1) % len(themeNames)
-	m.currentTheme = themes[themeNames[nextIndex]]
+	m.setTheme(themeNames[(currentIndex+1)%len(themeNames)])
+}
+
+// setTheme switches to a theme and remembers it in the preferences.
+func (m *model) setTheme(name string) {
+	m.currentTheme = themes[name]
 
 	// Save theme preference
 	if m.preferences != nil {
-		m.preferences.Theme = themeNames[nextIndex]
+		m.preferences.Theme = name
 		config.SavePreferences(m.preferences)
 	}
 
 	m.statusMessage = fmt.Sprintf("Theme changed to: %s", m.currentTheme.Name)
 }
 
-// highlightCode applies syntax highlighting to code blocks in the content.
-func highlightCode(content string, isDarkTheme bool) string {
-	// First, handle inline code with single backticks
-	inlineCodeRegex := regexp.MustCompile("`([^`]+)`")
-	content = inlineCodeRegex.ReplaceAllStringFunc(content, func(match string) string {
-		code := strings.Trim(match, "`")
-		codeStyle := lipgloss.NewStyle().
-			Background(lipgloss.Color("236")).
-			Foreground(lipgloss.Color("15")).
-			Padding(0, 1)
-		return codeStyle.Render(code)
-	})
+// applyPersonality gives the buddy a personality and remembers it in the
+// preferences.
+func (m *model) applyPersonality(personality *Personality) {
+	m.currentPersonality = personality
 
-	// Then handle code blocks: ```language\ncode\n```
-	codeBlockRegex := regexp.MustCompile("```([a-zA-Z0-9_+-]*)\\n([\\s\\S]*?)\\n```")
-
-	// Choose appropriate style based on theme
-	styleName := "github"
-	if isDarkTheme {
-		styleName = "github-dark"
+	// Update buddy name with personality
+	baseName := ""
+	if m.preferences != nil {
+		baseName = m.preferences.BuddyName
 	}
-
-	style := styles.Get(styleName)
-	if style == nil {
-		style = styles.Fallback
+	if baseName == "" {
+		baseName = defaultBuddyName
 	}
+	m.buddyName = ApplyPersonalityToBuddyName(personality, baseName)
 
-	formatter := formatters.Get("terminal256")
-	if formatter == nil {
-		formatter = formatters.Fallback
+	// Update system message with personality
+	systemMessage := createSystemMessage(m.preferences, baseName, personality)
+	if len(m.messages) > 0 {
+		m.messages[0].Content = systemMessage
 	}
 
-	return codeBlockRegex.ReplaceAllStringFunc(content, func(match string) string {
-		// Extract language and code
-		parts := codeBlockRegex.FindStringSubmatch(match)
-		if len(parts) != 3 {
-			return match // Return original if regex fails
-		}
-
-		language := parts[1]
-		code := parts[2]
-
-		// Get lexer for the language
-		var lexer chroma.Lexer
-		if language != "" {
-			lexer = lexers.Get(language)
-		}
-		if lexer == nil {
-			lexer = lexers.Analyse(code)
-		}
-		if lexer == nil {
-			lexer = lexers.Fallback
-		}
-
-		// Apply syntax highlighting
-		tokens, err := lexer.Tokenise(nil, code)
-		if err != nil {
-			return match // Return original if highlighting fails
-		}
-
-		var buf strings.Builder
-		err = formatter.Format(&buf, style, tokens)
-		if err != nil {
-			return match // Return original if formatting fails
-		}
-
-		// Create a styled code block
-		highlightedCode := buf.String()
+	// Save preference
+	if m.preferences != nil {
+		m.preferences.Personality = personality.ID
+		config.SavePreferences(m.preferences)
+	}
+	m.statusMessage = fmt.Sprintf("Personality changed to: %s %s", personality.Emoji, personality.Name)
+}
 
-		// Add language label and border
-		languageLabel := language
-		if languageLabel == "" {
-			languageLabel = "code"
-		}
+// applyRetroTheme switches to a retro theme and remembers it in the
+// preferences.
+func (m *model) applyRetroTheme(theme *RetroTheme) {
+	m.currentRetroTheme = theme
+	m.currentTheme = theme.BaseTheme
+	m.retroEffectsEnabled = true
 
-		codeStyle := lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("240")).
-			Padding(0, 1).
-			Margin(1, 0)
+	// Update spinner with new theme
+	m.spinner.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.Spinner))
 
-		labelStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("12")).
-			Bold(true)
+	// Save preference
+	if m.preferences != nil {
+		m.preferences.RetroTheme = theme.ID
+		config.SavePreferences(m.preferences)
+	}
+	m.statusMessage = fmt.Sprintf("Retro theme applied: %s", theme.Name)
+}
 
-		return codeStyle.Render(
-			labelStyle.Render(languageLabel) + "\n" + strings.TrimSpace(highlightedCode),
-		)
-	})
+// renderReply renders an assistant reply's markdown to fit after its label,
+// indenting the lines below the first to line up with it. Its code blocks are
+// numbered from firstBlock.
+func (m model) renderReply(content string, labelWidth, width, firstBlock int) string {
+	rendered := renderMarkdown(content, width-labelWidth, m.currentTheme, m.isDarkTheme(), firstBlock)
+	return strings.ReplaceAll(rendered, "\n", "\n"+strings.Repeat(" ", labelWidth))
 }
 
 // isDarkTheme returns true if the current theme is considered dark.
@@ -680,6 +756,10 @@ func (m model) isDarkTheme() bool {
 
 // calculateTokenCost calculates the estimated cost for the given token usage.
 func calculateTokenCost(model string, promptTokens, completionTokens int) float64 {
+	if strings.HasPrefix(model, "claude") {
+		return ai.CalculateClaudeCost(model, promptTokens, completionTokens)
+	}
+
 	pricing, exists := ai.ModelPricing[model]
 	if !exists {
 		return 0.0
@@ -691,15 +771,25 @@ func calculateTokenCost(model string, promptTokens, completionTokens int) float6
 	return promptCost + completionCost
 }
 
-// updateTokenUsage updates the token usage statistics.
-func (m *model) updateTokenUsage(promptTokens, completionTokens int) {
-	cost := calculateTokenCost(m.currentModel, promptTokens, completionTokens)
+// updateTokenUsage updates the token usage statistics for a response from a model.
+func (m *model) updateTokenUsage(modelName string, promptTokens, completionTokens int) {
+	cost := calculateTokenCost(modelName, promptTokens, completionTokens)
 
 	m.tokenUsage.PromptTokens += promptTokens
 	m.tokenUsage.CompletionTokens += completionTokens
 	m.tokenUsage.TotalTokens += promptTokens + completionTokens
 	m.tokenUsage.EstimatedCost += cost
 	m.tokenUsage.RequestCount++
+	m.chatCost += cost
+
+	// Usage history is nice to have, so a failed write shouldn't interrupt the chat
+	chat.RecordUsage(chat.UsageRecord{
+		ChatID:           m.chatID,
+		Model:            modelName,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Cost:             cost,
+	})
 }
 
 // clearStatusAfterDelay returns a command that will clear the status message after a delay.
@@ -709,27 +799,57 @@ func clearStatusAfterDelay() tea.Cmd {
 	})
 }
 
-// refreshBranchList refreshes the list of checkpoints from the conversation tree
-func (m *model) refreshBranchList() {
-	if m.conversationTree != nil {
-		m.checkpoints = m.conversationTree.ListCheckpoints()
-		m.selectedCheckpoint = 0
-	}
-}
-
 // initializeConversationTree creates a new conversation tree if none exists
 func (m *model) initializeConversationTree() {
 	if m.conversationTree == nil {
 		m.conversationTree = chat.NewConversationTree()
+	}
+	if m.treeFilename == "" {
 		m.treeFilename = fmt.Sprintf("tree_%s.json", time.Now().Format("2006-01-02_15-04-05"))
 	}
 }
 
+// treeMessages returns the conversation as recorded in the conversation tree,
+// without the notices shown as system messages.
+func (m *model) treeMessages() []chat.ChatMessage {
+	var messages []chat.ChatMessage
+	for _, msg := range m.chatMessages {
+		if msg.Role != "system" {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// recordConversation adds the current conversation to the conversation tree.
+// Messages an edit or regenerate replaces stay in the tree as siblings of the
+// new ones.
+func (m *model) recordConversation() {
+	if m.isTyping || len(m.chatMessages) == 0 {
+		return // Wait for the whole reply
+	}
+	m.initializeConversationTree()
+	m.conversationTree.SyncMessages(m.treeMessages())
+}
+
+// describeConversationTree stores the session settings in the conversation
+// tree so it can be resumed later.
+func (m *model) describeConversationTree() {
+	m.conversationTree.BuddyName = m.buddyName
+	m.conversationTree.Model = m.currentModel
+	m.conversationTree.Settings = m.generationSettings()
+	if m.chatTitle != "" {
+		m.conversationTree.Title = m.chatTitle
+	}
+}
+
 // createCheckpoint creates a new checkpoint with the current conversation state
 func (m *model) createCheckpoint(name, description string) error {
 	m.initializeConversationTree()
-	
-	_, err := m.conversationTree.CreateCheckpoint(name, description, m.chatMessages)
+
+	m.describeConversationTree()
+
+	_, err := m.conversationTree.CreateCheckpoint(name, description, m.treeMessages())
 	if err != nil {
 		return err
 	}
@@ -738,6 +858,56 @@ func (m *model) createCheckpoint(name, description string) error {
 	return chat.SaveTree(m.conversationTree, m.treeFilename)
 }
 
+// defaultAutoCheckpointTurns is how many replies pass between automatic
+// checkpoints unless the preferences say otherwise.
+const defaultAutoCheckpointTurns = 10
+
+// autoCheckpointTurns returns how many replies pass between automatic
+// checkpoints, or 0 if they're turned off.
+func (m model) autoCheckpointTurns() int {
+	if m.preferences == nil || m.preferences.AutoCheckpointTurns == 0 {
+		return defaultAutoCheckpointTurns
+	}
+	return max(m.preferences.AutoCheckpointTurns, 0)
+}
+
+// replyCount returns how many replies the conversation has.
+func (m model) replyCount() int {
+	count := 0
+	for _, msg := range m.chatMessages {
+		if msg.Role == "assistant" {
+			count++
+		}
+	}
+	return count
+}
+
+// autoCheckpoint saves a checkpoint of the conversation before something
+// replaces it, and reports whether the conversation is now checkpointed. Failures are shown in the
+// status line but don't stop what the user asked for.
+func (m *model) autoCheckpoint(reason string) bool {
+	messages := m.treeMessages()
+	if len(messages) == 0 {
+		return false
+	}
+	if m.isTyping && messages[len(messages)-1].Role == "assistant" {
+		// Keep the whole reply, not just the part typed so far
+		messages[len(messages)-1].Content = m.typingContent
+	}
+
+	m.initializeConversationTree()
+	m.describeConversationTree()
+	_, err := m.conversationTree.CreateAutoCheckpoint(reason, messages)
+	if err == nil {
+		err = chat.SaveTree(m.conversationTree, m.treeFilename)
+	}
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Automatic checkpoint failed: %v", err)
+		return false
+	}
+	return true
+}
+
 // loadCheckpoint loads a conversation from a checkpoint
 func (m *model) loadCheckpoint(checkpointID string) error {
 	if m.conversationTree == nil {
@@ -748,24 +918,8 @@ func (m *model) loadCheckpoint(checkpointID string) error {
 	if err != nil {
 		return err
 	}
-	
-	// Convert chat messages to unified messages
-	m.messages = []ai.UnifiedMessage{
-		{Role: "system", Content: m.messages[0].Content}, // Keep system message
-	}
-	m.chatMessages = []chat.ChatMessage{}
-	
-	for _, msg := range messages {
-		if msg.Role != "system" {
-			m.messages = append(m.messages, ai.UnifiedMessage{
-				Role:    msg.Role,
-				Content: msg.Content,
-			})
-			m.chatMessages = append(m.chatMessages, msg)
-		}
-	}
-	
-	m.updateViewportContent()
+
+	m.showConversation(messages)
 	return nil
 }
 
@@ -779,13 +933,40 @@ func typingTick() tea.Cmd {
 // saveCurrentChat saves the current conversation to a file.
 func (m *model) saveCurrentChat() error {
 	history := chat.ChatHistory{
+		ID:        m.chatID,
+		Title:     m.chatTitle,
 		Messages:  m.chatMessages,
 		BuddyName: m.buddyName,
 		Model:     m.currentModel,
+		Settings:  m.generationSettings(),
+		Cost:      m.chatCost,
 	}
 
-	_, err := chat.SaveChat(history)
-	return err
+	// Keep regenerated replies and edited questions that aren't shown
+	if m.conversationTree != nil && len(m.conversationTree.Nodes) > len(m.treeMessages()) {
+		history.Tree = m.conversationTree
+	}
+
+	// Keep the tags, pin and archive flags set from the chat browser, and
+	// where an imported chat came from so importing it again skips it
+	if m.chatID != "" {
+		if saved, err := chat.LoadChat(m.chatID); err == nil {
+			history.Tags = saved.Tags
+			history.Pinned = saved.Pinned
+			history.Archived = saved.Archived
+			history.Source = saved.Source
+			history.SourceID = saved.SourceID
+		}
+	}
+
+	filename, err := chat.SaveChat(history)
+	if err != nil {
+		return err
+	}
+
+	// Later saves update the same file
+	m.chatID = chat.ChatIDFromFilename(filename)
+	return nil
 }
 
 // addChatMessage adds a message to both the unified messages and our chat history.
@@ -808,6 +989,8 @@ func (m *model) addChatMessage(role, content string) {
 // exportToMarkdown exports the current chat to a markdown file.
 func (m *model) exportToMarkdown() error {
 	history := chat.ChatHistory{
+		ID:        m.chatID,
+		Title:     m.chatTitle,
 		Messages:  m.chatMessages,
 		BuddyName: m.buddyName,
 		Model:     m.currentModel,
@@ -817,6 +1000,19 @@ func (m *model) exportToMarkdown() error {
 	return err
 }
 
+// exportToHTML exports the current chat to an HTML page with a light/dark switch.
+func (m *model) exportToHTML() (string, error) {
+	history := chat.ChatHistory{
+		ID:        m.chatID,
+		Title:     m.chatTitle,
+		Messages:  m.chatMessages,
+		BuddyName: m.buddyName,
+		Model:     m.currentModel,
+	}
+
+	return chat.ExportToHTML(history, chat.HTMLOptions{ThemeToggle: true})
+}
+
 // createSystemMessage creates the system message based on preferences.
 func createSystemMessage(prefs *config.Preferences, buddyName string, personality *Personality) string {
 	systemMessage := defaultSystemMessage
@@ -884,6 +1080,7 @@ func initialModel(client *ai.UnifiedClient) model {
 		},
 		chatMessages:   []chat.ChatMessage{}, // Initialize empty chat history
 		textInput:      createTextInput(),
+		composer:       createComposer(),
 		spinner:        createSpinner(currentTheme),
 		isThinking:     false,
 		preferences:    prefs,
@@ -893,12 +1090,14 @@ func initialModel(client *ai.UnifiedClient) model {
 		viewport:       createViewport(),
 		currentModel:   currentModel,
 		statusMessage:  fmt.Sprintf("Using %s", currentModel),
-		savedChats:     []string{},
+		generation:     ai.GenerationOptions{Temperature: prefs.Temperature, MaxTokens: prefs.MaxTokens},
+		savedChats:     []chat.ChatSummary{},
 		selectedChat:   0,
 		selectedTemplate: 0,
 		searchQuery:    "",
-		searchResults:  []chat.ChatMessage{},
+		searchResults:  []chat.SearchResult{},
 		selectedResult: 0,
+		focusedMessage: -1,
 		currentTheme:   currentTheme,
 		loadingMessage: "Thinking...", // Default loading message
 		messageHistory: []string{},
@@ -915,7 +1114,7 @@ func initialModel(client *ai.UnifiedClient) model {
 
 // Init is called once to initialize the program.
 func (m model) Init() tea.Cmd {
-	return textinput.Blink
+	return tea.Batch(textinput.Blink, textarea.Blink)
 }
 
 // handleOnboardingInput handles user input during onboarding.
@@ -945,6 +1144,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
 
+	// Titles are generated in the background and may arrive on any screen
+	if msg, ok := msg.(titleMsg); ok {
+		m.applyTitle(msg)
+		return m, clearStatusAfterDelay()
+	}
+	if msg, ok := msg.(importMsg); ok {
+		m.applyImport(msg)
+		return m, clearStatusAfterDelay()
+	}
+	if msg, ok := msg.(mergeMsg); ok {
+		m.applyMerge(msg)
+		return m, clearStatusAfterDelay()
+	}
+	if msg, ok := msg.(editorMsg); ok {
+		return m, m.applyEditor(msg)
+	}
+	if msg, ok := msg.(clipboardPasteMsg); ok {
+		if msg.Err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to paste: %v - try your terminal's paste (Ctrl+Shift+V)", msg.Err)
+			return m, clearStatusAfterDelay()
+		}
+		if m.appState == stateChatting {
+			m.composer.InsertString(strings.ReplaceAll(msg.Text, "\r\n", "\n"))
+			m.resizeComposer()
+		}
+		return m, nil
+	}
+	if msg, ok := msg.(codeRunMsg); ok {
+		m.applyCodeRun(msg)
+		return m, clearStatusAfterDelay()
+	}
+
 	switch m.appState {
 	case stateOnboarding:
 		m.textInput, cmd = m.textInput.Update(msg)
@@ -968,37 +1199,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case stateChatBrowser:
-		switch msg := msg.(type) {
-		case tea.KeyMsg:
-			switch msg.String() {
-			case "ctrl+c", "q", "esc":
-				m.appState = stateChatting
-				m.statusMessage = "Back to chat"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "up", "k":
-				if m.selectedChat > 0 {
-					m.selectedChat--
-				}
-			case "down", "j":
-				if m.selectedChat < len(m.savedChats)-1 {
-					m.selectedChat++
-				}
-			case "enter":
-				if len(m.savedChats) > 0 && m.selectedChat < len(m.savedChats) {
-					if err := m.loadChatHistory(m.savedChats[m.selectedChat]); err != nil {
-						m.statusMessage = fmt.Sprintf("Failed to load chat: %v", err)
-					} else {
-						m.appState = stateChatting
-						m.statusMessage = fmt.Sprintf("Loaded chat: %s", m.savedChats[m.selectedChat])
-					}
-					cmds = append(cmds, clearStatusAfterDelay())
-				}
-			case "r":
-				// Refresh chat list
-				m.refreshChatList()
-				m.statusMessage = "Chat list refreshed"
-				cmds = append(cmds, clearStatusAfterDelay())
-			}
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateChatBrowser(msg))
 		}
 
 	case stateTemplateSelector:
@@ -1035,8 +1237,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.statusMessage = "Back to chat"
 				cmds = append(cmds, clearStatusAfterDelay())
 			case "enter":
-				if m.searchQuery != "" {
+				if m.searchQuery != "" && m.searchQuery != m.lastSearchQuery {
 					m.performSearch()
+				} else if len(m.searchResults) > 0 && m.selectedResult < len(m.searchResults) {
+					// Open the selected result in its conversation
+					if err := m.openSearchResult(m.searchResults[m.selectedResult]); err != nil {
+						m.statusMessage = fmt.Sprintf("Failed to open chat: %v", err)
+						cmds = append(cmds, clearStatusAfterDelay())
+					}
 				}
 			case "up", "k":
 				if len(m.searchResults) > 0 && m.selectedResult > 0 {
@@ -1058,108 +1266,41 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
-	case stateEditMessage:
-		switch msg := msg.(type) {
-		case tea.KeyMsg:
-			switch msg.String() {
-			case "ctrl+c", "q", "esc":
-				m.appState = stateChatting
-				m.statusMessage = "Edit cancelled"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "up", "k":
-				// Navigate up through messages (skip system messages)
-				for m.selectedMessage > 0 {
-					m.selectedMessage--
-					if m.chatMessages[m.selectedMessage].Role != "system" {
-						break
-					}
-				}
-			case "down", "j":
-				// Navigate down through messages (skip system messages)
-				for m.selectedMessage < len(m.chatMessages)-1 {
-					m.selectedMessage++
-					if m.chatMessages[m.selectedMessage].Role != "system" {
-						break
-					}
-				}
-			case "enter":
-				// Start editing the selected message
-				if m.selectedMessage < len(m.chatMessages) {
-					msg := m.chatMessages[m.selectedMessage]
-					if msg.Role == "user" {
-						// Edit user message
-						m.textInput.SetValue(msg.Content)
-						m.editingMessageIndex = m.selectedMessage
-						m.appState = stateChatting
-						m.statusMessage = "Edit message and press Enter to regenerate from this point"
-					} else {
-						m.statusMessage = "Can only edit user messages"
-						cmds = append(cmds, clearStatusAfterDelay())
-					}
-				}
-			}
+	case stateSelectMessage:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateSelectMessage(msg))
 		}
 
 	case stateBranchManager:
-		switch msg := msg.(type) {
-		case tea.KeyMsg:
-			switch msg.String() {
-			case "ctrl+c", "q", "esc":
-				m.appState = stateChatting
-				m.statusMessage = "Back to chat"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "up", "k":
-				if m.selectedCheckpoint > 0 {
-					m.selectedCheckpoint--
-				}
-			case "down", "j":
-				if m.selectedCheckpoint < len(m.checkpoints)-1 {
-					m.selectedCheckpoint++
-				}
-			case "enter":
-				// Load from checkpoint
-				if len(m.checkpoints) > 0 && m.selectedCheckpoint < len(m.checkpoints) {
-					if err := m.loadCheckpoint(m.checkpoints[m.selectedCheckpoint].ID); err != nil {
-						m.statusMessage = fmt.Sprintf("Failed to load checkpoint: %v", err)
-					} else {
-						m.appState = stateChatting
-						m.statusMessage = fmt.Sprintf("Loaded checkpoint: %s", m.checkpoints[m.selectedCheckpoint].Name)
-					}
-					cmds = append(cmds, clearStatusAfterDelay())
-				}
-			case "b":
-				// Create branch from checkpoint
-				if len(m.checkpoints) > 0 && m.selectedCheckpoint < len(m.checkpoints) {
-					checkpoint := m.checkpoints[m.selectedCheckpoint]
-					newBranch, err := m.conversationTree.CreateBranch(
-						checkpoint.ID,
-						fmt.Sprintf("branch_%d", time.Now().Unix()),
-						fmt.Sprintf("Branched from: %s", checkpoint.Name),
-					)
-					if err != nil {
-						m.statusMessage = fmt.Sprintf("Failed to create branch: %v", err)
-					} else {
-						m.conversationTree.SwitchBranch(newBranch.ID)
-						m.loadCheckpoint(checkpoint.ID)
-						m.appState = stateChatting
-						m.statusMessage = fmt.Sprintf("Created new branch from checkpoint: %s", checkpoint.Name)
-						chat.SaveTree(m.conversationTree, m.treeFilename)
-					}
-					cmds = append(cmds, clearStatusAfterDelay())
-				}
-			case "s":
-				// Save conversation tree
-				if m.conversationTree != nil {
-					if err := chat.SaveTree(m.conversationTree, m.treeFilename); err != nil {
-						m.statusMessage = fmt.Sprintf("Failed to save tree: %v", err)
-					} else {
-						m.statusMessage = "Conversation tree saved"
-					}
-					cmds = append(cmds, clearStatusAfterDelay())
-				}
-			}
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateBranchManager(msg))
 		}
-		
+
+	case stateBranchDiff:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateBranchDiff(msg))
+		}
+
+	case stateTreeBrowser:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateTreeBrowser(msg))
+		}
+
+	case stateCherryPick:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateCherryPick(msg))
+		}
+
+	case stateCodeBlocks:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updateCodeBlocks(msg))
+		}
+
+	case statePalette:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			cmds = append(cmds, m.updatePalette(msg))
+		}
+
 	case stateCreateCheckpoint:
 		m.textInput, cmd = m.textInput.Update(msg)
 		cmds = append(cmds, cmd)
@@ -1203,31 +1344,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			case "enter":
 				if m.selectedPersonality < len(personalities) {
-					// Apply the selected personality
-					selectedPersonality := &personalities[m.selectedPersonality]
-					m.currentPersonality = selectedPersonality
-					
-					// Update buddy name with personality
-					baseName := m.preferences.BuddyName
-					if baseName == "" {
-						baseName = defaultBuddyName
-					}
-					m.buddyName = ApplyPersonalityToBuddyName(selectedPersonality, baseName)
-					
-					// Update system message with personality
-					systemMessage := createSystemMessage(m.preferences, baseName, selectedPersonality)
-					if len(m.messages) > 0 {
-						m.messages[0].Content = systemMessage
-					}
-					
-					// Save preference
-					if m.preferences != nil {
-						m.preferences.Personality = selectedPersonality.ID
-						config.SavePreferences(m.preferences)
-					}
-					
+					m.applyPersonality(&personalities[m.selectedPersonality])
 					m.appState = stateChatting
-					m.statusMessage = fmt.Sprintf("Personality changed to: %s %s", selectedPersonality.Emoji, selectedPersonality.Name)
 					cmds = append(cmds, clearStatusAfterDelay())
 				}
 			}
@@ -1251,23 +1369,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			case "enter":
 				if m.selectedRetroTheme < len(retroThemes) {
-					// Apply the selected retro theme
-					selectedTheme := &retroThemes[m.selectedRetroTheme]
-					m.currentRetroTheme = selectedTheme
-					m.currentTheme = selectedTheme.BaseTheme
-					m.retroEffectsEnabled = true
-					
-					// Update spinner with new theme
-					m.spinner.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.Spinner))
-					
-					// Save preference
-					if m.preferences != nil {
-						m.preferences.RetroTheme = selectedTheme.ID
-						config.SavePreferences(m.preferences)
-					}
-					
+					m.applyRetroTheme(&retroThemes[m.selectedRetroTheme])
 					m.appState = stateChatting
-					m.statusMessage = fmt.Sprintf("Retro theme applied: %s", selectedTheme.Name)
 					cmds = append(cmds, clearStatusAfterDelay())
 				}
 			}
@@ -1276,16 +1379,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case stateChatting:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
+			// Pasted text goes straight into the composer, newlines and all
+			if msg.Paste {
+				msg.Runes = []rune(strings.ReplaceAll(string(msg.Runes), "\r\n", "\n"))
+				m.composer, cmd = m.composer.Update(msg)
+				m.resizeComposer()
+				cmds = append(cmds, cmd)
+				break
+			}
+
+			// Ctrl+X starts a chord such as Ctrl+X Ctrl+E
+			if m.ctrlXPending {
+				m.ctrlXPending = false
+				m.statusMessage = ""
+				if action := findAction("ctrl+x " + msg.String()); action != nil {
+					cmds = append(cmds, action.Run(&m))
+					break
+				}
+			}
+
+			// : opens the command palette when there's no message to type it into
+			if msg.String() == ":" && m.composer.Value() == "" {
+				cmds = append(cmds, m.openPalette())
+				break
+			}
+			if action := findAction(msg.String()); action != nil {
+				cmds = append(cmds, action.Run(&m))
+				break
+			}
+
 			switch msg.String() {
-			case "ctrl+c":
-				return m, tea.Quit
 			case "q":
 				// Only quit if input is empty
-				if m.textInput.Value() == "" {
+				if m.composer.Value() == "" {
 					return m, tea.Quit
 				} else {
 					// Pass through to text input
-					m.textInput, cmd = m.textInput.Update(msg)
+					m.composer, cmd = m.composer.Update(msg)
 					cmds = append(cmds, cmd)
 				}
 			case "pgup":
@@ -1294,29 +1424,32 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.viewport.LineDown(10)
 			case "up":
 				// If input is empty, navigate message history
-				if m.textInput.Value() == "" && len(m.messageHistory) > 0 {
+				if m.composer.Value() == "" && len(m.messageHistory) > 0 {
 					// Save current input if we're just starting to navigate
 					if m.historyIndex == len(m.messageHistory) {
-						m.tempInput = m.textInput.Value()
+						m.tempInput = m.composer.Value()
 					}
 					
 					// Move up in history
 					if m.historyIndex > 0 {
 						m.historyIndex--
-						m.textInput.SetValue(m.messageHistory[m.historyIndex])
-						m.textInput.CursorEnd()
+						m.setComposer(m.messageHistory[m.historyIndex])
 					}
+				} else if m.composer.Line() > 0 {
+					// Move up a line in a multi-line message
+					m.composer, cmd = m.composer.Update(msg)
+					cmds = append(cmds, cmd)
 				} else {
 					// Normal viewport scrolling
 					m.viewport.LineUp(1)
 				}
 			case "k":
 				// Only use for scrolling if input is empty
-				if m.textInput.Value() == "" {
+				if m.composer.Value() == "" {
 					m.viewport.LineUp(1)
 				} else {
 					// Pass through to text input
-					m.textInput, cmd = m.textInput.Update(msg)
+					m.composer, cmd = m.composer.Update(msg)
 					cmds = append(cmds, cmd)
 				}
 			case "down":
@@ -1325,242 +1458,54 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.historyIndex++
 					if m.historyIndex == len(m.messageHistory) {
 						// Restore the temporary input
-						m.textInput.SetValue(m.tempInput)
+						m.setComposer(m.tempInput)
 					} else {
-						m.textInput.SetValue(m.messageHistory[m.historyIndex])
+						m.setComposer(m.messageHistory[m.historyIndex])
 					}
-					m.textInput.CursorEnd()
+				} else if m.composer.Line() < m.composer.LineCount()-1 {
+					// Move down a line in a multi-line message
+					m.composer, cmd = m.composer.Update(msg)
+					cmds = append(cmds, cmd)
 				} else {
 					// Normal viewport scrolling
 					m.viewport.LineDown(1)
 				}
 			case "j":
 				// Only use for scrolling if input is empty
-				if m.textInput.Value() == "" {
+				if m.composer.Value() == "" {
 					m.viewport.LineDown(1)
 				} else {
 					// Pass through to text input
-					m.textInput, cmd = m.textInput.Update(msg)
+					m.composer, cmd = m.composer.Update(msg)
 					cmds = append(cmds, cmd)
 				}
-			case "home":
-				m.viewport.GotoTop()
-			case "end":
-				m.viewport.GotoBottom()
-			case "ctrl+l":
-				m.clearConversation()
-				m.statusMessage = "Conversation cleared"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+m":
-				// Cycle through available models
-				availableModels := getAvailableModels(m.client)
-				currentIndex := 0
-				for i, model := range availableModels {
-					if model == m.currentModel {
-						currentIndex = i
-						break
-					}
-				}
-				nextIndex := (currentIndex + 1) % len(availableModels)
-				m.currentModel = availableModels[nextIndex]
-				m.preferences.Model = m.currentModel
-				config.SavePreferences(m.preferences)
-				m.statusMessage = fmt.Sprintf("Switched to %s", m.currentModel)
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+s":
-				// Save chat history
-				if err := m.saveCurrentChat(); err != nil {
-					m.statusMessage = fmt.Sprintf("Failed to save: %v", err)
-				} else {
-					m.statusMessage = "Chat history saved"
-					m.messagesSinceLastSave = 0 // Reset auto-save counter
-				}
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+e":
-				// Export to markdown
-				if err := m.exportToMarkdown(); err != nil {
-					m.statusMessage = fmt.Sprintf("Failed to export: %v", err)
-				} else {
-					m.statusMessage = "Chat exported to markdown"
-				}
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+t":
-				// Show token usage stats
-				m.statusMessage = fmt.Sprintf("Tokens: %d | Requests: %d | Cost: $%.4f",
-					m.tokenUsage.TotalTokens, m.tokenUsage.RequestCount, m.tokenUsage.EstimatedCost)
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+y":
-				// Copy last assistant message to clipboard
-				lastMsg := m.getLastAssistantMessage()
-				if lastMsg != "" {
-					if err := copyToClipboard(lastMsg); err != nil {
-						m.statusMessage = fmt.Sprintf("Failed to copy: %v", err)
+			case "alt+n", "alt+N":
+				// Step through search matches; plain n/N would eat the
+				// first letter of a message
+				if len(m.focusMatches) > 0 {
+					if msg.String() == "alt+n" {
+						m.stepFocus(1)
 					} else {
-						m.statusMessage = "Last response copied to clipboard"
+						m.stepFocus(-1)
 					}
-				} else {
-					m.statusMessage = "No assistant message to copy"
 				}
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+b":
-				// Open chat browser
-				m.appState = stateChatBrowser
-				m.refreshChatList()
-				m.statusMessage = "Chat browser - Use arrows to navigate, Enter to load, Esc to return"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+k":
-				// Create checkpoint
-				if len(m.chatMessages) > 0 {
-					m.appState = stateCreateCheckpoint
-					m.checkpointName = chat.GenerateCheckpointName(m.chatMessages)
-					m.textInput.SetValue(m.checkpointName)
-					m.textInput.Focus()
-					m.statusMessage = "Create checkpoint - Enter name and press Enter"
-				}
-			case "ctrl+h":
-				// Open branch manager
-				m.appState = stateBranchManager
-				m.refreshBranchList()
-				m.statusMessage = "Branch manager - Use arrows to navigate, Enter to load checkpoint, B to branch, Esc to return"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+a":
-				// Toggle auto-save
-				if m.preferences != nil {
-					m.preferences.AutoSave = !m.preferences.AutoSave
-					config.SavePreferences(m.preferences)
-					status := "disabled"
-					if m.preferences.AutoSave {
-						status = "enabled"
-					}
-					m.statusMessage = fmt.Sprintf("Auto-save %s", status)
-					cmds = append(cmds, clearStatusAfterDelay())
+			case "esc":
+				if m.focusedMessage >= 0 {
+					m.clearFocus()
+					m.updateViewportContent()
+					m.statusMessage = ""
 				}
-			case "alt+e":
-				// Edit mode - Alt+E won't interfere with normal typing
-				if len(m.chatMessages) > 0 {
-					m.appState = stateEditMessage
-					m.selectedMessage = len(m.chatMessages) - 1 // Start at last message
-					m.statusMessage = "Edit mode: ↑/↓ to select, Enter to edit, Esc to cancel"
-				}
-			case "ctrl+p":
-				// Open template selector
-				m.appState = stateTemplateSelector
-				m.selectedTemplate = 0
-				m.statusMessage = "Template selector - Use arrows to navigate, Enter to apply, Esc to return"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+o":
-				// Open personality selector
-				m.appState = statePersonalitySelector
-				m.selectedPersonality = 0
-				m.statusMessage = "Personality selector - Use arrows to navigate, Enter to apply, Esc to return"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+f":
-				// Open search interface
-				m.appState = stateSearch
-				m.searchQuery = ""
-				m.searchResults = []chat.ChatMessage{}
-				m.selectedResult = 0
-				m.statusMessage = "Search chat history - Type to search, Enter to perform search, Esc to return"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+d":
-				// Cycle theme
-				m.cycleTheme()
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+g":
-				// Open retro theme selector
-				m.appState = stateRetroThemeSelector
-				m.selectedRetroTheme = 0
-				m.statusMessage = "Retro theme selector - Use arrows to navigate, Enter to apply, Esc to return"
-				cmds = append(cmds, clearStatusAfterDelay())
-			case "ctrl+r":
-				// Regenerate last response
-				if m.lastUserMessage != "" && !m.isThinking {
-					// Remove the last assistant message if there is one
-					if len(m.messages) > 0 && m.messages[len(m.messages)-1].Role == "assistant" {
-						m.messages = m.messages[:len(m.messages)-1]
-						if len(m.chatMessages) > 0 && m.chatMessages[len(m.chatMessages)-1].Role == "assistant" {
-							m.chatMessages = m.chatMessages[:len(m.chatMessages)-1]
-						}
-					}
-					
-					// Resend the last user message
-					m.isThinking = true
-					if m.currentPersonality != nil {
-						m.loadingMessage = GetPersonalityThinkingMessage(m.currentPersonality)
-					} else {
-						m.loadingMessage = loadingMessages[time.Now().UnixNano()%int64(len(loadingMessages))]
-					}
-					m.statusMessage = "Regenerating response..."
-					cmds = append(cmds, sendToAI(m, m.lastUserMessage), m.spinner.Tick)
-					cmds = append(cmds, clearStatusAfterDelay())
-				}
-			case "enter", "shift+enter":
-				value := m.textInput.Value()
-				if value != "" {
-					// Check if we're editing a message
-					if m.editingMessageIndex >= 0 {
-						// Truncate conversation at edit point and regenerate
-						m.truncateAndRegenerate(m.editingMessageIndex, value)
-						m.editingMessageIndex = -1 // Reset editing
-						m.textInput.Reset()
-						cmds = append(cmds, sendToAI(m, value), m.spinner.Tick)
-						return m, tea.Batch(cmds...)
-					}
-					
-					// Check for commands
-					switch strings.ToLower(strings.TrimSpace(value)) {
-					case "/clear":
-						m.clearConversation()
-						m.statusMessage = "Conversation cleared"
-						m.textInput.Reset()
-						cmds = append(cmds, clearStatusAfterDelay())
-						return m, tea.Batch(cmds...)
-					case "/help":
-						// Show help as a system message
-						helpText := `Available commands:
-/clear - Clear the conversation
-/help - Show this help message
-
-Keyboard shortcuts:
-↑/↓ - Scroll chat or navigate message history
-PgUp/PgDn - Scroll by page
-Home/End - Jump to top/bottom
-e - Edit mode (edit any user message)
-Ctrl+R - Regenerate last response
-Ctrl+L - Clear conversation
-Ctrl+M - Switch AI model
-Ctrl+S - Save chat
-Ctrl+E - Export to markdown
-Ctrl+T - Show token usage
-Ctrl+Y - Copy last response
-Ctrl+B - Browse saved chats
-Ctrl+P - Select AI templates
-Ctrl+F - Search chat history
-Ctrl+D - Change theme
-Ctrl+A - Toggle auto-save
-Ctrl+C/Q - Quit`
-						m.addChatMessage("system", helpText)
-						m.textInput.Reset()
-						m.updateViewportContent()
-						return m, tea.Batch(cmds...)
-					default:
-						// Normal message processing
-						// Add to message history
-						m.messageHistory = append(m.messageHistory, value)
-						m.historyIndex = len(m.messageHistory) // Reset history navigation
-						m.lastUserMessage = value // Save for potential regeneration
-						
-						m.addChatMessage("user", value)
-						m.isThinking = true
-						// Select a random loading message based on personality
-						if m.currentPersonality != nil {
-							m.loadingMessage = GetPersonalityThinkingMessage(m.currentPersonality)
-						} else {
-							m.loadingMessage = loadingMessages[time.Now().UnixNano()%int64(len(loadingMessages))]
-						}
-						cmds = append(cmds, sendToAI(m, value), m.spinner.Tick)
-						m.textInput.Reset()
-					}
+			case "home":
+				m.viewport.GotoTop()
+			case "end":
+				m.viewport.GotoBottom()
+			case "ctrl+x":
+				// First half of a chord
+				m.ctrlXPending = true
+				m.statusMessage = "Ctrl+X - press Ctrl+E to write the message in $EDITOR"
+			case "enter":
+				if m.composer.Value() != "" {
+					cmds = append(cmds, m.submitInput(m.composer.Value()))
 				}
 			default:
 				// Filter out unhandled escape sequences
@@ -1572,7 +1517,8 @@ Ctrl+C/Q - Quit`
 					break
 				}
 				
-				m.textInput, cmd = m.textInput.Update(msg)
+				m.composer, cmd = m.composer.Update(msg)
+				m.resizeComposer()
 				cmds = append(cmds, cmd)
 			}
 		case errMsg:
@@ -1589,7 +1535,7 @@ Ctrl+C/Q - Quit`
 			m.addChatMessage("assistant", "")
 			
 			// Track tokens
-			m.updateTokenUsage(msg.PromptTokens, msg.CompletionTokens)
+			m.updateTokenUsage(m.currentModel, msg.PromptTokens, msg.CompletionTokens)
 			
 			// Start typing animation
 			cmds = append(cmds, typingTick())
@@ -1598,9 +1544,13 @@ Ctrl+C/Q - Quit`
 			cmds = append(cmds, cmd)
 		case tea.WindowSizeMsg:
 			m.viewport.Width = msg.Width
-			m.viewport.Height = msg.Height - viewportHeightOffset
-			m.updateViewportContent()
-			m.textInput.Width = msg.Width - textInputWidthOffset
+			m.viewport.Height = max(msg.Height-viewportHeightOffset-(m.composer.Height()-1), 1)
+			if m.focusedMessage >= 0 {
+				m.scrollToMessage(m.focusedMessage)
+			} else {
+				m.updateViewportContent()
+			}
+			m.composer.SetWidth(msg.Width - textInputWidthOffset)
 		case clearStatusMsg:
 			m.statusMessage = ""
 		case typingTickMsg:
@@ -1629,6 +1579,16 @@ Ctrl+C/Q - Quit`
 				} else {
 					// Typing complete
 					m.isTyping = false
+					m.recordConversation()
+					m.updateViewportContent() // Show the reply's alternatives
+					if turns := m.autoCheckpointTurns(); turns > 0 && m.replyCount()%turns == 0 {
+						m.autoCheckpoint(fmt.Sprintf("Automatic checkpoint after %d replies", m.replyCount()))
+					}
+
+					// Name the conversation after its first exchange
+					if m.needsTitle() {
+						cmds = append(cmds, m.requestTitle())
+					}
 					
 					// Auto-save check
 					m.messagesSinceLastSave++
@@ -1649,7 +1609,7 @@ Ctrl+C/Q - Quit`
 			cmds = append(cmds, cmd)
 			
 			// Update text input
-			m.textInput, cmd = m.textInput.Update(msg)
+			m.composer, cmd = m.composer.Update(msg)
 			cmds = append(cmds, cmd)
 		}
 	}
@@ -1657,6 +1617,67 @@ Ctrl+C/Q - Quit`
 	return m, tea.Batch(cmds...)
 }
 
+// submitInput runs a command typed into the composer, or sends it as a
+// message.
+func (m *model) submitInput(value string) tea.Cmd {
+	var cmds []tea.Cmd
+
+	// Check if we're editing a message
+	if m.editingMessageIndex >= 0 {
+		// Truncate conversation at edit point and regenerate
+		m.truncateAndRegenerate(m.editingMessageIndex, value)
+		m.editingMessageIndex = -1 // Reset editing
+		m.resetComposer()
+		cmds = append(cmds, sendToAI(*m, value), m.spinner.Tick)
+		return tea.Batch(cmds...)
+	}
+
+	// /title renames the conversation; on its own it generates a new title
+	if command := strings.TrimSpace(value); strings.EqualFold(command, "/title") || strings.HasPrefix(strings.ToLower(command), "/title ") {
+		cmds = append(cmds, m.setTitle(command[len("/title"):]))
+		m.resetComposer()
+		return tea.Batch(cmds...)
+	}
+
+	// /import loads a ChatGPT or Claude.ai data export
+	if command := strings.TrimSpace(value); strings.EqualFold(command, "/import") || strings.HasPrefix(strings.ToLower(command), "/import ") {
+		if path := strings.TrimSpace(command[len("/import"):]); path == "" {
+			m.statusMessage = "Usage: /import <export.zip or conversations.json>"
+		} else {
+			m.statusMessage = "Importing..."
+			cmds = append(cmds, importExport(path))
+		}
+		m.resetComposer()
+		return tea.Batch(cmds...)
+	}
+
+	// Slash commands run chat actions
+	if action := findCommand(value); action != nil {
+		m.resetComposer()
+		return action.Run(m)
+	}
+
+	// Normal message processing
+	// Add to message history
+	m.messageHistory = append(m.messageHistory, value)
+	m.historyIndex = len(m.messageHistory) // Reset history navigation
+	m.lastUserMessage = value // Save for potential regeneration
+
+	m.addChatMessage("user", value)
+	m.clearFocus()
+	m.updateViewportContent()
+	m.isThinking = true
+	// Select a random loading message based on personality
+	if m.currentPersonality != nil {
+		m.loadingMessage = GetPersonalityThinkingMessage(m.currentPersonality)
+	} else {
+		m.loadingMessage = loadingMessages[time.Now().UnixNano()%int64(len(loadingMessages))]
+	}
+	cmds = append(cmds, sendToAI(*m, value), m.spinner.Tick)
+	m.resetComposer()
+	return tea.Batch(cmds...)
+}
+
 // getOnboardingPrompt returns the appropriate prompt for the current onboarding step.
 func (m model) getOnboardingPrompt() string {
 	switch m.onboardingStep {
@@ -1676,36 +1697,7 @@ func (m model) View() string {
 		return fmt.Sprintf("%s%s", m.getOnboardingPrompt(), m.textInput.View())
 
 	case stateChatBrowser:
-		s := lipgloss.NewStyle().Bold(true).Render("📁 Chat Browser") + "\n\n"
-
-		if len(m.savedChats) == 0 {
-			s += "No saved chats found.\n\n"
-		} else {
-			for i, chat := range m.savedChats {
-				style := lipgloss.NewStyle()
-				if i == m.selectedChat {
-					style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
-				}
-
-				// Format filename for display
-				displayName := strings.TrimSuffix(chat, ".json")
-				displayName = strings.Replace(displayName, "chat_", "", 1)
-				displayName = strings.Replace(displayName, "_", " ", -1)
-
-				s += style.Render(fmt.Sprintf("  %s", displayName)) + "\n"
-			}
-		}
-
-		s += "\n"
-		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
-		s += helpStyle.Render("↑↓: Navigate | Enter: Load | R: Refresh | Esc: Back") + "\n"
-
-		if m.statusMessage != "" {
-			statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
-			s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
-		}
-
-		return s
+		return m.renderChatBrowser()
 
 	case stateTemplateSelector:
 		s := lipgloss.NewStyle().Bold(true).Render("🎭 System Prompt Templates") + "\n\n"
@@ -1750,29 +1742,31 @@ func (m model) View() string {
 					style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
 				}
 
-				// Format the message preview
-				preview := result.Content
-				if len(preview) > 80 {
-					preview = preview[:77] + "..."
-				}
+				// Format the message preview with the matched terms highlighted
+				matchStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.currentTheme.AssistantMessage))
+				preview := result.MarkSnippet(func(match string) string {
+					return matchStyle.Render(match)
+				})
 
 				roleStyle := lipgloss.NewStyle().Bold(true)
 				var role string
-				if result.Role == "user" {
+				if result.Message.Role == "user" {
 					role = "You"
 				} else {
 					role = "AI"
 				}
 
-				timeStr := result.Timestamp.Format("2006-01-02 15:04")
+				timeStr := result.Message.Timestamp.Format("2006-01-02 15:04")
 
-				s += style.Render(fmt.Sprintf("  [%s] %s: %s", timeStr, roleStyle.Render(role), preview)) + "\n"
+				line := fmt.Sprintf("  [%s] %s: %s", timeStr, roleStyle.Render(role), preview)
+				s += style.Render(wrap.Truncate(line, max(m.viewport.Width, 40), "…")) + "\n"
 			}
 			s += "\n"
 		}
 
 		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
-		s += helpStyle.Render("Type to search | Enter: Search | ↑↓: Navigate results | Esc: Back") + "\n"
+		s += helpStyle.Render("Type to search | Enter: Search, then open result | ↑↓: Navigate results | Esc: Back") + "\n"
+		s += helpStyle.Render(`"exact phrase" | prefix* | role:user | model:gpt-4o | after:2025-01-01 | before:2025-02-01`) + "\n"
 
 		if m.statusMessage != "" {
 			statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
@@ -1781,83 +1775,27 @@ func (m model) View() string {
 
 		return s
 
-	case stateEditMessage:
-		s := lipgloss.NewStyle().Bold(true).Render("📝 Edit Message Mode") + "\n\n"
-		
-		// Show messages with selection highlight
-		for i, msg := range m.chatMessages {
-			if msg.Role == "system" {
-				continue // Skip system messages
-			}
-			
-			style := lipgloss.NewStyle()
-			if i == m.selectedMessage {
-				style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
-			}
-			
-			// Format message preview
-			preview := msg.Content
-			if len(preview) > 60 {
-				preview = preview[:57] + "..."
-			}
-			
-			roleStyle := lipgloss.NewStyle().Bold(true)
-			if msg.Role == "user" {
-				roleStyle = roleStyle.Foreground(lipgloss.Color(m.currentTheme.UserMessage))
-				s += style.Render(fmt.Sprintf("  [%d] %s: %s", i, roleStyle.Render("You"), preview)) + "\n"
-			} else {
-				roleStyle = roleStyle.Foreground(lipgloss.Color(m.currentTheme.AssistantMessage))
-				s += style.Render(fmt.Sprintf("  [%d] %s: %s", i, roleStyle.Render(m.buddyName), preview)) + "\n"
-			}
-		}
-		
-		s += "\n"
-		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
-		s += helpStyle.Render("↑/↓: Navigate | Enter: Edit selected message | Esc: Cancel") + "\n"
-		
-		if m.statusMessage != "" {
-			statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
-			s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
-		}
-		
-		return s
+	case stateSelectMessage:
+		return m.renderSelectMessage()
 
 	case stateBranchManager:
-		s := lipgloss.NewStyle().Bold(true).Render("🌿 Branch Manager") + "\n\n"
-		
-		if m.conversationTree == nil {
-			s += "No conversation tree loaded. Create a checkpoint first (Ctrl+K)\n"
-		} else {
-			s += fmt.Sprintf("Current branch: %s\n\n", m.conversationTree.GetCurrentBranch().Name)
-			
-			// Show checkpoints
-			s += lipgloss.NewStyle().Bold(true).Render("Checkpoints:") + "\n"
-			for i, checkpoint := range m.checkpoints {
-				style := lipgloss.NewStyle()
-				if i == m.selectedCheckpoint {
-					style = style.Background(lipgloss.Color(m.currentTheme.Background)).Foreground(lipgloss.Color(m.currentTheme.Highlight))
-				}
-				
-				timeStr := checkpoint.CreatedAt.Format("15:04")
-				s += style.Render(fmt.Sprintf("  [%s] %s", timeStr, checkpoint.Name)) + "\n"
-				if checkpoint.Description != "" {
-					s += style.Render(fmt.Sprintf("    %s", checkpoint.Description)) + "\n"
-				}
-			}
-			
-			s += "\n"
-		}
-		
-		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
-		s += helpStyle.Render("↑↓: Navigate | Enter: Load checkpoint | B: Create branch | S: Save tree | Esc: Back") + "\n"
-		
-		if m.statusMessage != "" {
-			statusStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
-			s += "\n" + statusStyle.Render(fmt.Sprintf("Status: %s", m.statusMessage))
-		}
-		
-		return s
-		
+		return m.renderBranchManager()
+
+	case stateBranchDiff:
+		return m.renderBranchDiff()
+
+	case stateTreeBrowser:
+		return m.renderTreeBrowser()
+
+	case stateCherryPick:
+		return m.renderCherryPick()
+
+	case stateCodeBlocks:
+		return m.renderCodeBlocks()
+
+	case statePalette:
+		return m.renderPalette()
+
 	case stateCreateCheckpoint:
 		s := lipgloss.NewStyle().Bold(true).Render("💾 Create Checkpoint") + "\n\n"
 		
@@ -1934,7 +1872,8 @@ func (m model) View() string {
 		return s
 
 	case stateChatting:
-		m.updateViewportContent()
+		// Refresh the content without moving the scroll position
+		m.viewport.SetContent(m.buildChatContent())
 		s := m.viewport.View() + "\n"
 
 		if m.isThinking {
@@ -1962,14 +1901,14 @@ func (m model) View() string {
 
 		// Add keyboard shortcuts help (split into four lines for readability)
 		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.Status)).Italic(true)
-		s += helpStyle.Render("↑/↓/PgUp/PgDn: Scroll | Home/End: Top/Bottom | Ctrl+R: Regenerate | Alt+E: Edit") + "\n"
-		s += helpStyle.Render("Ctrl+L: Clear | Ctrl+M: Model | Ctrl+S: Save | Ctrl+E: Export | Ctrl+T: Tokens | Ctrl+Y: Copy") + "\n"
-		s += helpStyle.Render("Ctrl+B: Browse | Ctrl+P: Templates | Ctrl+O: Personality | Ctrl+F: Search") + "\n"
-		s += helpStyle.Render("Ctrl+D: Theme | Ctrl+G: Retro | Ctrl+K: Checkpoint | Ctrl+H: Branches | Ctrl+A: Auto-save | Ctrl+C: Quit") + "\n"
+		s += helpStyle.Render("↑/↓/PgUp/PgDn: Scroll | Home/End: Top/Bottom | Ctrl+R: Regenerate | Alt+,/Alt+.: Alternatives | Alt+E: Select message") + "\n"
+		s += helpStyle.Render("Ctrl+L: Clear | Alt+M: Model | Ctrl+S: Save | Ctrl+E: Export | Ctrl+T: Tokens | Ctrl+Y: Copy | Ctrl+V: Paste | Alt+C: Code blocks") + "\n"
+		s += helpStyle.Render("Ctrl+B: Browse | Ctrl+P: Templates | Ctrl+O: Personality | Ctrl+F: Search | Alt+Enter: New line | Ctrl+X Ctrl+E: $EDITOR") + "\n"
+		s += helpStyle.Render("Ctrl+D: Theme | Ctrl+G: Retro | Ctrl+K: Checkpoint | Alt+H: Branches | : Commands | Ctrl+A: Auto-save | Ctrl+C: Quit") + "\n"
 
 		// Add input line with token counter
 		inputLabel := "Your message: "
-		currentText := m.textInput.Value()
+		currentText := m.composer.Value()
 		tokenCount := estimateTokens(currentText)
 		
 		// Show token count if there's text
@@ -1978,12 +1917,9 @@ func (m model) View() string {
 			inputLabel += tokenStyle.Render(fmt.Sprintf("[~%d tokens] ", tokenCount))
 		}
 		
-		// Create wrapped version of input for display
 		labelStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.currentTheme.InputLabel))
-		s += "\n" + labelStyle.Render(inputLabel)
-		
-		// For now, just show the standard input (wrapping will be handled by terminal)
-		s += m.textInput.View()
+		s += "\n" + labelStyle.Render(inputLabel) + "\n"
+		s += m.composer.View()
 
 		if m.error != nil {
 			s += fmt.Sprintf("\nError: %v", m.error)
@@ -2028,40 +1964,6 @@ func estimateTokens(text string) int {
 	return len(text) / 4
 }
 
-// wrapText wraps text to fit within the specified width
-func wrapText(text string, width int) string {
-	if len(text) <= width {
-		return text
-	}
-	
-	var lines []string
-	for len(text) > 0 {
-		if len(text) <= width {
-			lines = append(lines, text)
-			break
-		}
-		
-		// Find a good break point (space)
-		breakPoint := width
-		for i := width; i > width*2/3; i-- {
-			if text[i-1] == ' ' {
-				breakPoint = i
-				break
-			}
-		}
-		
-		lines = append(lines, text[:breakPoint])
-		text = text[breakPoint:]
-		
-		// Skip leading space on next line
-		if len(text) > 0 && text[0] == ' ' {
-			text = text[1:]
-		}
-	}
-	
-	return strings.Join(lines, "\n")
-}
-
 // sendToOpenAI sends a prompt to OpenAI and tracks token usage.
 func sendToAI(m model, prompt string) tea.Cmd {
 	return func() tea.Msg {
@@ -2076,7 +1978,9 @@ func sendToAI(m model, prompt string) tea.Cmd {
 			}
 		}
 		
-		response, err := m.client.SendMessage(m.currentModel, conversationMessages, systemPrompt)
+		systemPrompt = m.pinnedContext(systemPrompt)
+
+		response, err := m.client.SendMessageWithOptions(m.currentModel, conversationMessages, systemPrompt, m.generation)
 		if err != nil {
 			return errMsg(fmt.Errorf("failed to create chat completion: %w", err))
 		}
@@ -2093,8 +1997,16 @@ func sendToAI(m model, prompt string) tea.Cmd {
 }
 
 // Start begins the TUI application.
-func Start(client *ai.UnifiedClient) {
-	p := tea.NewProgram(initialModel(client))
+func Start(client *ai.UnifiedClient, opts Options) {
+	m := initialModel(client)
+	if opts.Resume {
+		if err := m.resume(opts); err != nil {
+			fmt.Printf("Couldn't resume conversation: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	p := tea.NewProgram(m)
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Alas, there's been an error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,433 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+
+	"lil_guy/internal/chat"
+	"lil_guy/internal/wrap"
+)
+
+func TestSaveAfterClearStartsNewTree(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	m := initialModel(nil)
+	m.addChatMessage("user", "First question")
+	m.addChatMessage("assistant", "First answer")
+	m.recordConversation()
+	if err := m.saveCurrentChat(); err != nil {
+		t.Fatalf("saveCurrentChat() failed: %v", err)
+	}
+
+	m.clearConversation()
+
+	// Regenerate the reply so the new chat saves its tree
+	m.addChatMessage("user", "Second question")
+	m.addChatMessage("assistant", "Second answer")
+	m.recordConversation()
+	m.chatMessages[len(m.chatMessages)-1].Content = "Another second answer"
+	m.recordConversation()
+	if err := m.saveCurrentChat(); err != nil {
+		t.Fatalf("saveCurrentChat() failed: %v", err)
+	}
+
+	history, err := chat.LoadChat(m.chatID)
+	if err != nil {
+		t.Fatalf("LoadChat() failed: %v", err)
+	}
+	if history.Tree == nil {
+		t.Fatal("new chat saved no tree, want the regenerated reply kept")
+	}
+	want := map[string]bool{"Second question": true, "Second answer": true, "Another second answer": true}
+	for _, node := range history.Tree.Nodes {
+		if !want[node.Message.Content] {
+			t.Errorf("new chat's tree holds %q from the cleared conversation", node.Message.Content)
+		}
+	}
+	if len(history.Tree.Nodes) != len(want) {
+		t.Errorf("new chat's tree has %d nodes, want %d", len(history.Tree.Nodes), len(want))
+	}
+}
+
+func TestActionLookup(t *testing.T) {
+	keys := map[string]string{
+		"ctrl+r":        "Regenerate last response",
+		"alt+m":         "Switch to the next model",
+		"alt+h":         "Branch manager",
+		"ctrl+e":        "Export to markdown",
+		"ctrl+x ctrl+e": "Write the message in $EDITOR",
+	}
+	for key, name := range keys {
+		if action := findAction(key); action == nil || action.Name != name {
+			t.Errorf("findAction(%q) = %+v, want %q", key, action, name)
+		}
+	}
+
+	// Terminals send Ctrl+M and Ctrl+H as Enter and Backspace, and Ctrl+X
+	// only starts a chord
+	for _, key := range []string{"ctrl+m", "ctrl+h", "ctrl+x", "x"} {
+		if action := findAction(key); action != nil {
+			t.Errorf("findAction(%q) = %q, want nothing", key, action.Name)
+		}
+	}
+
+	commands := map[string]string{
+		"/clear":       "Clear conversation",
+		" /CLEAR ":     "Clear conversation",
+		"/export html": "Export as an HTML page",
+		"/code":        "Code blocks: copy, save or run",
+		"/help":        "Help",
+	}
+	for command, name := range commands {
+		if action := findCommand(command); action == nil || action.Name != name {
+			t.Errorf("findCommand(%q) = %+v, want %q", command, action, name)
+		}
+	}
+	if action := findCommand("/nope"); action != nil {
+		t.Errorf("findCommand(/nope) = %q, want nothing", action.Name)
+	}
+
+	// Every key and command runs one action
+	seen := map[string]string{}
+	for _, action := range chatActions() {
+		for _, key := range append(action.Keys, action.Command) {
+			if key == "" {
+				continue
+			}
+			if other, ok := seen[key]; ok {
+				t.Errorf("%q runs both %q and %q", key, other, action.Name)
+			}
+			seen[key] = action.Name
+		}
+	}
+}
+
+func TestActionBindings(t *testing.T) {
+	labels := map[string]string{
+		"ctrl+x ctrl+e": "Ctrl+X Ctrl+E",
+		"alt+,":         "Alt+,",
+		"ctrl+c":        "Ctrl+C",
+	}
+	for key, want := range labels {
+		if got := keyLabel(key); got != want {
+			t.Errorf("keyLabel(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	if got := findAction("alt+c").binding(); got != "Alt+C" {
+		t.Errorf("binding of an action with a key and a command = %q, want the key", got)
+	}
+	if got := findCommand("/trees").binding(); got != "/trees" {
+		t.Errorf("binding of a command-only action = %q, want /trees", got)
+	}
+}
+
+func TestChatKeysRunActions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	m := initialModel(nil)
+	m.appState = stateChatting
+	m.addChatMessage("user", "Hello")
+
+	// The chord waits for its second key instead of running Ctrl+X alone
+	next, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlX})
+	m = next.(model)
+	if !m.ctrlXPending {
+		t.Fatal("Ctrl+X didn't start a chord")
+	}
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlL})
+	m = next.(model)
+	if m.ctrlXPending {
+		t.Error("chord still pending after its second key")
+	}
+	if len(m.chatMessages) != 0 {
+		t.Errorf("Ctrl+X Ctrl+L has no chord, so Ctrl+L should clear; %d messages left", len(m.chatMessages))
+	}
+
+	m.addChatMessage("user", "Hello again")
+	m.submitInput("/clear")
+	if len(m.chatMessages) != 0 {
+		t.Errorf("/clear left %d messages", len(m.chatMessages))
+	}
+
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(":")})
+	if m = next.(model); m.appState != statePalette {
+		t.Errorf(": in an empty message opened state %v, want the palette", m.appState)
+	}
+}
+
+func TestCodeBlockFilenames(t *testing.T) {
+	reply := "Here you go:\n\n" +
+		"```go title=\"x.go\"\npackage main\n```\n\n" +
+		"```python\n// file: x.py\nprint('hi')\n```\n\n" +
+		"```rust\nfn main() {}\n```\n\n" +
+		"```\nplain text\n```\n"
+
+	blocks := codeBlocks(reply)
+	if len(blocks) != 4 {
+		t.Fatalf("codeBlocks() found %d blocks, want 4", len(blocks))
+	}
+	if got := countCodeBlocks(reply); got != 4 {
+		t.Errorf("countCodeBlocks() = %d, want 4", got)
+	}
+
+	tests := []struct {
+		language, filename, suggested string
+	}{
+		{"go", "x.go", "x.go"},     // Fence title
+		{"python", "x.py", "x.py"}, // First-line comment
+		{"rust", "", "block_3.rs"}, // No hint, extension from the language
+		{"", "", "block_4.txt"},    // No hint or language
+	}
+	for i, tt := range tests {
+		block := blocks[i]
+		if block.Language != tt.language || block.Filename != tt.filename {
+			t.Errorf("block %d = %q %q, want %q %q", i+1, block.Language, block.Filename, tt.language, tt.filename)
+		}
+		if got := defaultBlockFilename(i+1, block); got != tt.suggested {
+			t.Errorf("defaultBlockFilename(%d) = %q, want %q", i+1, got, tt.suggested)
+		}
+	}
+}
+
+func TestSearchMatchKeysDontEatTyping(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	m := initialModel(nil)
+	m.appState = stateChatting
+	m.addChatMessage("user", "first match")
+	m.addChatMessage("assistant", "second match")
+	m.focusMatches = []int{0, 1}
+	m.focusedMessage = 0
+
+	next, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	m = next.(model)
+	if m.composer.Value() != "n" || m.focusMatchIndex != 0 {
+		t.Errorf("typing n gave message %q and match %d, want n typed and the match kept", m.composer.Value(), m.focusMatchIndex)
+	}
+
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n"), Alt: true})
+	m = next.(model)
+	if m.focusMatchIndex != 1 || m.focusedMessage != 1 {
+		t.Errorf("Alt+N moved to match %d, want 1", m.focusMatchIndex)
+	}
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("N"), Alt: true})
+	if m = next.(model); m.focusMatchIndex != 0 {
+		t.Errorf("Alt+Shift+N moved to match %d, want 0", m.focusMatchIndex)
+	}
+}
+
+func TestContinuedImportIsNotImportedAgain(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	export := filepath.Join(home, "claude.json")
+	os.WriteFile(export, []byte(`[
+	  {"uuid": "k1", "name": "Sourdough", "created_at": "2024-03-01T10:00:00Z", "updated_at": "2024-03-01T10:05:00Z",
+	   "chat_messages": [
+	     {"uuid": "m1", "sender": "human", "text": "How do I feed a starter?", "created_at": "2024-03-01T10:00:00Z"},
+	     {"uuid": "m2", "sender": "assistant", "text": "Equal parts flour and water.", "created_at": "2024-03-01T10:01:00Z"}
+	   ]}
+	]`), 0644)
+	if stats, err := chat.ImportConversations(export); err != nil || stats.Chats != 1 {
+		t.Fatalf("ImportConversations() = %+v, %v", stats, err)
+	}
+	summaries, err := chat.ListChatSummaries()
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("ListChatSummaries() = %+v, %v", summaries, err)
+	}
+
+	// Continue the imported chat and save it from the TUI
+	m := initialModel(nil)
+	if err := m.loadChatHistory(summaries[0].Filename); err != nil {
+		t.Fatalf("loadChatHistory() failed: %v", err)
+	}
+	m.addChatMessage("user", "And how often?")
+	if err := m.saveCurrentChat(); err != nil {
+		t.Fatalf("saveCurrentChat() failed: %v", err)
+	}
+
+	stats, err := chat.ImportConversations(export)
+	if err != nil || stats.Chats != 0 || stats.Skipped != 1 {
+		t.Errorf("re-import = %+v, %v; want the continued chat skipped", stats, err)
+	}
+	if summaries, _ := chat.ListChatSummaries(); len(summaries) != 1 {
+		t.Errorf("re-import left %d chats, want 1", len(summaries))
+	}
+}
+
+// markdownSample uses most of GFM, with code blocks fenced, indented and
+// inside a blockquote.
+const markdownSample = `# Packing list
+
+Bring **layers** and a _rain jacket_; the weather changes quickly and a very long sentence like this one has to wrap.
+
+| Item | Why | Weight |
+|:-----|:---:|-------:|
+| Wool base layer that keeps you warm when wet | Warmth | 200g |
+| Shell | Rain | 400g |
+
+- [x] Passport
+- [ ] Adapter
+  - Type F
+
+1. Tight
+2. List
+
+` + "```go title=\"pack.go\"\npackage main\n```" + `
+
+    indented code block
+
+> Quoted:
+>
+> ` + "```sh\n> echo hi\n> ```" + `
+`
+
+func TestRenderMarkdown(t *testing.T) {
+	const width = 50
+	rendered := ansi.Strip(renderMarkdown(markdownSample, width, themes["default"], false, 0))
+
+	for _, line := range strings.Split(rendered, "\n") {
+		if wrap.Width(line) > width {
+			t.Errorf("line is %d cells wide, want at most %d: %q", wrap.Width(line), width, line)
+		}
+	}
+	for _, marker := range []string{"│ Item", "├──", "│ Wool base", "☑ Passport", "☐ Adapter", "  • Type F", "1. Tight", "│ Quoted:"} {
+		if !strings.Contains(rendered, marker) {
+			t.Errorf("rendered markdown is missing %q:\n%s", marker, rendered)
+		}
+	}
+}
+
+// blockLabel matches the [N] label and language on a code block's box.
+var blockLabel = regexp.MustCompile(`\[(\d+)\] (\S+)`)
+
+func TestCodeBlockLabelsMatchPicker(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	m := initialModel(nil)
+	m.addChatMessage("user", "Pack for me")
+	m.addChatMessage("assistant", markdownSample)
+	m.addChatMessage("user", "And a script?")
+	m.addChatMessage("assistant", "```python\nprint('ok')\n```")
+
+	blocks := m.conversationCodeBlocks()
+	if len(blocks) != 4 {
+		t.Fatalf("conversationCodeBlocks() found %d blocks, want 4", len(blocks))
+	}
+
+	content, _ := m.renderChat()
+	labels := blockLabel.FindAllStringSubmatch(ansi.Strip(content), -1)
+	if len(labels) != len(blocks) {
+		t.Fatalf("chat shows %d block labels, want %d", len(labels), len(blocks))
+	}
+	for i, label := range labels {
+		number, _ := strconv.Atoi(label[1])
+		want := blocks[i].Language
+		if want == "" {
+			want = "code"
+		}
+		if number != i+1 || label[2] != want {
+			t.Errorf("label %d is [%d] %s, want [%d] %s", i, number, label[2], i+1, want)
+		}
+	}
+}
+
+// treeHolds reports whether any version of the conversation has content.
+func treeHolds(tree *chat.ConversationTree, content string) bool {
+	for _, node := range tree.Nodes {
+		if node.Message.Content == content {
+			return true
+		}
+	}
+	return false
+}
+
+// selectModel returns a model with a two-exchange conversation recorded in
+// its tree, in message-select mode.
+func selectModel(t *testing.T) model {
+	t.Setenv("HOME", t.TempDir())
+
+	m := initialModel(nil)
+	m.appState = stateChatting
+	m.addChatMessage("user", "Name a colour")
+	m.addChatMessage("assistant", "Red")
+	m.addChatMessage("user", "Another")
+	m.addChatMessage("assistant", "Blue")
+	m.recordConversation()
+	m.openSelectMessage()
+	return m
+}
+
+func TestSelectDeleteKeepsOldVersion(t *testing.T) {
+	m := selectModel(t)
+
+	m.selectedMessage = 3
+	m.updateSelectMessage(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	m.updateSelectMessage(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+
+	if len(m.chatMessages) != 3 || m.chatMessages[2].Content != "Another" {
+		t.Fatalf("after deleting Blue the chat has %d messages", len(m.chatMessages))
+	}
+	if !treeHolds(m.conversationTree, "Blue") {
+		t.Error("deleted message is gone from the conversation tree")
+	}
+	if head := m.conversationTree.Messages(m.conversationTree.Head()); len(head) != 3 {
+		t.Errorf("tree head has %d messages, want the 3 left", len(head))
+	}
+}
+
+func TestSelectRegenerateAndBranch(t *testing.T) {
+	m := selectModel(t)
+
+	// Regenerating from a reply drops it and asks again
+	if cmd := m.regenerateFrom(1); cmd == nil || !m.isThinking {
+		t.Fatal("regenerateFrom() didn't send the question again")
+	}
+	if len(m.chatMessages) != 1 || m.chatMessages[0].Content != "Name a colour" {
+		t.Errorf("after regenerating from Red the chat is %+v", m.chatMessages)
+	}
+	for _, content := range []string{"Red", "Another", "Blue"} {
+		if !treeHolds(m.conversationTree, content) {
+			t.Errorf("regenerating lost %q from the conversation tree", content)
+		}
+	}
+
+	// Nothing else changes while a reply is on its way
+	m.openSelectMessage()
+	m.selectedMessage = 0
+	m.updateSelectMessage(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	if m.editingMessageIndex != -1 {
+		t.Error("edit started while a reply is arriving")
+	}
+	m.updateSelectMessage(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	if m.confirmDeleteMessage {
+		t.Error("delete asked for confirmation while a reply is arriving")
+	}
+
+	// Branching at the question keeps the rest on the old branch
+	m = selectModel(t)
+	original := m.conversationTree.CurrentBranch
+	m.branchFromMessage(0)
+	if m.conversationTree.CurrentBranch == original || len(m.chatMessages) != 1 {
+		t.Errorf("branchFromMessage() left branch %s with %d messages", m.conversationTree.CurrentBranch, len(m.chatMessages))
+	}
+	if messages := m.conversationTree.Messages(m.conversationTree.Branches[original].Head); len(messages) != 4 {
+		t.Errorf("old branch has %d messages, want all 4", len(messages))
+	}
+
+	// Editing is allowed once the reply is in
+	m.openSelectMessage()
+	m.selectedMessage = 0
+	m.updateSelectMessage(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	if m.editingMessageIndex != 0 || m.composer.Value() != "Name a colour" {
+		t.Errorf("edit = index %d, composer %q", m.editingMessageIndex, m.composer.Value())
+	}
+}
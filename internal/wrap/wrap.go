@@ -0,0 +1,47 @@
+package wrap
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// Width returns how many terminal cells text takes. Escape codes take none,
+// and wide characters such as emoji and CJK take two.
+func Width(s string) int {
+	return ansi.StringWidth(s)
+}
+
+// Truncate shortens text to at most width cells, ending it with tail if it
+// was cut. It never splits an escape code or a grapheme.
+func Truncate(s string, width int, tail string) string {
+	if width <= 0 {
+		return ""
+	}
+	if Width(s) <= width {
+		return s
+	}
+	if Width(tail) >= width {
+		return ansi.Truncate(s, width, "")
+	}
+	return ansi.Truncate(s, width, tail)
+}
+
+// Wrap word-wraps text to width cells, breaking words longer than a line.
+// Styled text keeps its escape codes, and existing line breaks are kept.
+func Wrap(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	lines := strings.Split(ansi.Wrap(s, width, ""), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Pad fills text with spaces on the right to width cells, for aligning
+// columns. Text wider than width is returned unchanged.
+func Pad(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-Width(s)))
+}
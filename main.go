@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -8,6 +9,9 @@ import (
 	"github.com/joho/godotenv"
 
 	"lil_guy/internal/ai"
+	"lil_guy/internal/chat"
+	"lil_guy/internal/cli"
+	"lil_guy/internal/config"
 	"lil_guy/internal/tui"
 )
 
@@ -16,6 +20,35 @@ func main() {
 		log.Printf("Error loading .env file: %v", err)
 	}
 
+	// Chats are kept in JSON files unless the preferences pick the database
+	prefs, err := config.LoadPreferences()
+	if err != nil {
+		prefs = &config.Preferences{}
+	}
+	store, err := chat.OpenStore(prefs.Storage)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	chat.SetStore(store)
+	defer store.Close()
+
+	// Chat management subcommands work on saved chats and need no API key
+	if len(os.Args) > 1 && os.Args[1] == "chats" {
+		code := cli.RunChats(os.Args[2:], os.Stdout, os.Stderr)
+		store.Close()
+		os.Exit(code)
+	}
+
+	opts, err := cli.ParseStartArgs(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		if errors.Is(err, cli.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
 	// Check for at least one API key
 	openaiKey := os.Getenv("OPENAI_API_KEY")
 	claudeKey := os.Getenv("CLAUDE_API_KEY")
@@ -28,5 +61,5 @@ func main() {
 	}
 
 	client := ai.NewUnifiedClient()
-	tui.Start(client)
+	tui.Start(client, opts)
 }
\ No newline at end of file
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"lil_guy/internal/chat"
+	"lil_guy/internal/cli"
+	"lil_guy/internal/clipboard"
 	"lil_guy/internal/config"
+	"lil_guy/internal/wrap"
 )
 
 func TestGetPreferencesFilePath(t *testing.T) {
@@ -82,4 +92,922 @@ func TestSaveAndLoadPreferences(t *testing.T) {
 	if loadedPrefs.SystemMessage != testPrefs.SystemMessage {
 		t.Errorf("SystemMessage mismatch: got %s, want %s", loadedPrefs.SystemMessage, testPrefs.SystemMessage)
 	}
-}
\ No newline at end of file
+}
+
+func TestRunChatsSearchAndRemove(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	filename, err := chat.SaveChat(chat.ChatHistory{
+		BuddyName: "TestBuddy",
+		Model:     "gpt-4o",
+		Messages: []chat.ChatMessage{
+			{Role: "user", Content: "How do I reverse a slice?", Timestamp: time.Now()},
+			{Role: "assistant", Content: "Use slices.Reverse.", Timestamp: time.Now()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SaveChat() failed: %v", err)
+	}
+	id := chat.ChatIDFromFilename(filename)
+
+	var stdout, stderr bytes.Buffer
+	if code := cli.RunChats([]string{"search", "REVERSE", "--json"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("chats search exited with %d: %s", code, stderr.String())
+	}
+
+	var results []chat.SearchResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("chats search printed invalid JSON: %v", err)
+	}
+	if len(results) != 2 || results[0].ChatID != id {
+		t.Errorf("chats search returned %+v, want 2 results from %s", results, id)
+	}
+
+	stdout.Reset()
+	if code := cli.RunChats([]string{"rm", id, "--json"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("chats rm exited with %d: %s", code, stderr.String())
+	}
+	if _, err := chat.ResolveChat(id); err == nil {
+		t.Errorf("chat %s still exists after rm", id)
+	}
+}
+
+func TestParseStartArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		resume   bool
+		resumeID string
+	}{
+		{nil, false, ""},
+		{[]string{"--resume"}, true, ""}, // No ID resumes the latest chat
+		{[]string{"-r", "chat_2025-01-02_03-04-05"}, true, "chat_2025-01-02_03-04-05"},
+		{[]string{"--resume", "tree_2025-01-02:checkpoint_3"}, true, "tree_2025-01-02:checkpoint_3"},
+		{[]string{"--resume=checkpoint_3"}, true, "checkpoint_3"},
+	}
+	for _, tt := range tests {
+		opts, err := cli.ParseStartArgs(tt.args)
+		if err != nil || opts.Resume != tt.resume || opts.ResumeID != tt.resumeID {
+			t.Errorf("ParseStartArgs(%q) = %+v, %v; want resume %v, ID %q", tt.args, opts, err, tt.resume, tt.resumeID)
+		}
+	}
+
+	// A flag after --resume isn't taken as its ID
+	if _, err := cli.ParseStartArgs([]string{"--resume", "--help"}); err != cli.ErrHelp {
+		t.Errorf("--resume --help returned %v, want ErrHelp", err)
+	}
+	if _, err := cli.ParseStartArgs([]string{"-h"}); err != cli.ErrHelp {
+		t.Errorf("-h returned %v, want ErrHelp", err)
+	}
+	if _, err := cli.ParseStartArgs([]string{"--bogus"}); err == nil || err == cli.ErrHelp {
+		t.Errorf("unknown flag returned %v, want an error", err)
+	}
+}
+
+func TestZeroTemperatureIsKept(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	zero := 0.0
+	filename, err := chat.SaveChat(chat.ChatHistory{
+		Settings: &chat.GenerationSettings{Temperature: &zero},
+		Messages: []chat.ChatMessage{{Role: "user", Content: "Be deterministic", Timestamp: time.Now()}},
+	})
+	if err != nil {
+		t.Fatalf("SaveChat() failed: %v", err)
+	}
+	saved, err := chat.LoadChat(filename)
+	if err != nil {
+		t.Fatalf("LoadChat() failed: %v", err)
+	}
+	if saved.Settings == nil || saved.Settings.Temperature == nil || *saved.Settings.Temperature != 0 {
+		t.Errorf("saved temperature = %+v, want 0 rather than unset", saved.Settings)
+	}
+}
+
+func TestSaveChatUpdatesSameFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	history := chat.ChatHistory{
+		BuddyName: "TestBuddy",
+		Model:     "gpt-4o",
+		Messages:  []chat.ChatMessage{{Role: "user", Content: "Hello", Timestamp: time.Now()}},
+	}
+
+	filename, err := chat.SaveChat(history)
+	if err != nil {
+		t.Fatalf("SaveChat() failed: %v", err)
+	}
+	first, err := chat.LoadChat(filename)
+	if err != nil {
+		t.Fatalf("LoadChat() failed: %v", err)
+	}
+
+	// Save again the way the TUI does: same ID, no timestamps
+	history.ID = chat.ChatIDFromFilename(filename)
+	history.Messages = append(history.Messages, chat.ChatMessage{Role: "assistant", Content: "Hi!", Timestamp: time.Now()})
+	secondFilename, err := chat.SaveChat(history)
+	if err != nil {
+		t.Fatalf("second SaveChat() failed: %v", err)
+	}
+	if secondFilename != filename {
+		t.Errorf("second save wrote %s, want %s", secondFilename, filename)
+	}
+
+	chats, err := chat.ListChats()
+	if err != nil {
+		t.Fatalf("ListChats() failed: %v", err)
+	}
+	if len(chats) != 1 {
+		t.Errorf("ListChats() returned %v, want a single chat file", chats)
+	}
+
+	second, err := chat.LoadChat(filename)
+	if err != nil {
+		t.Fatalf("LoadChat() failed: %v", err)
+	}
+	if !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("CreatedAt changed from %v to %v", first.CreatedAt, second.CreatedAt)
+	}
+	if second.UpdatedAt.Before(first.UpdatedAt) {
+		t.Errorf("UpdatedAt went backwards from %v to %v", first.UpdatedAt, second.UpdatedAt)
+	}
+	if len(second.Messages) != 2 {
+		t.Errorf("saved chat has %d messages, want 2", len(second.Messages))
+	}
+}
+
+func TestSearchChatsRanksAndFilters(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	now := time.Now()
+	chats := []chat.ChatHistory{
+		{Model: "gpt-4o", Messages: []chat.ChatMessage{
+			{Role: "user", Content: "What is a reverse proxy?", Timestamp: now},
+			{Role: "assistant", Content: "A reverse proxy forwards requests. Reverse proxies also cache.", Timestamp: now},
+		}},
+		{Model: "gpt-4o", Messages: []chat.ChatMessage{
+			{Role: "user", Content: "How do I reverse a slice and then proxy it somewhere?", Timestamp: now},
+		}},
+	}
+	var ids []string
+	for _, history := range chats {
+		filename, err := chat.SaveChat(history)
+		if err != nil {
+			t.Fatalf("SaveChat() failed: %v", err)
+		}
+		ids = append(ids, chat.ChatIDFromFilename(filename))
+	}
+
+	results, err := chat.SearchChats(`"reverse proxy"`)
+	if err != nil {
+		t.Fatalf("SearchChats() failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("phrase search returned %d results, want 2", len(results))
+	}
+	for _, result := range results {
+		if result.ChatID != ids[0] {
+			t.Errorf("phrase search matched %s #%d, want only %s", result.ChatID, result.MessageIndex, ids[0])
+		}
+		if len(result.Highlights) == 0 {
+			t.Errorf("result %s #%d has no highlights in %q", result.ChatID, result.MessageIndex, result.Snippet)
+		}
+	}
+
+	results, err = chat.SearchChats("reverse role:assistant")
+	if err != nil {
+		t.Fatalf("SearchChats() failed: %v", err)
+	}
+	if len(results) != 1 || results[0].MessageIndex != 1 {
+		t.Errorf("role filter returned %+v, want the assistant message only", results)
+	}
+}
+
+func TestSearchIndexBatchImportAndRemove(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	// One import indexes every chat it adds
+	var jsonl strings.Builder
+	for _, word := range []string{"alpha", "bravo", "charlie"} {
+		fmt.Fprintf(&jsonl, `{"messages":[{"role":"user","content":"Tell me about %s and kubernetes"},{"role":"assistant","content":"Sure."}]}`+"\n", word)
+	}
+	if stats, err := chat.ImportJSONL(strings.NewReader(jsonl.String())); err != nil || stats.Chats != 3 {
+		t.Fatalf("ImportJSONL() = %+v, %v", stats, err)
+	}
+
+	results, err := chat.SearchChats("kubernetes")
+	if err != nil {
+		t.Fatalf("SearchChats() failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("search returned %d results, want one per imported chat", len(results))
+	}
+
+	results, err = chat.SearchChats("bravo")
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchChats(bravo) = %d results, %v", len(results), err)
+	}
+	if err := chat.DeleteChat(results[0].ChatID); err != nil {
+		t.Fatalf("DeleteChat() failed: %v", err)
+	}
+
+	// Removing a chat drops its terms but keeps the ones other chats share
+	if results, err := chat.SearchChats("bravo"); err != nil || len(results) != 0 {
+		t.Errorf("deleted chat still found: %d results, %v", len(results), err)
+	}
+	if results, err := chat.SearchChats("kubernetes"); err != nil || len(results) != 2 {
+		t.Errorf("search after delete returned %d results, want 2 (%v)", len(results), err)
+	}
+}
+
+func TestBoltStoreImportAndQuery(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	now := time.Now()
+	for _, history := range []chat.ChatHistory{
+		{Model: "gpt-4o", Tags: []string{"Work"}, Messages: []chat.ChatMessage{{Role: "user", Content: "Quarterly report", Timestamp: now}}},
+		{Model: "claude-3-5-sonnet", Messages: []chat.ChatMessage{{Role: "user", Content: "Weekend plans", Timestamp: now}}},
+	} {
+		if _, err := chat.SaveChat(history); err != nil {
+			t.Fatalf("SaveChat() failed: %v", err)
+		}
+	}
+
+	store, err := chat.OpenBoltStore(filepath.Join(tmpDir, "chats.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore() failed: %v", err)
+	}
+	defer store.Close()
+
+	stats, err := chat.ImportJSON(store)
+	if err != nil {
+		t.Fatalf("ImportJSON() failed: %v", err)
+	}
+	if stats.Chats != 2 {
+		t.Errorf("ImportJSON() imported %d chats, want 2", stats.Chats)
+	}
+	if stats, _ := chat.ImportJSON(store); stats.Chats != 0 {
+		t.Errorf("second ImportJSON() imported %d chats, want 0", stats.Chats)
+	}
+
+	work, err := store.ListChats(chat.ChatFilter{Tag: "work"})
+	if err != nil {
+		t.Fatalf("ListChats() failed: %v", err)
+	}
+	if len(work) != 1 || work[0].Model != "gpt-4o" {
+		t.Errorf("tag filter returned %+v, want the gpt-4o chat", work)
+	}
+
+	claude, _ := store.ListChats(chat.ChatFilter{Model: "claude"})
+	if len(claude) != 1 || claude[0].Model != "claude-3-5-sonnet" {
+		t.Errorf("model filter returned %+v, want the claude chat", claude)
+	}
+
+	future, _ := store.ListChats(chat.ChatFilter{After: now.Add(time.Hour)})
+	if len(future) != 0 {
+		t.Errorf("date filter returned %+v, want nothing", future)
+	}
+
+	// A failed transaction leaves nothing behind
+	store.Update(func(tx chat.Store) error {
+		tx.DeleteChat(work[0].ID)
+		return os.ErrInvalid
+	})
+	if _, err := store.LoadChat(work[0].ID); err != nil {
+		t.Errorf("chat deleted by a rolled back transaction: %v", err)
+	}
+
+	// The package-level functions work the same on the database
+	chat.SetStore(store)
+	defer chat.SetStore(chat.JSONStore{})
+
+	results, err := chat.SearchChats("quarterly")
+	if err != nil {
+		t.Fatalf("SearchChats() failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ChatID != work[0].ID {
+		t.Errorf("search on the database returned %+v", results)
+	}
+}
+
+func TestChatSummaryTitles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	now := time.Now()
+	chats := []chat.ChatHistory{
+		{Title: "Sourdough starter", Cost: 0.0125, Messages: []chat.ChatMessage{{Role: "user", Content: "Help me bake", Timestamp: now}}},
+		{Messages: []chat.ChatMessage{{Role: "user", Content: "  How do I\n reverse   a slice in Go without allocating a second slice?", Timestamp: now}}},
+	}
+	for _, history := range chats {
+		if _, err := chat.SaveChat(history); err != nil {
+			t.Fatalf("SaveChat() failed: %v", err)
+		}
+	}
+
+	summaries, err := chat.ListChatSummaries()
+	if err != nil {
+		t.Fatalf("ListChatSummaries() failed: %v", err)
+	}
+
+	titles := map[string]float64{}
+	for _, summary := range summaries {
+		titles[summary.Title] = summary.Cost
+	}
+	if cost, ok := titles["Sourdough starter"]; !ok || cost != 0.0125 {
+		t.Errorf("titled chat summary missing or wrong cost: %+v", summaries)
+	}
+	if _, ok := titles["How do I reverse a slice in Go without allocati..."]; !ok {
+		t.Errorf("untitled chat should fall back to its first message: %+v", summaries)
+	}
+}
+
+func TestOrganizeAndTrashChats(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	store, err := chat.OpenBoltStore(filepath.Join(tmpDir, "chats.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore() failed: %v", err)
+	}
+	defer store.Close()
+
+	for _, backend := range []chat.Store{chat.JSONStore{}, store} {
+		chat.SetStore(backend)
+
+		filename, err := chat.SaveChat(chat.ChatHistory{
+			Title:    "Garden plans",
+			Messages: []chat.ChatMessage{{Role: "user", Content: "Which tomatoes grow best in shade?", Timestamp: time.Now()}},
+		})
+		if err != nil {
+			t.Fatalf("SaveChat() failed: %v", err)
+		}
+		id := chat.ChatIDFromFilename(filename)
+
+		before, _ := chat.LoadChat(id)
+		if err := chat.RenameChat(id, "  Tomatoes  "); err != nil {
+			t.Fatalf("RenameChat() failed: %v", err)
+		}
+		if err := chat.SetChatTags(id, chat.ParseTags("#garden, Garden  veg")); err != nil {
+			t.Fatalf("SetChatTags() failed: %v", err)
+		}
+		if err := chat.SetChatPinned(id, true); err != nil {
+			t.Fatalf("SetChatPinned() failed: %v", err)
+		}
+
+		history, err := chat.LoadChat(id)
+		if err != nil {
+			t.Fatalf("LoadChat() failed: %v", err)
+		}
+		if history.Title != "Tomatoes" || !history.Pinned || len(history.Tags) != 2 || history.Tags[1] != "veg" {
+			t.Errorf("metadata not saved: %+v", history)
+		}
+		if !history.UpdatedAt.Equal(before.UpdatedAt) {
+			t.Errorf("organizing a chat changed UpdatedAt from %v to %v", before.UpdatedAt, history.UpdatedAt)
+		}
+
+		if err := chat.TrashChat(id); err != nil {
+			t.Fatalf("TrashChat() failed: %v", err)
+		}
+		if summaries, _ := chat.ListChatSummaries(); len(summaries) != 0 {
+			t.Errorf("trashed chat still listed: %+v", summaries)
+		}
+		if results, _ := chat.SearchChats("tomatoes"); len(results) != 0 {
+			t.Errorf("trashed chat still searchable: %+v", results)
+		}
+
+		trash, err := chat.ListTrash()
+		if err != nil || len(trash) != 1 || trash[0].DeletedAt.IsZero() {
+			t.Fatalf("ListTrash() = %+v, %v", trash, err)
+		}
+
+		if err := chat.RestoreChat(id); err != nil {
+			t.Fatalf("RestoreChat() failed: %v", err)
+		}
+		if results, _ := chat.SearchChats("tomatoes"); len(results) != 1 {
+			t.Errorf("restored chat not searchable: %+v", results)
+		}
+
+		if err := chat.TrashChat(id); err != nil {
+			t.Fatalf("TrashChat() failed: %v", err)
+		}
+		if count, err := chat.EmptyTrash(); err != nil || count != 1 {
+			t.Errorf("EmptyTrash() = %d, %v", count, err)
+		}
+		if trash, _ := chat.ListTrash(); len(trash) != 0 {
+			t.Errorf("trash not empty: %+v", trash)
+		}
+	}
+	chat.SetStore(chat.JSONStore{})
+}
+
+func TestImportConversations(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	// One linear conversation and one where the answer was regenerated
+	chatGPT := `[
+	  {"conversation_id": "c1", "title": "Packing list", "create_time": 1700000000.5, "update_time": 1700000100,
+	   "current_node": "a1", "default_model_slug": "gpt-4",
+	   "mapping": {
+	     "root": {"id": "root", "parent": null, "children": ["s"], "message": null},
+	     "s": {"id": "s", "parent": "root", "children": ["u1"], "message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}, "metadata": {"is_visually_hidden_from_conversation": true}}},
+	     "u1": {"id": "u1", "parent": "s", "children": ["a1"], "message": {"author": {"role": "user"}, "create_time": 1700000001, "content": {"content_type": "text", "parts": ["What should I pack for Iceland?"]}, "metadata": {}}},
+	     "a1": {"id": "a1", "parent": "u1", "children": [], "message": {"author": {"role": "assistant"}, "create_time": 1700000002, "content": {"content_type": "text", "parts": ["Layers and a rain jacket."]}, "metadata": {"model_slug": "gpt-4o"}}}
+	   }},
+	  {"conversation_id": "c2", "title": "Haiku", "create_time": 1700001000, "update_time": 1700002000,
+	   "current_node": "b2",
+	   "mapping": {
+	     "root": {"id": "root", "parent": null, "children": ["q"], "message": null},
+	     "q": {"id": "q", "parent": "root", "children": ["b1", "b2"], "message": {"author": {"role": "user"}, "create_time": 1700001001, "content": {"content_type": "text", "parts": ["Write a haiku"]}, "metadata": {}}},
+	     "b1": {"id": "b1", "parent": "q", "children": [], "message": {"author": {"role": "assistant"}, "create_time": 1700001002, "content": {"content_type": "text", "parts": ["First try"]}, "metadata": {"model_slug": "gpt-4"}}},
+	     "b2": {"id": "b2", "parent": "q", "children": [], "message": {"author": {"role": "assistant"}, "create_time": 1700001500, "content": {"content_type": "text", "parts": ["Second try"]}, "metadata": {"model_slug": "gpt-4"}}}
+	   }}
+	]`
+	claude := `[
+	  {"uuid": "k1", "name": "Sourdough", "created_at": "2024-03-01T10:00:00.000000Z", "updated_at": "2024-03-01T10:05:00.000000Z",
+	   "chat_messages": [
+	     {"uuid": "m1", "sender": "human", "text": "How do I feed a starter?", "created_at": "2024-03-01T10:00:00Z"},
+	     {"uuid": "m2", "sender": "assistant", "text": "", "content": [{"type": "text", "text": "Equal parts flour and water."}], "created_at": "2024-03-01T10:01:00Z"}
+	   ]}
+	]`
+
+	chatGPTPath := filepath.Join(tmpDir, "conversations.json")
+	claudePath := filepath.Join(tmpDir, "claude.json")
+	os.WriteFile(chatGPTPath, []byte(chatGPT), 0644)
+	os.WriteFile(claudePath, []byte(claude), 0644)
+
+	stats, err := chat.ImportConversations(tmpDir) // The unzipped export folder
+	if err != nil {
+		t.Fatalf("ImportConversations() failed: %v", err)
+	}
+	if stats.Chats != 1 || stats.Trees != 1 {
+		t.Errorf("ChatGPT import = %+v, want 1 chat and 1 tree", stats)
+	}
+	if stats, err := chat.ImportConversations(claudePath); err != nil || stats.Chats != 1 {
+		t.Errorf("Claude import = %+v, %v", stats, err)
+	}
+
+	summaries, err := chat.ListChatSummaries()
+	if err != nil || len(summaries) != 2 {
+		t.Fatalf("ListChatSummaries() = %+v, %v", summaries, err)
+	}
+	packing, err := chat.LoadChat(summaries[1].ID)
+	if err != nil {
+		t.Fatalf("LoadChat() failed: %v", err)
+	}
+	if packing.Title != "Packing list" || packing.Source != chat.SourceChatGPT || len(packing.Messages) != 2 {
+		t.Errorf("imported chat = %+v", packing)
+	}
+	if packing.Model != "gpt-4o" || !packing.CreatedAt.Equal(time.Unix(1700000000, 5e8)) {
+		t.Errorf("model or timestamps not kept: %s %v", packing.Model, packing.CreatedAt)
+	}
+	if summaries[0].Title != "Sourdough" || summaries[0].Source != chat.SourceClaude {
+		t.Errorf("Claude chat summary = %+v", summaries[0])
+	}
+
+	trees, err := chat.ListTrees()
+	if err != nil || len(trees) != 1 {
+		t.Fatalf("ListTrees() = %v, %v", trees, err)
+	}
+	tree, err := chat.LoadTree(trees[0])
+	if err != nil {
+		t.Fatalf("LoadTree() failed: %v", err)
+	}
+	if tree.Title != "Haiku" || len(tree.Branches) != 2 {
+		t.Errorf("imported tree = %+v", tree)
+	}
+	if main := tree.Messages(tree.Branches[tree.RootBranchID].Head); len(main) != 2 || main[1].Content != "Second try" {
+		t.Errorf("main branch should follow the open answer: %+v", main)
+	}
+	if first := tree.Children(""); len(first) != 1 || len(tree.Children(first[0].ID)) != 2 {
+		t.Errorf("both answers should follow the shared question, got %d nodes", len(tree.Nodes))
+	}
+
+	// Importing again adds nothing
+	stats, err = chat.ImportConversations(chatGPTPath)
+	if err != nil || stats.Chats != 0 || stats.Trees != 0 || stats.Skipped != 2 {
+		t.Errorf("re-import = %+v, %v", stats, err)
+	}
+}
+
+// colourTree is a conversation tree whose reply was regenerated: the first
+// reply is checkpointed and kept as a sibling of the second.
+type colourTree struct {
+	tree                *chat.ConversationTree
+	checkpoint          *chat.Checkpoint
+	head                string // The regenerated reply
+	question, red, blue chat.ChatMessage
+}
+
+// newColourTree builds a fresh colourTree, so each test can change its own.
+func newColourTree(t *testing.T) colourTree {
+	t.Helper()
+	now := time.Now()
+	c := colourTree{
+		tree:     chat.NewConversationTree(),
+		question: chat.ChatMessage{Role: "user", Content: "Name a colour", Timestamp: now},
+		red:      chat.ChatMessage{Role: "assistant", Content: "Red", Timestamp: now.Add(time.Second)},
+		blue:     chat.ChatMessage{Role: "assistant", Content: "Blue", Timestamp: now.Add(2 * time.Second)},
+	}
+	checkpoint, err := c.tree.CreateCheckpoint("first", "", []chat.ChatMessage{c.question, c.red})
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() failed: %v", err)
+	}
+	c.checkpoint = checkpoint
+	c.head = c.tree.SyncMessages([]chat.ChatMessage{c.question, c.blue})
+	return c
+}
+
+func TestConversationTreeAlternatives(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	c := newColourTree(t)
+	tree := c.tree
+
+	// Regenerating keeps the old reply as a sibling and moves the branch
+	if len(tree.Nodes) != 3 || len(tree.Siblings(c.head)) != 2 || tree.Head() != c.head {
+		t.Errorf("regenerated reply should be a sibling, got %d nodes", len(tree.Nodes))
+	}
+	if messages, _ := tree.LoadFromCheckpoint(c.checkpoint.ID); len(messages) != 2 || messages[1].Content != "Red" {
+		t.Errorf("checkpoint should still hold the first reply: %+v", messages)
+	}
+
+	// Alternatives are saved with the chat
+	filename, err := chat.SaveChat(chat.ChatHistory{Messages: tree.Messages(tree.Head()), Tree: tree})
+	if err != nil {
+		t.Fatalf("SaveChat() failed: %v", err)
+	}
+	saved, err := chat.LoadChat(filename)
+	if err != nil || saved.Tree == nil || len(saved.Tree.Siblings(saved.Tree.Head())) != 2 {
+		t.Errorf("chat should keep its alternatives: %+v, %v", saved, err)
+	}
+}
+
+func TestConversationTreeRenameAndDeleteBranch(t *testing.T) {
+	c := newColourTree(t)
+	tree := c.tree
+
+	branch, err := tree.CreateBranch(c.checkpoint.ID, "red", "")
+	if err != nil || branch.Head != c.checkpoint.NodeID {
+		t.Fatalf("CreateBranch() = %+v, %v", branch, err)
+	}
+	if err := tree.RenameBranch(branch.ID, " red first "); err != nil || branch.Name != "red first" {
+		t.Errorf("RenameBranch() = %v, name %q", err, branch.Name)
+	}
+	if err := tree.DeleteBranch(tree.RootBranchID); err == nil {
+		t.Error("DeleteBranch() should refuse to delete main")
+	}
+	tree.SwitchBranch(branch.ID)
+	if err := tree.DeleteBranch(branch.ID); err != nil || tree.CurrentBranch != tree.RootBranchID || len(tree.Nodes) != 3 {
+		t.Errorf("DeleteBranch() = %v, current %s, %d nodes", err, tree.CurrentBranch, len(tree.Nodes))
+	}
+}
+
+func TestConversationTreeBranchAt(t *testing.T) {
+	c := newColourTree(t)
+	tree := c.tree
+
+	// Branching from a message leaves the current branch where it is
+	questionNode := tree.Path(c.head)[0]
+	fromQuestion, err := tree.BranchAt(questionNode, "again", "")
+	if err != nil || fromQuestion.Head != questionNode || tree.Head() != c.head || len(fromQuestion.Checkpoints) != 1 {
+		t.Errorf("BranchAt() = %+v, %v", fromQuestion, err)
+	}
+	if _, err := tree.BranchAt("missing", "x", ""); err == nil {
+		t.Error("BranchAt() should reject an unknown message")
+	}
+}
+
+func TestConversationTreeCherryPickAndMerge(t *testing.T) {
+	c := newColourTree(t)
+	tree := c.tree
+
+	// Copying the red reply onto main keeps where it came from
+	redNode := c.checkpoint.NodeID
+	picked, err := tree.CherryPick([]string{redNode})
+	if err != nil || tree.Head() != picked || tree.Nodes[picked].PickedFrom != redNode || tree.Nodes[picked].ParentID != c.head {
+		t.Errorf("CherryPick() = %s, %v", picked, err)
+	}
+	merged, err := tree.AddMerge(chat.ChatMessage{Role: "assistant", Content: "Purple", Timestamp: time.Now().Add(time.Minute)}, []string{picked, redNode})
+	if err != nil || tree.Head() != merged.ID || len(merged.MergedFrom) != 2 {
+		t.Errorf("AddMerge() = %+v, %v", merged, err)
+	}
+}
+
+func TestConversationTreeDiff(t *testing.T) {
+	c := newColourTree(t)
+
+	diff, err := c.tree.Diff(c.checkpoint.ID, c.tree.RootBranchID)
+	if err != nil || len(diff.Common) != 1 || diff.Left[0].Content != "Red" || diff.Right[0].Content != "Blue" {
+		t.Errorf("Diff() = %+v, %v", diff, err)
+	}
+
+	lines := chat.DiffLines("func a() {\n\treturn 1\n}", "func a() {\n\tx := 2\n\treturn x\n}")
+	var ops []chat.DiffOp
+	for _, line := range lines {
+		ops = append(ops, line.Op)
+	}
+	want := []chat.DiffOp{chat.DiffEqual, chat.DiffDelete, chat.DiffInsert, chat.DiffInsert, chat.DiffEqual}
+	if fmt.Sprint(ops) != fmt.Sprint(want) {
+		t.Errorf("DiffLines() ops = %v, want %v", ops, want)
+	}
+}
+
+func TestConversationTreeUpgradeAndSummaries(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	c := newColourTree(t)
+	now := time.Now()
+
+	// Trees saved with a full copy of the messages in each checkpoint are upgraded
+	legacy := map[string]any{
+		"root_branch": map[string]any{"id": "branch_1"},
+		"branches": map[string]any{
+			"branch_1": map[string]any{"id": "branch_1", "name": "main", "created_at": now,
+				"checkpoints": []any{map[string]any{"id": "checkpoint_1", "messages": []chat.ChatMessage{c.question, c.red}}}},
+			"branch_2": map[string]any{"id": "branch_2", "name": "other", "created_at": now.Add(time.Minute),
+				"checkpoints": []any{map[string]any{"id": "checkpoint_2", "branch_from": "checkpoint_1", "messages": []chat.ChatMessage{c.question, c.blue}}}},
+		},
+		"current_branch": "branch_1",
+	}
+	data, _ := json.Marshal(legacy)
+	branchDir, _ := chat.GetBranchesDir()
+	if err := os.WriteFile(filepath.Join(branchDir, "tree_old.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded, err := chat.LoadTree("tree_old")
+	if err != nil {
+		t.Fatalf("LoadTree() failed: %v", err)
+	}
+	if upgraded.RootBranchID != "branch_1" || len(upgraded.Nodes) != 3 {
+		t.Errorf("upgraded tree = %+v", upgraded)
+	}
+	if messages, _ := upgraded.LoadFromCheckpoint("checkpoint_2"); len(messages) != 2 || messages[1].Content != "Blue" {
+		t.Errorf("upgraded checkpoint messages = %+v", messages)
+	}
+	if head := upgraded.Branches["branch_2"].Head; len(upgraded.Siblings(head)) != 2 {
+		t.Errorf("branches should share their common messages")
+	}
+
+	c.tree.Title = "Colours"
+	if err := chat.SaveTree(c.tree, "tree_new.json"); err != nil {
+		t.Fatalf("SaveTree() failed: %v", err)
+	}
+	summaries, err := chat.ListTreeSummaries()
+	if err != nil || len(summaries) != 2 {
+		t.Fatalf("ListTreeSummaries() = %+v, %v", summaries, err)
+	}
+	if summaries[0].Filename != "tree_new.json" || summaries[0].Name != "Colours" || summaries[0].Branches != 1 || summaries[0].Messages != 3 {
+		t.Errorf("newest tree summary = %+v", summaries[0])
+	}
+	if summaries[1].Name != "Name a colour" || summaries[1].Branches != 2 {
+		t.Errorf("upgraded tree summary = %+v", summaries[1])
+	}
+}
+
+func TestAutoCheckpoints(t *testing.T) {
+	now := time.Now()
+	question := chat.ChatMessage{Role: "user", Content: "Hello", Timestamp: now}
+
+	tree := chat.NewConversationTree()
+	first, err := tree.CreateAutoCheckpoint("before clearing", []chat.ChatMessage{question})
+	if err != nil || first == nil || !first.Auto {
+		t.Fatalf("CreateAutoCheckpoint() = %+v, %v", first, err)
+	}
+	if again, _ := tree.CreateAutoCheckpoint("before clearing", []chat.ChatMessage{question}); again.ID != first.ID {
+		t.Error("an unchanged conversation should reuse its checkpoint")
+	}
+
+	// Thirty automatic checkpoints made every 20 minutes, plus one made by hand
+	branch := tree.GetCurrentBranch()
+	branch.Checkpoints = []chat.Checkpoint{{ID: "manual", CreatedAt: now.Add(-100 * time.Hour)}}
+	for i := 30; i > 0; i-- {
+		branch.Checkpoints = append(branch.Checkpoints, chat.Checkpoint{
+			ID:        fmt.Sprintf("auto_%d", i),
+			Auto:      true,
+			CreatedAt: now.Add(-time.Duration(i) * 20 * time.Minute),
+		})
+	}
+
+	removed := tree.PruneAutoCheckpoints(now)
+	kept := map[string]bool{}
+	for _, checkpoint := range branch.Checkpoints {
+		kept[checkpoint.ID] = true
+	}
+	if removed == 0 || !kept["manual"] || !kept["auto_1"] || !kept["auto_5"] {
+		t.Errorf("PruneAutoCheckpoints() removed %d, kept %v", removed, kept)
+	}
+	if len(branch.Checkpoints)+removed != 31 || len(branch.Checkpoints) > 1+5+10 {
+		t.Errorf("PruneAutoCheckpoints() kept %d checkpoints", len(branch.Checkpoints))
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	history := chat.ChatHistory{
+		Title:     "Slices <and> maps",
+		BuddyName: "Pal",
+		Model:     "gpt-4o",
+		Messages: []chat.ChatMessage{
+			{Role: "system", Content: "You are helpful.", Timestamp: time.Now()},
+			{Role: "user", Content: "How do I reverse a slice?", Timestamp: time.Now()},
+			{Role: "assistant", Content: "Use **slices.Reverse**:\n\n```go\nslices.Reverse(s)\n```\n<script>alert(1)</script>", Timestamp: time.Now(), Model: "gpt-4o"},
+		},
+	}
+
+	page, err := chat.RenderHTML(history, chat.HTMLOptions{Dark: true, ThemeToggle: true})
+	if err != nil {
+		t.Fatalf("RenderHTML() failed: %v", err)
+	}
+	for _, want := range []string{
+		`<title>Slices &lt;and&gt; maps</title>`,
+		`data-theme="dark"`,
+		`class="theme-toggle"`,
+		`<section class="message system">`,
+		`<strong>slices.Reverse</strong>`,
+		`<pre class="chroma">`,
+		`html[data-theme="dark"] .chroma`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML export missing %q", want)
+		}
+	}
+	if strings.Contains(page, "<script>alert") {
+		t.Error("HTML export kept raw HTML from a message")
+	}
+
+	markdown := chat.RenderMarkdown(history)
+	if !strings.Contains(markdown, "**System**") || strings.Count(markdown, "**Pal**") != 1 {
+		t.Errorf("markdown export mislabels roles:\n%s", markdown)
+	}
+}
+
+func TestJSONLExportAndImport(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", tmpDir)
+
+	now := time.Now()
+	history := &chat.ChatHistory{
+		Settings: &chat.GenerationSettings{SystemPrompt: "You are a support agent."},
+		Messages: []chat.ChatMessage{
+			{Role: "user", Content: "My email is ana@example.com", Timestamp: now},
+			{Role: "user", Content: "and my key is sk-abcdefghijklmnopqrstuvwx", Timestamp: now},
+			{Role: "system", Content: "Chat saved", Timestamp: now},
+			{Role: "assistant", Content: "Thanks, call 555-123-4567 if it persists.", Timestamp: now},
+		},
+	}
+	unanswered := &chat.ChatHistory{Messages: []chat.ChatMessage{{Role: "user", Content: "Hello?", Timestamp: now}}}
+
+	var openai bytes.Buffer
+	written, err := chat.ExportJSONL(&openai, []*chat.ChatHistory{history, unanswered}, chat.JSONLOptions{Redact: true})
+	if err != nil || written != 1 {
+		t.Fatalf("ExportJSONL() = %d, %v", written, err)
+	}
+	var record struct {
+		Messages []struct{ Role, Content string } `json:"messages"`
+	}
+	if err := json.Unmarshal(openai.Bytes(), &record); err != nil {
+		t.Fatalf("invalid OpenAI record: %v", err)
+	}
+	if len(record.Messages) != 4 || record.Messages[0].Role != "system" || record.Messages[1].Content != "My email is [EMAIL]" {
+		t.Errorf("OpenAI record = %+v", record.Messages)
+	}
+	if strings.Contains(openai.String(), "sk-abc") || strings.Contains(openai.String(), "555-123") || strings.Contains(openai.String(), "Chat saved") {
+		t.Errorf("OpenAI record leaks redacted text or notices: %s", openai.String())
+	}
+
+	var anthropic bytes.Buffer
+	if _, err := chat.ExportJSONL(&anthropic, []*chat.ChatHistory{history}, chat.JSONLOptions{Format: chat.FormatAnthropic, StripSystem: true}); err != nil {
+		t.Fatalf("ExportJSONL() failed: %v", err)
+	}
+	var claudeRecord struct {
+		System   string                           `json:"system"`
+		Messages []struct{ Role, Content string } `json:"messages"`
+	}
+	if err := json.Unmarshal(anthropic.Bytes(), &claudeRecord); err != nil {
+		t.Fatalf("invalid Anthropic record: %v", err)
+	}
+	if claudeRecord.System != "" || len(claudeRecord.Messages) != 2 || claudeRecord.Messages[0].Role != "user" {
+		t.Errorf("Anthropic record should merge turns and drop the system prompt: %+v", claudeRecord)
+	}
+
+	stats, err := chat.ImportJSONL(strings.NewReader(openai.String() + "\n" + anthropic.String()))
+	if err != nil || stats.Chats != 2 {
+		t.Fatalf("ImportJSONL() = %+v, %v", stats, err)
+	}
+	if stats, err := chat.ImportJSONL(&openai); err != nil || stats.Chats != 0 || stats.Skipped != 1 {
+		t.Errorf("re-import = %+v, %v", stats, err)
+	}
+
+	summaries, _ := chat.ListChatSummaries()
+	var imported *chat.ChatHistory
+	for _, summary := range summaries {
+		if loaded, err := chat.LoadChat(summary.ID); err == nil && loaded.Settings != nil {
+			imported = loaded
+		}
+	}
+	if imported == nil || imported.Settings.SystemPrompt != "You are a support agent." || len(imported.Messages) != 3 {
+		t.Errorf("imported chat = %+v", imported)
+	}
+}
+
+func TestWrapDisplayWidth(t *testing.T) {
+	// Emoji take two cells and escape codes none
+	if got := wrap.Truncate("🏴‍☠️ Ahoy matey", 6, "…"); got != "🏴‍☠️ Ah…" || wrap.Width(got) != 6 {
+		t.Errorf("Truncate(emoji) = %q, width %d", got, wrap.Width(got))
+	}
+	if got := wrap.Truncate("\x1b[1mbold text\x1b[0m", 6, "…"); got != "\x1b[1mbold …\x1b[0m" {
+		t.Errorf("Truncate(styled) = %q", got)
+	}
+	if got := wrap.Wrap("日本語のテキスト", 6); got != "日本語\nのテキ\nスト" {
+		t.Errorf("Wrap(CJK) = %q", got)
+	}
+	if got := wrap.Pad("日本", 6); got != "日本  " {
+		t.Errorf("Pad() = %q", got)
+	}
+
+	name := chat.GenerateCheckpointName([]chat.ChatMessage{{Role: "user", Content: strings.Repeat("🦜 ", 30)}})
+	if !strings.HasSuffix(name, "...") || wrap.Width(name) > 50 || !utf8.ValidString(name) {
+		t.Errorf("GenerateCheckpointName() = %q", name)
+	}
+}
+
+func TestClipboardOSC52(t *testing.T) {
+	if got := clipboard.ParseMethod(" OSC52 "); got != clipboard.OSC52 {
+		t.Errorf("ParseMethod(OSC52) = %q", got)
+	}
+	if got := clipboard.ParseMethod("pbcopy"); got != clipboard.Auto {
+		t.Errorf("ParseMethod(unknown) = %q", got)
+	}
+
+	// Over SSH the terminal is the only clipboard that reaches the user
+	t.Setenv("SSH_CONNECTION", "10.0.0.1 22 10.0.0.2 22")
+	if got := clipboard.Detect(); got != clipboard.OSC52 {
+		t.Errorf("Detect() over SSH = %q", got)
+	}
+
+	var out bytes.Buffer
+	clipboard.Output = &out
+	defer func() { clipboard.Output = os.Stdout }()
+
+	t.Setenv("TMUX", "")
+	t.Setenv("TERM", "xterm-256color")
+	if method, err := clipboard.Copy("hello", clipboard.Auto); err != nil || method != clipboard.OSC52 || out.String() != "\x1b]52;c;aGVsbG8=\x07" {
+		t.Errorf("Copy() = %q, %v, wrote %q", method, err, out.String())
+	}
+
+	// tmux and screen need the sequence wrapped to pass it on
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1234,0")
+	if got := clipboard.Sequence("hello"); !strings.HasPrefix(got, "\x1bPtmux;\x1b\x1b]52;c;aGVsbG8=") {
+		t.Errorf("Sequence() in tmux = %q", got)
+	}
+	t.Setenv("TMUX", "")
+	t.Setenv("TERM", "screen-256color")
+	if got := clipboard.Sequence("hello"); !strings.HasPrefix(got, "\x1bP\x1b]52;c;aGVsbG8=") {
+		t.Errorf("Sequence() in screen = %q", got)
+	}
+}